@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/floriangaechter/rss/internal/config"
+	"github.com/floriangaechter/rss/internal/store"
+)
+
+func runDB(args []string) error {
+	sub, args, err := subcommand("db", args, "vacuum")
+	if err != nil {
+		return err
+	}
+
+	var cfg config.Config
+	fs := newFlagSet("db "+sub, &cfg)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, closeApp, err := openApp(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	switch sub {
+	case "vacuum":
+		if err := store.Vacuum(a.DB); err != nil {
+			return err
+		}
+		fmt.Println("vacuumed database")
+	}
+
+	return nil
+}
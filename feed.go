@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/floriangaechter/rss/internal/app"
+	"github.com/floriangaechter/rss/internal/config"
+	"github.com/floriangaechter/rss/internal/store"
+)
+
+func runFeed(args []string) error {
+	sub, args, err := subcommand("feed", args, "add", "list", "refresh")
+	if err != nil {
+		return err
+	}
+
+	var cfg config.Config
+	fs := newFlagSet("feed "+sub, &cfg)
+	var username, title, category string
+	switch sub {
+	case "add":
+		fs.StringVar(&username, "user", "", "Owner of the feed")
+		fs.StringVar(&title, "title", "", "Feed title, taken from the feed when empty")
+		fs.StringVar(&category, "category", "", "Category to file the feed under")
+	case "list":
+		fs.StringVar(&username, "user", "", "Only list feeds of this user")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, closeApp, err := openApp(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	ctx := context.Background()
+
+	switch sub {
+	case "add":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("%w: rss feed add -user <username> [-title <title>] [-category <category>] <url>", errUsage)
+		}
+		user, err := lookupUser(a, username)
+		if err != nil {
+			return err
+		}
+
+		feed, err := addFeed(ctx, a, user, fs.Arg(0), title, category)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("added feed %q with id %d\n", feed.Title, feed.ID)
+
+		// The feed is there either way, the scheduler tries again later
+		if err := a.Fetcher.FetchFeedItems(ctx, int64(feed.ID)); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: fetching feed %d failed: %v\n", feed.ID, err)
+		}
+		return nil
+
+	case "list":
+		return listFeeds(a, username)
+
+	case "refresh":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("%w: rss feed refresh <id|all>", errUsage)
+		}
+		return refreshFeeds(ctx, a, fs.Arg(0))
+	}
+
+	return nil
+}
+
+// addFeed subscribes user to link. Without a title the one advertised by the
+// feed is used.
+func addFeed(ctx context.Context, a *app.Application, user *store.User, link, title, category string) (*store.Feed, error) {
+	feed := &store.Feed{
+		UserID:   user.ID,
+		Title:    title,
+		Link:     link,
+		Category: category,
+	}
+
+	if title == "" {
+		discovered, err := a.Fetcher.Discover(ctx, link)
+		if err != nil {
+			return nil, fmt.Errorf("reading feed %s: %w", link, err)
+		}
+		feed.Title = discovered.Title
+		feed.Description = discovered.Description
+	}
+	if feed.Title == "" {
+		feed.Title = link
+	}
+
+	return a.FeedStore.CreateFeed(feed)
+}
+
+func listFeeds(a *app.Application, username string) error {
+	users, err := a.UserStore.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSER\tUNREAD\tTITLE\tLINK")
+	for _, user := range users {
+		if username != "" && user.Username != username {
+			continue
+		}
+
+		feeds, err := a.FeedStore.GetFeedsByUserID(int64(user.ID))
+		if err != nil {
+			return err
+		}
+		for _, feed := range feeds {
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n", feed.ID, user.Username, feed.UnreadCount, feed.Title, feed.Link)
+		}
+	}
+	return tw.Flush()
+}
+
+func refreshFeeds(ctx context.Context, a *app.Application, target string) error {
+	var feedIDs []int64
+	if target == "all" {
+		feeds, err := a.FeedStore.GetAllFeeds()
+		if err != nil {
+			return err
+		}
+		for _, feed := range feeds {
+			feedIDs = append(feedIDs, int64(feed.ID))
+		}
+	} else {
+		feedID, err := strconv.ParseInt(target, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid feed id %q", errUsage, target)
+		}
+		feedIDs = append(feedIDs, feedID)
+	}
+
+	// Keep going on failures, the fetcher logs each of them
+	var failed int
+	for _, feedID := range feedIDs {
+		if err := a.Fetcher.FetchFeedItems(ctx, feedID); err != nil {
+			failed++
+		}
+	}
+
+	fmt.Printf("refreshed %d of %d feeds\n", len(feedIDs)-failed, len(feedIDs))
+	if failed > 0 {
+		return errors.New("some feeds failed to refresh")
+	}
+	return nil
+}
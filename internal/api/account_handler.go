@@ -0,0 +1,421 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/mailer"
+	"github.com/floriangaechter/rss/internal/middleware"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/views"
+)
+
+// exportPageSize is the number of items read at a time for an export
+const exportPageSize = 500
+
+// accountMessages are shown after a redirect back to the account page
+var accountMessages = map[string]string{
+	"username": "Username changed",
+	"password": "Password changed, all other devices have been signed out",
+	"email":    "Email address saved",
+}
+
+type accountData struct {
+	views.Page
+	Email string
+}
+
+type AccountHandler struct {
+	userStore     store.UserStore
+	sessionStore  store.SessionStore
+	settingsStore store.UserSettingsStore
+	feedStore     store.FeedStore
+	feedItemStore store.FeedItemStore
+	auditStore    store.AuditStore
+	// The stores below are only read for the export
+	webhookStore  store.WebhookStore
+	alertStore    store.AlertStore
+	digestStore   store.DigestStore
+	identityStore store.IdentityStore
+	// mailer tells the previous address about a new one, it is nil when
+	// sending email isn't configured
+	mailer   mailer.Mailer
+	renderer *views.Renderer
+	logger   *slog.Logger
+
+	sending sync.WaitGroup
+}
+
+func NewAccountHandler(userStore store.UserStore, sessionStore store.SessionStore, settingsStore store.UserSettingsStore, feedStore store.FeedStore, feedItemStore store.FeedItemStore, auditStore store.AuditStore, webhookStore store.WebhookStore, alertStore store.AlertStore, digestStore store.DigestStore, identityStore store.IdentityStore, mailer mailer.Mailer, renderer *views.Renderer, logger *slog.Logger) *AccountHandler {
+	return &AccountHandler{
+		userStore:     userStore,
+		sessionStore:  sessionStore,
+		settingsStore: settingsStore,
+		feedStore:     feedStore,
+		feedItemStore: feedItemStore,
+		auditStore:    auditStore,
+		webhookStore:  webhookStore,
+		alertStore:    alertStore,
+		digestStore:   digestStore,
+		identityStore: identityStore,
+		mailer:        mailer,
+		renderer:      renderer,
+		logger:        logger,
+	}
+}
+
+func (ah *AccountHandler) HandleAccount(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var flash *views.Flash
+	if message, ok := accountMessages[r.URL.Query().Get("changed")]; ok {
+		flash = views.SuccessFlash(message)
+	}
+	ah.render(w, r, http.StatusOK, user, flash)
+}
+
+func (ah *AccountHandler) HandleChangeUsername(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	if err := ValidateUsername(username); err != nil {
+		ah.render(w, r, http.StatusUnprocessableEntity, user, views.ErrorFlash(err.Error()))
+		return
+	}
+
+	existing, err := ah.userStore.GetUserByUsername(username)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "GetUserByUsername", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil && existing.ID != user.ID {
+		ah.render(w, r, http.StatusConflict, user, views.ErrorFlash("username is already taken"))
+		return
+	}
+
+	user.Username = username
+	err = ah.userStore.UpdateUser(user)
+	if errors.Is(err, store.ErrUsernameTaken) {
+		ah.render(w, r, http.StatusConflict, user, views.ErrorFlash("username is already taken"))
+		return
+	}
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "UpdateUser", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/account?changed=username", http.StatusSeeOther)
+}
+
+// HandleChangeEmail sets the address password reset links are sent to, an
+// empty one removes it. It takes the current password, as whoever controls
+// the address controls the account, and the previous address is told.
+func (ah *AccountHandler) HandleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	email := NormalizeEmail(r.FormValue("email"))
+	if err := ValidateEmail(email); err != nil {
+		ah.render(w, r, http.StatusUnprocessableEntity, user, views.ErrorFlash(err.Error()))
+		return
+	}
+	// Behind an authenticating proxy there is no session and no password,
+	// the proxy vouches for the user
+	if utils.GetSessionFromContext(r) != nil && !ah.checkPassword(w, r, user, r.FormValue("current_password")) {
+		return
+	}
+
+	previous := user.Email
+	if email == previous {
+		http.Redirect(w, r, "/account?changed=email", http.StatusSeeOther)
+		return
+	}
+	user.Email = email
+	err := ah.userStore.UpdateUser(user)
+	if errors.Is(err, store.ErrEmailTaken) {
+		user.Email = previous
+		ah.render(w, r, http.StatusConflict, user, views.ErrorFlash(err.Error()))
+		return
+	}
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "UpdateUser", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := ah.auditStore.RecordAuditEvent(&store.AuditEvent{
+		Event:     store.AuditEmailChanged,
+		UserID:    user.ID,
+		Username:  user.Username,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    fmt.Sprintf("from %q to %q", previous, email),
+	}); err != nil {
+		ah.logger.ErrorContext(r.Context(), "recording audit event", "error", err)
+	}
+	if previous != "" && ah.mailer != nil {
+		ah.sendEmailChanged(r, user, previous)
+	}
+
+	http.Redirect(w, r, "/account?changed=email", http.StatusSeeOther)
+}
+
+// sendEmailChanged tells the previous address of the user about the change
+// in the background
+func (ah *AccountHandler) sendEmailChanged(r *http.Request, user *store.User, previous string) {
+	now := "removed"
+	if user.Email != "" {
+		now = "changed to " + user.Email
+	}
+	msg := &mailer.Message{
+		To:      previous,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf(`Hi %s,
+
+the email address of your account was %s. Password reset links go
+there from now on.
+
+If you didn't change it, sign in, change your password and set your
+address again.
+`, user.Username, now),
+	}
+	ah.sending.Add(1)
+	go func() {
+		defer ah.sending.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), mailTimeout)
+		defer cancel()
+		if err := ah.mailer.Send(ctx, msg); err != nil {
+			ah.logger.ErrorContext(ctx, "sending email change notice", "user_id", user.ID, "error", err)
+		}
+	}()
+}
+
+// Wait blocks until all emails being sent are out
+func (ah *AccountHandler) Wait() {
+	ah.sending.Wait()
+}
+
+// HandleChangePassword sets a new password and signs out every other
+// session of the user. The current one gets a new token.
+func (ah *AccountHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Other devices are signed out and this one gets a new token
+	session := utils.GetSessionFromContext(r)
+	if session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	newPassword := r.FormValue("new_password")
+	if err := ValidatePassword(newPassword, user.Username); err != nil {
+		ah.render(w, r, http.StatusUnprocessableEntity, user, views.ErrorFlash(err.Error()))
+		return
+	}
+	if newPassword != r.FormValue("confirm_password") {
+		ah.render(w, r, http.StatusUnprocessableEntity, user, views.ErrorFlash("new passwords do not match"))
+		return
+	}
+
+	if !ah.checkPassword(w, r, user, r.FormValue("current_password")) {
+		return
+	}
+
+	if err := user.Password.Set(newPassword); err != nil {
+		ah.logger.ErrorContext(r.Context(), "hashing password", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := ah.userStore.UpdateUser(user); err != nil {
+		ah.logger.ErrorContext(r.Context(), "UpdateUser", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := ah.sessionStore.DeleteOtherUserSessions(user.ID, session.Token); err != nil {
+		ah.logger.ErrorContext(r.Context(), "DeleteOtherUserSessions", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := ah.sessionStore.RotateSession(session); err != nil {
+		ah.logger.ErrorContext(r.Context(), "RotateSession", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	middleware.SetSessionCookie(w, session)
+
+	http.Redirect(w, r, "/account?changed=password", http.StatusSeeOther)
+}
+
+// HandleDeleteAccount removes the user after they confirmed with their
+// password and username. Feeds, items, settings and sessions go with the
+// user via ON DELETE CASCADE.
+func (ah *AccountHandler) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.FormValue("confirm") != user.Username {
+		ah.render(w, r, http.StatusUnprocessableEntity, user, views.ErrorFlash("type your username to confirm"))
+		return
+	}
+	if !ah.checkPassword(w, r, user, r.FormValue("password")) {
+		return
+	}
+
+	if err := ah.userStore.DeleteUser(user.ID); err != nil {
+		ah.logger.ErrorContext(r.Context(), "DeleteUser", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	ah.logger.InfoContext(r.Context(), "account deleted", "user_id", user.ID)
+
+	middleware.ClearSessionCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// HandleExport downloads everything stored for the user as JSON
+func (ah *AccountHandler) HandleExport(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	export, err := ah.export(user)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "HandleExport", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="rss-export-`+time.Now().UTC().Format("2006-01-02")+`.json"`)
+	_ = utils.WriteJSON(w, http.StatusOK, export)
+}
+
+// export collects everything stored about the user. Secrets like the
+// password, session tokens and webhook secrets are left out.
+func (ah *AccountHandler) export(user *store.User) (utils.Envelope, error) {
+	settings, err := ah.settingsStore.GetUserSettings(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("GetUserSettings: %w", err)
+	}
+	feeds, err := ah.feedStore.GetFeedsByUserID(int64(user.ID))
+	if err != nil {
+		return nil, fmt.Errorf("GetFeedsByUserID: %w", err)
+	}
+
+	items := []*store.FeedItem{}
+	for offset := 0; ; offset += exportPageSize {
+		page, err := ah.feedItemStore.ListFeedItems(int64(user.ID), store.FeedItemFilter{Limit: exportPageSize, Offset: offset})
+		if err != nil {
+			return nil, fmt.Errorf("ListFeedItems: %w", err)
+		}
+		items = append(items, page...)
+		if len(page) < exportPageSize {
+			break
+		}
+	}
+
+	digest, err := ah.digestStore.GetDigest(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("GetDigest: %w", err)
+	}
+	webhooks, err := ah.webhookStore.ListWebhooks(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("ListWebhooks: %w", err)
+	}
+	notifiers, err := ah.alertStore.ListNotifiers(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("ListNotifiers: %w", err)
+	}
+	alertRules, err := ah.alertStore.ListAlertRules(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("ListAlertRules: %w", err)
+	}
+	identities, err := ah.identityStore.ListUserIdentities(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("ListUserIdentities: %w", err)
+	}
+	sessions, err := ah.sessionStore.ListUserSessions(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("ListUserSessions: %w", err)
+	}
+
+	return utils.Envelope{
+		"user":       user,
+		"settings":   settings,
+		"feeds":      feeds,
+		"items":      items,
+		"digest":     digest,
+		"webhooks":   webhooks,
+		"notifiers":  notifiers,
+		"alertRules": alertRules,
+		"identities": identities,
+		"sessions":   sessions,
+	}, nil
+}
+
+// checkPassword renders the account page with an error unless password is
+// the user's current one
+func (ah *AccountHandler) checkPassword(w http.ResponseWriter, r *http.Request, user *store.User, password string) bool {
+	matches, err := user.Password.Matches(password)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "checking password", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !matches {
+		ah.render(w, r, http.StatusUnprocessableEntity, user, views.ErrorFlash("current password is incorrect"))
+		return false
+	}
+	return true
+}
+
+func (ah *AccountHandler) render(w http.ResponseWriter, r *http.Request, status int, user *store.User, flash *views.Flash) {
+	settings, err := ah.settingsStore.GetUserSettings(user.ID)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "GetUserSettings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := accountData{
+		Page:  userPage(r, "Account", user, settings),
+		Email: user.Email,
+	}
+	data.Flash = flash
+
+	w.WriteHeader(status)
+	err = ah.renderer.Render(w, "account", data)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "HandleAccount", "error", err)
+		return
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/floriangaechter/rss/internal/fetcher"
+	"github.com/floriangaechter/rss/internal/middleware"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/views"
+)
+
+const (
+	adminFeedsLimit  = 10
+	adminEventsLimit = 20
+)
+
+// adminMessages are shown after a redirect back to the admin page
+var adminMessages = map[string]string{
+	"disabled":  "The user has been disabled and signed out",
+	"enabled":   "The user has been enabled",
+	"deleted":   "The user has been deleted",
+	"refreshed": "The feed has been refreshed",
+}
+
+// adminErrors are shown after a redirect back to the admin page
+var adminErrors = map[string]string{
+	"self":    "You can't disable or delete yourself",
+	"refresh": "The feed could not be refreshed, see the failing feeds",
+}
+
+// AdminHandler serves the admin page. Its routes are behind RequireAdmin.
+type AdminHandler struct {
+	adminStore    store.AdminStore
+	userStore     store.UserStore
+	sessionStore  store.SessionStore
+	settingsStore store.UserSettingsStore
+	feedStore     store.FeedStore
+	auditStore    store.AuditStore
+	fetcher       *fetcher.Fetcher
+	renderer      *views.Renderer
+	logger        *slog.Logger
+}
+
+func NewAdminHandler(adminStore store.AdminStore, userStore store.UserStore, sessionStore store.SessionStore, settingsStore store.UserSettingsStore, feedStore store.FeedStore, auditStore store.AuditStore, fetcher *fetcher.Fetcher, renderer *views.Renderer, logger *slog.Logger) *AdminHandler {
+	return &AdminHandler{
+		adminStore:    adminStore,
+		userStore:     userStore,
+		sessionStore:  sessionStore,
+		settingsStore: settingsStore,
+		feedStore:     feedStore,
+		auditStore:    auditStore,
+		fetcher:       fetcher,
+		renderer:      renderer,
+		logger:        logger,
+	}
+}
+
+type adminData struct {
+	views.Page
+	Users        []*store.UserStats
+	PopularFeeds []*store.FeedSubscriptions
+	FailingFeeds []*store.FailingFeed
+	Events       []*store.AuditEvent
+	DatabaseSize int64
+}
+
+func (ah *AdminHandler) HandleAdmin(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := ah.settingsStore.GetUserSettings(user.ID)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "GetUserSettings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := adminData{Page: userPage(r, "Admin", user, settings)}
+	if data.Users, err = ah.adminStore.ListUserStats(); err != nil {
+		ah.logger.ErrorContext(r.Context(), "ListUserStats", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if data.PopularFeeds, err = ah.adminStore.MostSubscribedFeeds(adminFeedsLimit); err != nil {
+		ah.logger.ErrorContext(r.Context(), "MostSubscribedFeeds", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if data.FailingFeeds, err = ah.adminStore.FailingFeeds(adminFeedsLimit); err != nil {
+		ah.logger.ErrorContext(r.Context(), "FailingFeeds", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if data.Events, err = ah.auditStore.ListAuditEvents(adminEventsLimit); err != nil {
+		ah.logger.ErrorContext(r.Context(), "ListAuditEvents", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if data.DatabaseSize, err = ah.adminStore.DatabaseSize(); err != nil {
+		ah.logger.ErrorContext(r.Context(), "DatabaseSize", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	if message, ok := adminMessages[query.Get("changed")]; ok {
+		data.Flash = views.SuccessFlash(message)
+	} else if message, ok := adminErrors[query.Get("error")]; ok {
+		data.Flash = views.ErrorFlash(message)
+	}
+
+	err = ah.renderer.Render(w, "admin", data)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "HandleAdmin", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleDisableUser keeps the user from signing in and signs them out
+func (ah *AdminHandler) HandleDisableUser(w http.ResponseWriter, r *http.Request) {
+	ah.changeUser(w, r, store.AuditUserDisabled, "disabled", func(target *store.User) error {
+		if err := ah.userStore.SetUserDisabled(target.ID, true); err != nil {
+			return err
+		}
+		return ah.sessionStore.DeleteUserSessions(target.ID)
+	})
+}
+
+func (ah *AdminHandler) HandleEnableUser(w http.ResponseWriter, r *http.Request) {
+	ah.changeUser(w, r, store.AuditUserEnabled, "enabled", func(target *store.User) error {
+		return ah.userStore.SetUserDisabled(target.ID, false)
+	})
+}
+
+// HandleDeleteUser removes the user with all their data, see
+// AccountHandler.HandleDeleteAccount
+func (ah *AdminHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	ah.changeUser(w, r, store.AuditUserDeleted, "deleted", func(target *store.User) error {
+		return ah.userStore.DeleteUser(target.ID)
+	})
+}
+
+// changeUser applies change to the user of the id param, records event in
+// the audit log and redirects with the changed message. Admins can't change
+// themselves, so there is always one admin left.
+func (ah *AdminHandler) changeUser(w http.ResponseWriter, r *http.Request, event, changed string, change func(*store.User) error) {
+	admin := utils.GetUserFromContext(r)
+	if admin == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := utils.ReadIDParam(r)
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+	if int(userID) == admin.ID {
+		http.Redirect(w, r, "/admin?error=self", http.StatusSeeOther)
+		return
+	}
+
+	target, err := ah.userStore.GetUserByID(int(userID))
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "GetUserByID", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if target == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	err = change(target)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "changing user", "event", event, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The target may be gone, the username tells who it was
+	auditEvent := &store.AuditEvent{
+		Event:     event,
+		Username:  target.Username,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    "by " + admin.Username,
+	}
+	if event != store.AuditUserDeleted {
+		auditEvent.UserID = target.ID
+	}
+	if err := ah.auditStore.RecordAuditEvent(auditEvent); err != nil {
+		ah.logger.ErrorContext(r.Context(), "recording audit event", "error", err)
+	}
+	ah.logger.InfoContext(r.Context(), "user changed by admin", "event", event, "target_user_id", target.ID)
+
+	http.Redirect(w, r, "/admin?changed="+changed, http.StatusSeeOther)
+}
+
+// HandleRefreshFeed fetches a feed of any user right away
+func (ah *AdminHandler) HandleRefreshFeed(w http.ResponseWriter, r *http.Request) {
+	feedID, err := utils.ReadIDParam(r)
+	if err != nil {
+		http.Error(w, "Invalid feed id", http.StatusBadRequest)
+		return
+	}
+
+	feed, err := ah.feedStore.GetFeedByID(feedID)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "GetFeedByID", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if feed == nil {
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
+	}
+
+	// Failures are logged and recorded on the feed by the fetcher
+	if err := ah.fetcher.FetchFeedItems(r.Context(), feedID); err != nil {
+		http.Redirect(w, r, "/admin?error=refresh", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin?changed=refreshed", http.StatusSeeOther)
+}
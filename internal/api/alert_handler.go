@@ -0,0 +1,348 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/floriangaechter/rss/internal/notify"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+)
+
+const maxAlertNameLength = 100
+
+// AlertHandler lets users manage the notifiers and rules alerting them of
+// new items
+type AlertHandler struct {
+	alertStore store.AlertStore
+	feedStore  store.FeedStore
+	alerter    *notify.Alerter
+	logger     *slog.Logger
+}
+
+func NewAlertHandler(alertStore store.AlertStore, feedStore store.FeedStore, alerter *notify.Alerter, logger *slog.Logger) *AlertHandler {
+	return &AlertHandler{
+		alertStore: alertStore,
+		feedStore:  feedStore,
+		alerter:    alerter,
+		logger:     logger,
+	}
+}
+
+type createNotifierRequest struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	Room  string `json:"room"`
+}
+
+func (req *createNotifierRequest) validate() error {
+	if req.Name == "" || len(req.Name) > maxAlertNameLength {
+		return errors.New("name must be between 1 and 100 characters")
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	switch req.Kind {
+	case store.NotifierSlack, store.NotifierNtfy:
+	case store.NotifierMatrix:
+		if req.Token == "" || req.Room == "" {
+			return errors.New("matrix needs a token and a room")
+		}
+	case store.NotifierGotify:
+		if req.Token == "" {
+			return errors.New("gotify needs a token")
+		}
+	default:
+		return errors.New("kind must be slack, matrix, ntfy or gotify")
+	}
+	return nil
+}
+
+// HandleCreateNotifier returns the new notifier, without its token
+func (ah *AlertHandler) HandleCreateNotifier(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	var req createNotifierRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ah.logger.ErrorContext(r.Context(), "decoding HandleCreateNotifier", "error", err)
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Room = strings.TrimSpace(req.Room)
+	if err := req.validate(); err != nil {
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	notifier := &store.Notifier{
+		UserID: user.ID,
+		Name:   req.Name,
+		Kind:   req.Kind,
+		URL:    req.URL,
+		Token:  req.Token,
+		Room:   req.Room,
+	}
+	if err := ah.alertStore.CreateNotifier(notifier); err != nil {
+		ah.logger.ErrorContext(r.Context(), "CreateNotifier", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	ah.logger.InfoContext(r.Context(), "notifier created", "notifier_id", notifier.ID, "kind", notifier.Kind)
+
+	_ = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"notifier": notifier})
+}
+
+func (ah *AlertHandler) HandleListNotifiers(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	notifiers, err := ah.alertStore.ListNotifiers(user.ID)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "ListNotifiers", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"notifiers": notifiers})
+}
+
+// userNotifier returns the notifier of the id parameter if it is the
+// user's, otherwise it writes the error response and returns nil
+func (ah *AlertHandler) userNotifier(w http.ResponseWriter, r *http.Request) *store.Notifier {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return nil
+	}
+
+	notifierID, err := utils.ReadIDParam(r)
+	if err != nil {
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid notifier id"})
+		return nil
+	}
+	notifier, err := ah.alertStore.GetNotifier(int(notifierID))
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "GetNotifier", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil
+	}
+	if notifier == nil || notifier.UserID != user.ID {
+		_ = utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "notifier not found"})
+		return nil
+	}
+
+	return notifier
+}
+
+// HandleDeleteNotifier deletes the notifier along with the rules using it
+func (ah *AlertHandler) HandleDeleteNotifier(w http.ResponseWriter, r *http.Request) {
+	notifier := ah.userNotifier(w, r)
+	if notifier == nil {
+		return
+	}
+
+	err := ah.alertStore.DeleteNotifier(notifier.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "notifier not found"})
+		return
+	}
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "DeleteNotifier", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleTestNotifier sends a test notification and returns whether it went
+// through
+func (ah *AlertHandler) HandleTestNotifier(w http.ResponseWriter, r *http.Request) {
+	notifier := ah.userNotifier(w, r)
+	if notifier == nil {
+		return
+	}
+
+	if err := ah.alerter.SendTest(r.Context(), notifier); err != nil {
+		ah.logger.WarnContext(r.Context(), "SendTest", "notifier_id", notifier.ID, "error", err)
+		_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"ok": false, "error": err.Error()})
+		return
+	}
+
+	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"ok": true})
+}
+
+type createAlertRuleRequest struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Regex   bool   `json:"regex"`
+	// Field is "any" if empty
+	Field      string `json:"field"`
+	NotifierID int    `json:"notifierId"`
+	// FeedIDs limits the rule to these feeds, it looks at all feeds if
+	// empty
+	FeedIDs []int `json:"feedIds"`
+}
+
+func (req *createAlertRuleRequest) validate() error {
+	if req.Name == "" || len(req.Name) > maxAlertNameLength {
+		return errors.New("name must be between 1 and 100 characters")
+	}
+	if req.Pattern == "" || len(req.Pattern) > notify.MaxPatternLength {
+		return errors.New("pattern must be between 1 and 200 characters")
+	}
+	switch req.Field {
+	case store.AlertFieldAny, store.AlertFieldTitle, store.AlertFieldBody:
+	default:
+		return errors.New("field must be any, title or body")
+	}
+	return nil
+}
+
+// HandleCreateAlertRule returns the new rule. A rule without feeds looks at
+// all feeds of the user.
+func (ah *AlertHandler) HandleCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	var req createAlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ah.logger.ErrorContext(r.Context(), "decoding HandleCreateAlertRule", "error", err)
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if !req.Regex {
+		req.Pattern = strings.TrimSpace(req.Pattern)
+	}
+	if req.Field == "" {
+		req.Field = store.AlertFieldAny
+	}
+	if err := req.validate(); err != nil {
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	rule := &store.AlertRule{
+		UserID:     user.ID,
+		Name:       req.Name,
+		Pattern:    req.Pattern,
+		Regex:      req.Regex,
+		Field:      req.Field,
+		NotifierID: req.NotifierID,
+		AllFeeds:   len(req.FeedIDs) == 0,
+		FeedIDs:    slices.Compact(slices.Sorted(slices.Values(req.FeedIDs))),
+	}
+	if _, err := notify.NewMatcher(rule); err != nil {
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid regex: " + err.Error()})
+		return
+	}
+
+	notifier, err := ah.alertStore.GetNotifier(req.NotifierID)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "GetNotifier", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if notifier == nil || notifier.UserID != user.ID {
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "notifier not found"})
+		return
+	}
+
+	for _, feedID := range rule.FeedIDs {
+		feed, err := ah.feedStore.GetFeedByID(int64(feedID))
+		if err != nil {
+			ah.logger.ErrorContext(r.Context(), "GetFeedByID", "error", err)
+			_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		if feed == nil || feed.UserID != user.ID {
+			_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "feed not found"})
+			return
+		}
+	}
+
+	if err := ah.alertStore.CreateAlertRule(rule); err != nil {
+		ah.logger.ErrorContext(r.Context(), "CreateAlertRule", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	ah.logger.InfoContext(r.Context(), "alert rule created", "rule_id", rule.ID)
+
+	_ = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"rule": rule})
+}
+
+func (ah *AlertHandler) HandleListAlertRules(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	rules, err := ah.alertStore.ListAlertRules(user.ID)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "ListAlertRules", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"rules": rules})
+}
+
+func (ah *AlertHandler) HandleDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	ruleID, err := utils.ReadIDParam(r)
+	if err != nil {
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid rule id"})
+		return
+	}
+	rule, err := ah.alertStore.GetAlertRule(int(ruleID))
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "GetAlertRule", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	// Rules of other users don't exist as far as the user is concerned
+	if rule == nil || rule.UserID != user.ID {
+		_ = utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "rule not found"})
+		return
+	}
+
+	err = ah.alertStore.DeleteAlertRule(rule.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "rule not found"})
+		return
+	}
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "DeleteAlertRule", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
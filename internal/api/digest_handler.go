@@ -0,0 +1,190 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/digest"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/views"
+)
+
+// maxDigestItems is the most items a digest can list
+const maxDigestItems = 100
+
+// DigestHandler lets users choose when they get a digest email and what is
+// in it. It is only routed when sending email is configured.
+type DigestHandler struct {
+	digestStore   store.DigestStore
+	feedStore     store.FeedStore
+	settingsStore store.UserSettingsStore
+	renderer      *views.Renderer
+	logger        *slog.Logger
+}
+
+func NewDigestHandler(digestStore store.DigestStore, feedStore store.FeedStore, settingsStore store.UserSettingsStore, renderer *views.Renderer, logger *slog.Logger) *DigestHandler {
+	return &DigestHandler{
+		digestStore:   digestStore,
+		feedStore:     feedStore,
+		settingsStore: settingsStore,
+		renderer:      renderer,
+		logger:        logger,
+	}
+}
+
+type digestData struct {
+	views.Page
+	Digest *store.Digest
+	Feeds  []*store.Feed
+	// HasEmail is false if the user has no address to send the digest to
+	HasEmail bool
+	Hours    []int
+	Weekdays []time.Weekday
+}
+
+func validateDigest(d *store.Digest) error {
+	if !slices.Contains([]string{store.DigestOff, store.DigestDaily, store.DigestWeekly}, d.Schedule) {
+		return errors.New("schedule must be off, daily or weekly")
+	}
+	if d.Hour < 0 || d.Hour > 23 {
+		return errors.New("hour must be between 0 and 23")
+	}
+	if d.Weekday < time.Sunday || d.Weekday > time.Saturday {
+		return errors.New("unknown weekday")
+	}
+	if d.MaxItems < 1 || d.MaxItems > maxDigestItems {
+		return errors.New("number of items must be between 1 and 100")
+	}
+	return nil
+}
+
+func (dh *DigestHandler) HandleDigest(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	d, err := dh.digestStore.GetDigest(user.ID)
+	if err != nil {
+		dh.logger.ErrorContext(r.Context(), "GetDigest", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var flash *views.Flash
+	if r.URL.Query().Get("saved") != "" {
+		flash = views.SuccessFlash("Digest saved")
+	}
+	dh.render(w, r, http.StatusOK, user, d, flash)
+}
+
+func (dh *DigestHandler) HandleSaveDigest(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	hour, _ := strconv.Atoi(r.FormValue("hour"))
+	weekday, _ := strconv.Atoi(r.FormValue("weekday"))
+	maxItems, _ := strconv.Atoi(r.FormValue("max_items"))
+	d := &store.Digest{
+		UserID:   user.ID,
+		Schedule: r.FormValue("schedule"),
+		Hour:     hour,
+		Weekday:  time.Weekday(weekday),
+		MaxItems: maxItems,
+		FeedIDs:  []int{},
+	}
+	for _, value := range r.Form["feed"] {
+		feedID, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		d.FeedIDs = append(d.FeedIDs, feedID)
+	}
+	d.FeedIDs = slices.Compact(slices.Sorted(slices.Values(d.FeedIDs)))
+	// Leaving all feeds unchecked picks every feed
+	d.AllFeeds = len(d.FeedIDs) == 0
+
+	if err := validateDigest(d); err != nil {
+		dh.render(w, r, http.StatusUnprocessableEntity, user, d, views.ErrorFlash(err.Error()))
+		return
+	}
+
+	feeds, err := dh.feedStore.GetFeedsByUserID(int64(user.ID))
+	if err != nil {
+		dh.logger.ErrorContext(r.Context(), "GetFeedsByUserID", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	for _, feedID := range d.FeedIDs {
+		if !slices.ContainsFunc(feeds, func(feed *store.Feed) bool { return feed.ID == feedID }) {
+			dh.render(w, r, http.StatusUnprocessableEntity, user, d, views.ErrorFlash("feed not found"))
+			return
+		}
+	}
+
+	settings, err := dh.settingsStore.GetUserSettings(user.ID)
+	if err != nil {
+		dh.logger.ErrorContext(r.Context(), "GetUserSettings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	d.NextAt = digest.Next(d, loc, time.Now())
+
+	if err := dh.digestStore.SaveDigest(d); err != nil {
+		dh.logger.ErrorContext(r.Context(), "SaveDigest", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/digest?saved=1", http.StatusSeeOther)
+}
+
+func (dh *DigestHandler) render(w http.ResponseWriter, r *http.Request, status int, user *store.User, d *store.Digest, flash *views.Flash) {
+	settings, err := dh.settingsStore.GetUserSettings(user.ID)
+	if err != nil {
+		dh.logger.ErrorContext(r.Context(), "GetUserSettings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	feeds, err := dh.feedStore.GetFeedsByUserID(int64(user.ID))
+	if err != nil {
+		dh.logger.ErrorContext(r.Context(), "GetFeedsByUserID", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := digestData{
+		Page:     userPage(r, "Digest", user, settings),
+		Digest:   d,
+		Feeds:    feeds,
+		HasEmail: user.Email != "",
+		Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday},
+	}
+	data.Flash = flash
+	for hour := range 24 {
+		data.Hours = append(data.Hours, hour)
+	}
+
+	w.WriteHeader(status)
+	if err := dh.renderer.Render(w, "digest", data); err != nil {
+		dh.logger.ErrorContext(r.Context(), "HandleDigest", "error", err)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/events"
+	"github.com/floriangaechter/rss/internal/utils"
+)
+
+const (
+	// heartbeatInterval keeps proxies from closing idle streams
+	heartbeatInterval = 30 * time.Second
+	// reconnectDelay is how long browsers wait before reconnecting a
+	// stream that ended, in milliseconds
+	reconnectDelay = 5000
+)
+
+// EventsHandler streams the live updates of the user as Server-Sent Events
+type EventsHandler struct {
+	hub    *events.Hub
+	logger *slog.Logger
+}
+
+func NewEventsHandler(hub *events.Hub, logger *slog.Logger) *EventsHandler {
+	return &EventsHandler{
+		hub:    hub,
+		logger: logger,
+	}
+}
+
+// HandleEvents writes the events of the user until the client goes away or
+// the server shuts down. Each event is named by its type and has JSON data.
+func (eh *EventsHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		eh.logger.WarnContext(r.Context(), "events: clearing write deadline", "error", err)
+	}
+
+	sub := eh.hub.Subscribe(user.ID)
+	defer eh.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Buffering proxies like nginx would hold the events back
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		eh.logger.ErrorContext(r.Context(), "events: flushing", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			// Fell behind or shutting down, the client reconnects
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				eh.logger.ErrorContext(r.Context(), "events: encoding", "type", event.Type, "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -5,11 +5,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
 	"github.com/floriangaechter/rss/internal/fetcher"
+	"github.com/floriangaechter/rss/internal/logging"
 	"github.com/floriangaechter/rss/internal/store"
 	"github.com/floriangaechter/rss/internal/utils"
 )
@@ -18,10 +19,10 @@ type FeedHandler struct {
 	feedStore     store.FeedStore
 	feedItemStore store.FeedItemStore
 	fetcher       *fetcher.Fetcher
-	logger        *log.Logger
+	logger        *slog.Logger
 }
 
-func NewFeedHanlder(feedStore store.FeedStore, feedItemStore store.FeedItemStore, fetcher *fetcher.Fetcher, logger *log.Logger) *FeedHandler {
+func NewFeedHanlder(feedStore store.FeedStore, feedItemStore store.FeedItemStore, fetcher *fetcher.Fetcher, logger *slog.Logger) *FeedHandler {
 	return &FeedHandler{
 		feedStore:     feedStore,
 		feedItemStore: feedItemStore,
@@ -34,6 +35,7 @@ type CreateFeedInput struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	Link        string `json:"link"`
+	Category    string `json:"category"`
 }
 
 func (in *CreateFeedInput) ValidateFeed() error {
@@ -55,14 +57,15 @@ func (fh *FeedHandler) HandleGetFeedByID(w http.ResponseWriter, r *http.Request)
 
 	feedID, err := utils.ReadIDParam(r)
 	if err != nil {
-		fh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		fh.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
 		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid feed id"})
 		return
 	}
+	logging.SetFeedID(r.Context(), feedID)
 
 	feed, err := fh.feedStore.GetFeedByID(feedID)
 	if err != nil {
-		fh.logger.Printf("ERROR: GetFeedByID: %v", err)
+		fh.logger.ErrorContext(r.Context(), "GetFeedByID", "error", err)
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
@@ -91,7 +94,7 @@ func (fh *FeedHandler) HandleCreateFeed(w http.ResponseWriter, r *http.Request)
 
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		fh.logger.Printf("ERROR: decoding HandleCreateFeed: %v", err)
+		fh.logger.ErrorContext(r.Context(), "decoding HandleCreateFeed", "error", err)
 		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request"})
 		return
 	}
@@ -106,11 +109,12 @@ func (fh *FeedHandler) HandleCreateFeed(w http.ResponseWriter, r *http.Request)
 		Title:       req.Title,
 		Description: req.Description,
 		Link:        req.Link,
+		Category:    strings.TrimSpace(req.Category),
 	}
 
 	createdFeed, err := fh.feedStore.CreateFeed(&feed)
 	if err != nil {
-		fh.logger.Printf("ERROR: HandleCreateFeed: %v", err)
+		fh.logger.ErrorContext(r.Context(), "HandleCreateFeed", "error", err)
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
@@ -127,14 +131,15 @@ func (fh *FeedHandler) HandleUpdateFeedByID(w http.ResponseWriter, r *http.Reque
 
 	feedID, err := utils.ReadIDParam(r)
 	if err != nil {
-		fh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		fh.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
 		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid feed id"})
 		return
 	}
+	logging.SetFeedID(r.Context(), feedID)
 
 	feed, err := fh.feedStore.GetFeedByID(feedID)
 	if err != nil {
-		fh.logger.Printf("ERROR: GetFeedByID: %v", err)
+		fh.logger.ErrorContext(r.Context(), "GetFeedByID", "error", err)
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
@@ -153,10 +158,11 @@ func (fh *FeedHandler) HandleUpdateFeedByID(w http.ResponseWriter, r *http.Reque
 		Title       *string `json:"title"`
 		Description *string `json:"description"`
 		Link        *string `json:"link"`
+		Category    *string `json:"category"`
 	}
 	err = json.NewDecoder(r.Body).Decode(&updateFeedRequest)
 	if err != nil {
-		fh.logger.Printf("ERROR: decoding HandleUpdateFeedByID: %v", err)
+		fh.logger.ErrorContext(r.Context(), "decoding HandleUpdateFeedByID", "error", err)
 		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request"})
 		return
 	}
@@ -170,10 +176,13 @@ func (fh *FeedHandler) HandleUpdateFeedByID(w http.ResponseWriter, r *http.Reque
 	if updateFeedRequest.Link != nil {
 		feed.Link = *updateFeedRequest.Link
 	}
+	if updateFeedRequest.Category != nil {
+		feed.Category = strings.TrimSpace(*updateFeedRequest.Category)
+	}
 
 	err = fh.feedStore.UpdateFeed(feed)
 	if err != nil {
-		fh.logger.Printf("ERROR: HandleUpdateFeedByID: %v", err)
+		fh.logger.ErrorContext(r.Context(), "HandleUpdateFeedByID", "error", err)
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
@@ -190,15 +199,16 @@ func (fh *FeedHandler) HandleDeleteFeedByID(w http.ResponseWriter, r *http.Reque
 
 	feedID, err := utils.ReadIDParam(r)
 	if err != nil {
-		fh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		fh.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
 		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid feed id"})
 		return
 	}
+	logging.SetFeedID(r.Context(), feedID)
 
 	// First check if feed exists and belongs to user
 	feed, err := fh.feedStore.GetFeedByID(feedID)
 	if err != nil {
-		fh.logger.Printf("ERROR: GetFeedByID: %v", err)
+		fh.logger.ErrorContext(r.Context(), "GetFeedByID", "error", err)
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
@@ -219,7 +229,7 @@ func (fh *FeedHandler) HandleDeleteFeedByID(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	if err != nil {
-		fh.logger.Printf("ERROR: DeleteFeedByID: %v", err)
+		fh.logger.ErrorContext(r.Context(), "DeleteFeedByID", "error", err)
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
@@ -236,14 +246,15 @@ func (fh *FeedHandler) HandleFetchFeedItems(w http.ResponseWriter, r *http.Reque
 
 	feedID, err := utils.ReadIDParam(r)
 	if err != nil {
-		fh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		fh.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
 		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid feed id"})
 		return
 	}
+	logging.SetFeedID(r.Context(), feedID)
 
 	feed, err := fh.feedStore.GetFeedByID(feedID)
 	if err != nil {
-		fh.logger.Printf("ERROR: GetFeedByID: %v", err)
+		fh.logger.ErrorContext(r.Context(), "GetFeedByID", "error", err)
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
@@ -258,9 +269,9 @@ func (fh *FeedHandler) HandleFetchFeedItems(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = fh.fetcher.FetchFeedItems(feedID)
+	// Failures are logged by the fetcher
+	err = fh.fetcher.FetchFeedItems(r.Context(), feedID)
 	if err != nil {
-		fh.logger.Printf("ERROR: FetchFeedItems: %v", err)
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch feed items"})
 		return
 	}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+)
+
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 90 * 24 * time.Hour
+)
+
+// InviteHandler lets admins hand out invite codes for invite only
+// registration
+type InviteHandler struct {
+	inviteStore store.InviteStore
+	logger      *slog.Logger
+}
+
+func NewInviteHandler(inviteStore store.InviteStore, logger *slog.Logger) *InviteHandler {
+	return &InviteHandler{
+		inviteStore: inviteStore,
+		logger:      logger,
+	}
+}
+
+type createInviteRequest struct {
+	// ExpiresIn is a duration like "48h", defaultInviteTTL if empty
+	ExpiresIn string `json:"expiresIn"`
+}
+
+// HandleCreateInvite returns a new invite code. It is shown only this once.
+func (ih *InviteHandler) HandleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	// The body is optional
+	var req createInviteRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		ih.logger.ErrorContext(r.Context(), "decoding HandleCreateInvite", "error", err)
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request"})
+		return
+	}
+
+	ttl := defaultInviteTTL
+	if req.ExpiresIn != "" {
+		ttl, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 || ttl > maxInviteTTL {
+			_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "expiresIn must be a duration up to " + maxInviteTTL.String()})
+			return
+		}
+	}
+
+	code, err := store.NewInviteCode()
+	if err != nil {
+		ih.logger.ErrorContext(r.Context(), "NewInviteCode", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	invite := &store.Invite{CreatedBy: user.ID, ExpiresAt: time.Now().Add(ttl).Truncate(time.Second)}
+	if err := ih.inviteStore.CreateInvite(invite, code); err != nil {
+		ih.logger.ErrorContext(r.Context(), "CreateInvite", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	ih.logger.InfoContext(r.Context(), "invite created", "invite_id", invite.ID, "expires_at", invite.ExpiresAt)
+
+	_ = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"invite": invite, "code": code})
+}
+
+func (ih *InviteHandler) HandleListInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := ih.inviteStore.ListInvites()
+	if err != nil {
+		ih.logger.ErrorContext(r.Context(), "ListInvites", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"invites": invites})
+}
+
+// HandleDeleteInvite revokes an invite
+func (ih *InviteHandler) HandleDeleteInvite(w http.ResponseWriter, r *http.Request) {
+	inviteID, err := utils.ReadIDParam(r)
+	if err != nil {
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid invite id"})
+		return
+	}
+
+	err = ih.inviteStore.DeleteInvite(inviteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "invite not found"})
+		return
+	}
+	if err != nil {
+		ih.logger.ErrorContext(r.Context(), "DeleteInvite", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/middleware"
+	"github.com/floriangaechter/rss/internal/store"
+)
+
+const (
+	// LoginFailureWindow is how long failed logins are remembered after the
+	// last one
+	LoginFailureWindow = time.Hour
+	loginLockout       = 15 * time.Minute
+	loginMaxDelay      = time.Minute
+)
+
+// loginPolicy is how many failures are allowed before each further attempt
+// has to wait, doubling from a second, and before logins are locked
+type loginPolicy struct {
+	freeAttempts int
+	lockAfter    int
+}
+
+// Many users can share an address, so it gets more attempts
+var loginPolicies = map[string]loginPolicy{
+	store.LoginScopeUsername: {freeAttempts: 3, lockAfter: 10},
+	store.LoginScopeIP:       {freeAttempts: 10, lockAfter: 50},
+}
+
+// loginDelay is the wait after the last of failures
+func (p loginPolicy) loginDelay(failures int) time.Duration {
+	if failures < p.freeAttempts {
+		return 0
+	}
+	shift := failures - p.freeAttempts
+	if shift >= 6 {
+		return loginMaxDelay
+	}
+	return min(time.Second<<shift, loginMaxDelay)
+}
+
+type loginKey struct {
+	scope string
+	key   string
+}
+
+// loginKeys are the counters a login attempt is checked against. Usernames
+// are counted whether they exist or not.
+func loginKeys(r *http.Request, username string) []loginKey {
+	return []loginKey{
+		{scope: store.LoginScopeIP, key: middleware.ClientIP(r)},
+		{scope: store.LoginScopeUsername, key: loginUsernameKey(username)},
+	}
+}
+
+func loginUsernameKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// loginWait returns how long the client has to wait before it may try to
+// log in as username again
+func (h *UserHandler) loginWait(r *http.Request, username string) (time.Duration, error) {
+	var wait time.Duration
+	for _, k := range loginKeys(r, username) {
+		failures, err := h.throttleStore.GetLoginFailures(k.scope, k.key)
+		if err != nil {
+			return 0, err
+		}
+		if failures == nil {
+			continue
+		}
+
+		until := failures.LastFailedAt.Add(loginPolicies[k.scope].loginDelay(failures.Failures))
+		if failures.LockedUntil.After(until) {
+			until = failures.LockedUntil
+		}
+		wait = max(wait, time.Until(until))
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed password or second factor, locks logins
+// after too many and writes the audit log. user is nil for unknown usernames.
+func (h *UserHandler) recordLoginFailure(r *http.Request, auditEvent string, user *store.User, username, reason string) {
+	event := &store.AuditEvent{
+		Event:     auditEvent,
+		Username:  username,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    reason,
+	}
+	if user != nil {
+		event.UserID = user.ID
+	}
+	h.logger.WarnContext(r.Context(), "login failed", "username", username, "ip", event.IP, "reason", reason)
+	h.audit(r, event)
+
+	for _, k := range loginKeys(r, username) {
+		failures, err := h.throttleStore.AddLoginFailure(k.scope, k.key, LoginFailureWindow)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "counting login failure", "error", err)
+			continue
+		}
+		if failures.Failures < loginPolicies[k.scope].lockAfter || failures.LockedUntil.After(time.Now()) {
+			continue
+		}
+
+		if err := h.throttleStore.LockLogin(k.scope, k.key, time.Now().Add(loginLockout)); err != nil {
+			h.logger.ErrorContext(r.Context(), "locking logins", "error", err)
+			continue
+		}
+		locked := *event
+		locked.Event = store.AuditLoginLocked
+		locked.Detail = fmt.Sprintf("%d failed logins for %s %s, locked for %s", failures.Failures, k.scope, k.key, loginLockout)
+		h.logger.WarnContext(r.Context(), "logins locked", "scope", k.scope, "key", k.key, "failures", failures.Failures)
+		h.audit(r, &locked)
+	}
+}
+
+// clearLoginFailures forgets the failures of a username once a session is
+// started, so after the second factor too. Those of the address expire on
+// their own, so a known account can't be used to reset them.
+func (h *UserHandler) clearLoginFailures(r *http.Request, username string) {
+	if err := h.throttleStore.ClearLoginFailures(store.LoginScopeUsername, loginUsernameKey(username)); err != nil {
+		h.logger.ErrorContext(r.Context(), "clearing login failures", "error", err)
+	}
+}
+
+func (h *UserHandler) audit(r *http.Request, event *store.AuditEvent) {
+	if err := h.auditStore.RecordAuditEvent(event); err != nil {
+		h.logger.ErrorContext(r.Context(), "recording audit event", "error", err)
+	}
+}
+
+// formatWait rounds up to whole seconds, e.g. "2m30s"
+func formatWait(wait time.Duration) string {
+	return (wait + time.Second - 1).Truncate(time.Second).String()
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/middleware"
+	"github.com/floriangaechter/rss/internal/oidc"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/views"
+)
+
+const (
+	// oidcFlowCookieName keeps the state, nonce and PKCE verifier of a login
+	// until the provider redirects back
+	oidcFlowCookieName = "oidc_flow"
+	oidcFlowTTL        = 10 * time.Minute
+
+	oidcModeLogin = "login"
+	oidcModeLink  = "link"
+)
+
+// ssoMessages are shown after a redirect back to the single sign-on page
+var ssoMessages = map[string]string{
+	"linked":   "Your account is linked, you can sign in with it from now on",
+	"unlinked": "The account has been unlinked",
+}
+
+type OIDCHandler struct {
+	provider      *oidc.Provider
+	users         *UserHandler
+	userStore     store.UserStore
+	sessionStore  store.SessionStore
+	identityStore store.IdentityStore
+	settingsStore store.UserSettingsStore
+	// autoRegister creates users for identities that aren't linked yet, it
+	// is only set when registration is open
+	autoRegister bool
+	renderer     *views.Renderer
+	logger       *slog.Logger
+}
+
+func NewOIDCHandler(provider *oidc.Provider, users *UserHandler, userStore store.UserStore, sessionStore store.SessionStore, identityStore store.IdentityStore, settingsStore store.UserSettingsStore, autoRegister bool, renderer *views.Renderer, logger *slog.Logger) *OIDCHandler {
+	return &OIDCHandler{
+		provider:      provider,
+		users:         users,
+		userStore:     userStore,
+		sessionStore:  sessionStore,
+		identityStore: identityStore,
+		settingsStore: settingsStore,
+		autoRegister:  autoRegister,
+		renderer:      renderer,
+		logger:        logger,
+	}
+}
+
+type ssoData struct {
+	views.Page
+	Identities []*store.Identity
+}
+
+// HandleLogin sends the user to the provider to sign in
+func (oh *OIDCHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	oh.startFlow(w, r, oidcModeLogin)
+}
+
+// HandleLink sends a signed in user to the provider to link their account
+// there to this one
+func (oh *OIDCHandler) HandleLink(w http.ResponseWriter, r *http.Request) {
+	oh.startFlow(w, r, oidcModeLink)
+}
+
+func (oh *OIDCHandler) startFlow(w http.ResponseWriter, r *http.Request, mode string) {
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		oh.logger.ErrorContext(r.Context(), "NewFlow", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	authURL, err := oh.provider.AuthCodeURL(r.Context(), flow)
+	if err != nil {
+		oh.logger.ErrorContext(r.Context(), "AuthCodeURL", "error", err)
+		oh.flowError(w, r, mode, "single sign-on is unavailable, please try again later")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookieName,
+		Value:    strings.Join([]string{mode, flow.State, flow.Nonce, flow.Verifier}, "."),
+		Path:     "/login/oidc",
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// HandleCallback finishes the flow the provider redirected back from. It
+// signs in the user of the identity, creating them if needed, or links the
+// identity to the signed in user.
+func (oh *OIDCHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	mode, flow := readOIDCFlow(r)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookieName,
+		Value:    "",
+		Path:     "/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	if flow == nil || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 {
+		oh.flowError(w, r, mode, "single sign-on expired, please try again")
+		return
+	}
+	if providerError := query.Get("error"); providerError != "" {
+		oh.logger.WarnContext(r.Context(), "oidc: provider returned an error", "error", providerError, "description", query.Get("error_description"))
+		oh.flowError(w, r, mode, "single sign-on was cancelled or denied")
+		return
+	}
+
+	identity, err := oh.provider.Exchange(r.Context(), query.Get("code"), flow)
+	if err != nil {
+		oh.logger.ErrorContext(r.Context(), "Exchange", "error", err)
+		oh.flowError(w, r, mode, "single sign-on failed, please try again")
+		return
+	}
+
+	linked, err := oh.identityStore.GetIdentity(identity.Issuer, identity.Subject)
+	if err != nil {
+		oh.logger.ErrorContext(r.Context(), "GetIdentity", "error", err)
+		oh.flowError(w, r, mode, "internal server error")
+		return
+	}
+
+	if mode == oidcModeLink {
+		oh.link(w, r, identity, linked)
+		return
+	}
+
+	var user *store.User
+	switch {
+	case linked != nil:
+		user, err = oh.userStore.GetUserByID(linked.UserID)
+	case oh.autoRegister:
+		user, err = oh.register(identity)
+	default:
+		oh.flowError(w, r, mode, "this account is not linked to any user")
+		return
+	}
+	if errors.Is(err, errUsernameTaken) {
+		oh.flowError(w, r, mode, fmt.Sprintf("username %q is taken, sign in with your password and link single sign-on on your account page", identity.Username))
+		return
+	}
+	if err != nil || user == nil {
+		oh.logger.ErrorContext(r.Context(), "oidc: getting user", "error", err)
+		oh.flowError(w, r, mode, "internal server error")
+		return
+	}
+	if user.Disabled {
+		oh.flowError(w, r, mode, "this account is disabled")
+		return
+	}
+
+	// The provider is trusted with the second factor, if any
+	oh.users.startSession(w, r, user, false, false)
+}
+
+var errUsernameTaken = errors.New("username is taken")
+
+// register creates a user for an identity that isn't linked yet
+func (oh *OIDCHandler) register(identity *oidc.Identity) (*store.User, error) {
+	existing, err := oh.userStore.GetUserByUsername(identity.Username)
+	if err != nil {
+		return nil, err
+	}
+	// Taking over an existing user needs the password, see HandleLink
+	if existing != nil {
+		return nil, errUsernameTaken
+	}
+
+	// The user signs in through the provider and never learns the random
+	// password. An admin can set one with "rss user reset-password".
+	user := &store.User{Username: identity.Username}
+	if err := user.Password.SetRandom(); err != nil {
+		return nil, err
+	}
+	err = oh.identityStore.CreateUserWithIdentity(user, &store.Identity{
+		Issuer:   identity.Issuer,
+		Subject:  identity.Subject,
+		Username: identity.Username,
+	})
+	if errors.Is(err, store.ErrUsernameTaken) {
+		return nil, errUsernameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// link adds the identity to the user of the session the flow was started
+// from
+func (oh *OIDCHandler) link(w http.ResponseWriter, r *http.Request, identity *oidc.Identity, linked *store.Identity) {
+	cookie, err := r.Cookie(middleware.SessionCookieName)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	session, err := oh.sessionStore.GetSession(cookie.Value)
+	if err != nil {
+		oh.logger.ErrorContext(r.Context(), "GetSession", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if session == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if linked != nil {
+		if linked.UserID != session.UserID {
+			oh.flowError(w, r, oidcModeLink, "this account is already linked to another user")
+			return
+		}
+		http.Redirect(w, r, "/account/sso?changed=linked", http.StatusSeeOther)
+		return
+	}
+
+	err = oh.identityStore.CreateIdentity(&store.Identity{
+		UserID:   session.UserID,
+		Issuer:   identity.Issuer,
+		Subject:  identity.Subject,
+		Username: identity.Username,
+	})
+	if err != nil {
+		oh.logger.ErrorContext(r.Context(), "CreateIdentity", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/account/sso?changed=linked", http.StatusSeeOther)
+}
+
+// readOIDCFlow returns the flow of the cookie, or nil if there is none
+func readOIDCFlow(r *http.Request) (string, *oidc.Flow) {
+	cookie, err := r.Cookie(oidcFlowCookieName)
+	if err != nil {
+		return oidcModeLogin, nil
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 4 || (parts[0] != oidcModeLogin && parts[0] != oidcModeLink) {
+		return oidcModeLogin, nil
+	}
+	return parts[0], &oidc.Flow{State: parts[1], Nonce: parts[2], Verifier: parts[3]}
+}
+
+// flowError sends the user back to where the flow was started from
+func (oh *OIDCHandler) flowError(w http.ResponseWriter, r *http.Request, mode, message string) {
+	page := "/"
+	if mode == oidcModeLink {
+		page = "/account/sso"
+	}
+	http.Redirect(w, r, page+"?error="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+func (oh *OIDCHandler) HandleIdentities(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := oh.settingsStore.GetUserSettings(user.ID)
+	if err != nil {
+		oh.logger.ErrorContext(r.Context(), "GetUserSettings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	identities, err := oh.identityStore.ListUserIdentities(user.ID)
+	if err != nil {
+		oh.logger.ErrorContext(r.Context(), "ListUserIdentities", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := ssoData{
+		Page:       userPage(r, "Single sign-on", user, settings),
+		Identities: identities,
+	}
+	if message, ok := ssoMessages[r.URL.Query().Get("changed")]; ok {
+		data.Flash = views.SuccessFlash(message)
+	} else {
+		data.Flash = views.ErrorFlash(r.URL.Query().Get("error"))
+	}
+
+	err = oh.renderer.Render(w, "sso", data)
+	if err != nil {
+		oh.logger.ErrorContext(r.Context(), "HandleIdentities", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (oh *OIDCHandler) HandleUnlink(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	identityID, err := utils.ReadIDParam(r)
+	if err != nil {
+		http.Error(w, "Invalid identity id", http.StatusBadRequest)
+		return
+	}
+
+	err = oh.identityStore.DeleteUserIdentity(user.ID, identityID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		oh.logger.ErrorContext(r.Context(), "DeleteUserIdentity", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/account/sso?changed=unlinked", http.StatusSeeOther)
+}
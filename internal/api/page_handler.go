@@ -1,41 +1,137 @@
 package api
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"text/template"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/floriangaechter/rss/internal/events"
+	"github.com/floriangaechter/rss/internal/fetcher"
+	"github.com/floriangaechter/rss/internal/logging"
 	"github.com/floriangaechter/rss/internal/store"
 	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/views"
 )
 
 type PageHandler struct {
-	feedStore store.FeedStore
-	logger    *log.Logger
+	feedStore     store.FeedStore
+	feedItemStore store.FeedItemStore
+	settingsStore store.UserSettingsStore
+	fetcher       *fetcher.Fetcher
+	// hub tells the other tabs and devices of the user about read items
+	hub      *events.Hub
+	renderer *views.Renderer
+	logger   *slog.Logger
 }
 
-func NewPageHandler(feedStore store.FeedStore, logger *log.Logger) *PageHandler {
+func NewPageHandler(feedStore store.FeedStore, feedItemStore store.FeedItemStore, settingsStore store.UserSettingsStore, fetcher *fetcher.Fetcher, hub *events.Hub, renderer *views.Renderer, logger *slog.Logger) *PageHandler {
 	return &PageHandler{
-		feedStore: feedStore,
-		logger:    logger,
+		feedStore:     feedStore,
+		feedItemStore: feedItemStore,
+		settingsStore: settingsStore,
+		fetcher:       fetcher,
+		hub:           hub,
+		renderer:      renderer,
+		logger:        logger,
 	}
 }
 
-func (h *PageHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
-	t, _ := template.ParseFiles("templates/index.html")
+// dashboardData is shared by the dashboard page and all of its fragments
+type dashboardData struct {
+	views.Page
+	Settings *store.UserSettings
+	Feeds    []*store.Feed
+	Items    []*store.FeedItem
+	Item     *store.FeedItem
+
+	// Offset is the position of Items in the list, NextOffset the position of
+	// the next page or 0 if this is the last one
+	Offset     int
+	NextOffset int
+
+	// PrevID and NextID are the items around Item in the list, 0 at the ends
+	PrevID int64
+	NextID int64
+
+	// FeedID is the selected feed, 0 for all feeds
+	FeedID  int
+	Unread  bool
+	Starred bool
+	Query   string
+}
 
+func (d dashboardData) filter() store.FeedItemFilter {
+	return store.FeedItemFilter{
+		FeedID:      int64(d.FeedID),
+		UnreadOnly:  d.Unread,
+		StarredOnly: d.Starred,
+		Query:       d.Query,
+		OldestFirst: d.Settings.SortOrder == store.SortOldest,
+		Limit:       d.Settings.ItemsPerPage,
+		Offset:      d.Offset,
+	}
+}
+
+type feedFormData struct {
+	Link  string
+	Error string
+}
+
+// loginMessages are shown after a redirect to the login page
+var loginMessages = map[string]string{
+	"password": "Your password has been reset, sign in with the new one",
+}
+
+func (h *PageHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 	// Get error from query parameter if present
 	errorMsg := r.URL.Query().Get("error")
 
 	data := struct {
-		Error string
+		views.Page
+	}{
+		Page: views.Page{
+			Title:     "Sign in",
+			Flash:     views.ErrorFlash(errorMsg),
+			CSRFToken: utils.GetCSRFTokenFromContext(r),
+		},
+	}
+	if message, ok := loginMessages[r.URL.Query().Get("changed")]; ok && data.Flash == nil {
+		data.Flash = views.SuccessFlash(message)
+	}
+
+	err := h.renderer.Render(w, "login", data)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "HandleHome", "error", err)
+		return
+	}
+}
+
+// HandleLoginTwoFactor asks for the second factor of a login that passed
+// the password check
+func (h *PageHandler) HandleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(loginChallengeCookieName); err != nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	data := struct {
+		views.Page
 	}{
-		Error: errorMsg,
+		Page: views.Page{
+			Title:     "Two-factor authentication",
+			Flash:     views.ErrorFlash(r.URL.Query().Get("error")),
+			CSRFToken: utils.GetCSRFTokenFromContext(r),
+		},
 	}
 
-	err := t.Execute(w, data)
+	err := h.renderer.Render(w, "login_2fa", data)
 	if err != nil {
-		h.logger.Printf("ERROR: HandleHome %v", err)
+		h.logger.ErrorContext(r.Context(), "HandleLoginTwoFactor", "error", err)
 		return
 	}
 }
@@ -47,27 +143,562 @@ func (h *PageHandler) HandleDashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	feeds, err := h.feedStore.GetFeedsByUserID(int64(user.ID))
+	data, err := h.dashboardState(r, user)
 	if err != nil {
-		h.logger.Printf("ERROR: GetFeedsByUserID: %v", err)
+		h.logger.ErrorContext(r.Context(), "dashboardState", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	t, err := template.ParseFiles("templates/dashboard.html")
+	// Items can be linked to directly, which opens them like a click would
+	if itemID, err := strconv.ParseInt(r.URL.Query().Get("item"), 10, 64); err == nil {
+		item, err := h.openItem(user, itemID)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "openItem", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		data.Item = item
+	}
+
+	if err := h.loadItemNeighbours(user, &data); err != nil {
+		h.logger.ErrorContext(r.Context(), "loadItemNeighbours", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.loadDashboard(user, &data); err != nil {
+		h.logger.ErrorContext(r.Context(), "loadDashboard", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.renderer.Render(w, "dashboard", data)
 	if err != nil {
-		h.logger.Printf("ERROR: ParseFiles: %v", err)
+		h.logger.ErrorContext(r.Context(), "HandleDashboard", "error", err)
+		return
+	}
+}
+
+// HandleItems renders the item list for the selected feed and filter
+func (h *PageHandler) HandleItems(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data, err := h.dashboardState(r, user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "dashboardState", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	data := struct {
-		Feeds []*store.Feed
-	}{
-		Feeds: feeds,
+	if err := h.loadDashboard(user, &data); err != nil {
+		h.logger.ErrorContext(r.Context(), "loadDashboard", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Further pages are appended to the list
+	if data.Offset > 0 {
+		h.renderPartial(w, r, "item_rows", data)
+		return
+	}
+
+	w.Header().Set("HX-Push-Url", dashboardURL(data))
+	h.renderPartial(w, r, "items_response", data)
+}
+
+// HandleItem opens an item in the reading pane and marks it read
+func (h *PageHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID, err := utils.ReadIDParam(r)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	item, err := h.openItem(user, itemID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "openItem", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if item == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	h.renderItem(w, r, user, item)
+}
+
+// HandleToggleRead marks an item unread if it was read and read otherwise.
+// With read=1 or read=0 the item is set to that state instead, so repeated
+// requests from marking read on scroll are harmless.
+func (h *PageHandler) HandleToggleRead(w http.ResponseWriter, r *http.Request) {
+	h.toggleItem(w, r, func(item *store.FeedItem, now string) {
+		read := item.ReadAt == ""
+		if value := r.FormValue("read"); value != "" {
+			read = value == "1"
+		}
+
+		switch {
+		case !read:
+			item.ReadAt = ""
+		case item.ReadAt == "":
+			item.ReadAt = now
+		}
+	})
+}
+
+// HandleToggleStar stars or unstars an item
+func (h *PageHandler) HandleToggleStar(w http.ResponseWriter, r *http.Request) {
+	h.toggleItem(w, r, func(item *store.FeedItem, now string) {
+		if item.StarredAt == "" {
+			item.StarredAt = now
+		} else {
+			item.StarredAt = ""
+		}
+	})
+}
+
+func (h *PageHandler) toggleItem(w http.ResponseWriter, r *http.Request, toggle func(item *store.FeedItem, now string)) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID, err := utils.ReadIDParam(r)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	item, err := h.feedItemStore.GetFeedItemByID(itemID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetFeedItemByID", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if item == nil || item.UserID != user.ID {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	wasRead := item.ReadAt != ""
+	toggle(item, time.Now().UTC().Format(time.RFC3339))
+	if err := h.feedItemStore.UpdateFeedItem(item); err != nil {
+		h.logger.ErrorContext(r.Context(), "UpdateFeedItem", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if read := item.ReadAt != ""; read != wasRead {
+		h.hub.Publish(user.ID, events.ItemRead(item.ID, read))
+	}
+
+	h.renderItem(w, r, user, item)
+}
+
+// HandleMarkAllRead marks every item in the current list as read
+func (h *PageHandler) HandleMarkAllRead(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data, err := h.dashboardState(r, user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "dashboardState", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	n, err := h.feedItemStore.MarkFeedItemsRead(int64(user.ID), data.filter(), time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "MarkFeedItemsRead", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n > 0 {
+		h.hub.Publish(user.ID, events.ItemsRead(n))
+	}
+
+	if n == 1 {
+		data.Flash = views.SuccessFlash("Marked 1 item as read")
+	} else {
+		data.Flash = views.SuccessFlash(fmt.Sprintf("Marked %d items as read", n))
+	}
+
+	if err := h.loadDashboard(user, &data); err != nil {
+		h.logger.ErrorContext(r.Context(), "loadDashboard", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderPartial(w, r, "items_response", data)
+}
+
+// renderItem renders item into the reading pane along with its neighbours in
+// the current list
+func (h *PageHandler) renderItem(w http.ResponseWriter, r *http.Request, user *store.User, item *store.FeedItem) {
+	data, err := h.dashboardState(r, user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "dashboardState", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	data.Item = item
+	if err := h.loadItemNeighbours(user, &data); err != nil {
+		h.logger.ErrorContext(r.Context(), "loadItemNeighbours", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.loadFeeds(user, &data); err != nil {
+		h.logger.ErrorContext(r.Context(), "loadFeeds", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderPartial(w, r, "item_response", data)
+}
+
+// HandleSidebar renders the sidebar alone, so the dashboard can update its
+// unread counts when told by the event stream
+func (h *PageHandler) HandleSidebar(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data, err := h.dashboardState(r, user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "dashboardState", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.loadFeeds(user, &data); err != nil {
+		h.logger.ErrorContext(r.Context(), "loadFeeds", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderPartial(w, r, "sidebar", data)
+}
+
+const (
+	// refreshTimeout is how long HandleRefresh and HandleAddFeed wait for
+	// the fetches, they answer well before the server's write timeout.
+	// Slower feeds finish in the background and show up through the live
+	// updates.
+	refreshTimeout = 20 * time.Second
+	refreshWorkers = 4
+	// discoverTimeout is how long HandleAddFeed tries to read a new feed,
+	// its first fetch gets what is left of refreshTimeout
+	discoverTimeout = 10 * time.Second
+)
+
+// refresh fetches the feeds concurrently and counts those that were fetched
+// or failed within wait. Failures are logged by the fetcher.
+func (h *PageHandler) refresh(r *http.Request, feedIDs []int64, wait time.Duration) (fetched, failed int) {
+	// The fetches outlive the request if they have to
+	ctx := logging.Detach(r.Context())
+	queue := make(chan int64, len(feedIDs))
+	for _, feedID := range feedIDs {
+		queue <- feedID
+	}
+	close(queue)
+	results := make(chan error, len(feedIDs))
+	for range min(refreshWorkers, len(feedIDs)) {
+		go func() {
+			for feedID := range queue {
+				results <- h.fetcher.FetchFeedItems(ctx, feedID)
+			}
+		}()
+	}
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for range feedIDs {
+		select {
+		case err := <-results:
+			if err != nil {
+				failed++
+			} else {
+				fetched++
+			}
+		case <-timeout.C:
+			return fetched, failed
+		case <-r.Context().Done():
+			return fetched, failed
+		}
+	}
+	return fetched, failed
+}
+
+// HandleRefresh fetches the selected feed, or all of the user's feeds, and
+// renders the updated item list
+func (h *PageHandler) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data, err := h.dashboardState(r, user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "dashboardState", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.loadFeeds(user, &data); err != nil {
+		h.logger.ErrorContext(r.Context(), "loadFeeds", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var feedIDs []int64
+	for _, feed := range data.Feeds {
+		if data.FeedID == 0 || feed.ID == data.FeedID {
+			feedIDs = append(feedIDs, int64(feed.ID))
+		}
+	}
+	fetched, failed := h.refresh(r, feedIDs, refreshTimeout)
+	pending := len(feedIDs) - fetched - failed
+
+	switch {
+	case failed > 0:
+		data.Flash = views.ErrorFlash(fmt.Sprintf("%d of %d feeds could not be refreshed", failed, len(feedIDs)))
+	case pending > 0:
+		data.Flash = views.SuccessFlash(fmt.Sprintf("Refreshed %d of %d feeds, the rest show up once they are fetched", fetched, len(feedIDs)))
+	case fetched == 1:
+		data.Flash = views.SuccessFlash("Refreshed 1 feed")
+	default:
+		data.Flash = views.SuccessFlash(fmt.Sprintf("Refreshed %d feeds", fetched))
+	}
+
+	if err := h.loadDashboard(user, &data); err != nil {
+		h.logger.ErrorContext(r.Context(), "loadDashboard", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderPartial(w, r, "items_response", data)
+}
+
+// HandleNewFeed renders the add feed form into the reading pane
+func (h *PageHandler) HandleNewFeed(w http.ResponseWriter, r *http.Request) {
+	h.renderPartial(w, r, "feed_form", feedFormData{})
+}
+
+// HandleAddFeed subscribes to the feed at the submitted link and selects it.
+// Errors are shown in the form, which is why they are sent with a 200.
+func (h *PageHandler) HandleAddFeed(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	form := feedFormData{Link: strings.TrimSpace(r.FormValue("link"))}
+	if form.Link == "" {
+		form.Error = "Enter the URL of a feed"
+		h.renderPartial(w, r, "feed_form", form)
+		return
+	}
+
+	// Reading and fetching the feed have to fit the server's write timeout
+	start := time.Now()
+	discoverCtx, cancel := context.WithTimeout(r.Context(), discoverTimeout)
+	defer cancel()
+	discovered, err := h.fetcher.Discover(discoverCtx, form.Link)
+	if err != nil {
+		h.logger.InfoContext(r.Context(), "Discover", "link", form.Link, "error", err)
+		form.Error = "No feed could be read from that URL"
+		h.renderPartial(w, r, "feed_form", form)
+		return
+	}
+
+	feed := &store.Feed{
+		UserID:      user.ID,
+		Title:       discovered.Title,
+		Description: discovered.Description,
+		Link:        form.Link,
 	}
-	err = t.Execute(w, data)
+	if feed.Title == "" {
+		feed.Title = form.Link
+	}
+
+	feed, err = h.feedStore.CreateFeed(feed)
 	if err != nil {
-		h.logger.Printf("ERROR: HandleDashboard %v", err)
+		h.logger.ErrorContext(r.Context(), "CreateFeed", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	logging.SetFeedID(r.Context(), int64(feed.ID))
+
+	data, err := h.dashboardState(r, user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "dashboardState", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	data.FeedID = feed.ID
+	// Failures are logged by the fetcher, the scheduler retries later
+	switch fetched, failed := h.refresh(r, []int64{int64(feed.ID)}, refreshTimeout-time.Since(start)); {
+	case failed > 0:
+		data.Flash = views.ErrorFlash(fmt.Sprintf("Added %s, but its items could not be fetched yet", feed.Title))
+	case fetched == 0:
+		data.Flash = views.SuccessFlash(fmt.Sprintf("Added %s, its items show up once they are fetched", feed.Title))
+	default:
+		data.Flash = views.SuccessFlash(fmt.Sprintf("Added %s", feed.Title))
+	}
+
+	if err := h.loadDashboard(user, &data); err != nil {
+		h.logger.ErrorContext(r.Context(), "loadDashboard", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+
+	// The form targets the reading pane, the new feed's items replace the list
+	w.Header().Set("HX-Retarget", "#items")
+	w.Header().Set("HX-Reswap", "outerHTML")
+	w.Header().Set("HX-Push-Url", dashboardURL(data))
+	h.renderPartial(w, r, "feed_added_response", data)
+}
+
+// openItem loads an item of the user and marks it read. It returns nil if
+// there is no such item.
+func (h *PageHandler) openItem(user *store.User, id int64) (*store.FeedItem, error) {
+	item, err := h.feedItemStore.GetFeedItemByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil || item.UserID != user.ID {
+		return nil, nil
+	}
+
+	if item.ReadAt == "" {
+		item.ReadAt = time.Now().UTC().Format(time.RFC3339)
+		if err := h.feedItemStore.UpdateFeedItem(item); err != nil {
+			return nil, err
+		}
+		h.hub.Publish(user.ID, events.ItemRead(item.ID, true))
+	}
+
+	return item, nil
+}
+
+func (h *PageHandler) loadFeeds(user *store.User, data *dashboardData) error {
+	feeds, err := h.feedStore.GetFeedsByUserID(int64(user.ID))
+	if err != nil {
+		return err
+	}
+	data.Feeds = feeds
+	return nil
+}
+
+func (h *PageHandler) loadDashboard(user *store.User, data *dashboardData) error {
+	if err := h.loadFeeds(user, data); err != nil {
+		return err
+	}
+
+	// One more item than shown tells whether there is another page
+	filter := data.filter()
+	filter.Limit++
+	items, err := h.feedItemStore.ListFeedItems(int64(user.ID), filter)
+	if err != nil {
+		return err
+	}
+	if len(items) > data.Settings.ItemsPerPage {
+		items = items[:data.Settings.ItemsPerPage]
+		data.NextOffset = data.Offset + data.Settings.ItemsPerPage
+	}
+	data.Items = items
+	return nil
+}
+
+func (h *PageHandler) loadItemNeighbours(user *store.User, data *dashboardData) error {
+	if data.Item == nil {
+		return nil
+	}
+
+	prevID, nextID, err := h.feedItemStore.GetAdjacentFeedItemIDs(int64(user.ID), data.filter(), data.Item)
+	if err != nil {
+		return err
+	}
+	data.PrevID, data.NextID = prevID, nextID
+	return nil
+}
+
+func (h *PageHandler) renderPartial(w http.ResponseWriter, r *http.Request, name string, data any) {
+	err := h.renderer.RenderPartial(w, name, data)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "RenderPartial", "name", name, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// dashboardState reads the selected feed, filters and search from the query
+// or form. Filters that are not given default to the user's settings.
+func (h *PageHandler) dashboardState(r *http.Request, user *store.User) (dashboardData, error) {
+	settings, err := h.settingsStore.GetUserSettings(user.ID)
+	if err != nil {
+		return dashboardData{}, err
+	}
+	if err := r.ParseForm(); err != nil {
+		return dashboardData{}, err
+	}
+
+	feedID, _ := strconv.Atoi(r.FormValue("feed"))
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	data := dashboardData{
+		Page:     userPage(r, "Dashboard", user, settings),
+		Settings: settings,
+		FeedID:   feedID,
+		Unread:   settings.UnreadOnly,
+		Starred:  r.FormValue("starred") != "",
+		Query:    strings.TrimSpace(r.FormValue("q")),
+		Offset:   max(offset, 0),
+	}
+
+	// The filters form sends unread=0 ahead of the checkbox, the last value
+	// is the one that counts
+	if values := r.Form["unread"]; len(values) > 0 {
+		data.Unread = values[len(values)-1] == "1"
+	}
+
+	return data, nil
+}
+
+// dashboardURL is the address of the dashboard in the given state, pushed to
+// the browser history so reloads keep it
+func dashboardURL(data dashboardData) string {
+	query := url.Values{}
+	if data.FeedID != 0 {
+		query.Set("feed", strconv.Itoa(data.FeedID))
+	}
+	if data.Unread != data.Settings.UnreadOnly {
+		if data.Unread {
+			query.Set("unread", "1")
+		} else {
+			query.Set("unread", "0")
+		}
+	}
+	if data.Starred {
+		query.Set("starred", "1")
+	}
+	if data.Query != "" {
+		query.Set("q", data.Query)
+	}
+	if len(query) == 0 {
+		return "/dashboard"
+	}
+	return "/dashboard?" + query.Encode()
 }
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/mailer"
+	"github.com/floriangaechter/rss/internal/middleware"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/views"
+)
+
+const (
+	// PasswordResetTTL is how long a reset link can be used
+	PasswordResetTTL = time.Hour
+	// maxPasswordResets is how many links a user gets per PasswordResetTTL,
+	// so nobody can flood their inbox
+	maxPasswordResets = 3
+	mailTimeout       = 30 * time.Second
+)
+
+// PasswordResetHandler lets users who forgot their password set a new one
+// through a link sent to their email address. It is only routed when
+// sending email is configured.
+type PasswordResetHandler struct {
+	userStore    store.UserStore
+	resetStore   store.PasswordResetStore
+	sessionStore store.SessionStore
+	auditStore   store.AuditStore
+	mailer       mailer.Mailer
+	// baseURL is where the links in the email point to
+	baseURL  string
+	renderer *views.Renderer
+	logger   *slog.Logger
+
+	sending sync.WaitGroup
+}
+
+func NewPasswordResetHandler(userStore store.UserStore, resetStore store.PasswordResetStore, sessionStore store.SessionStore, auditStore store.AuditStore, mailer mailer.Mailer, baseURL string, renderer *views.Renderer, logger *slog.Logger) *PasswordResetHandler {
+	return &PasswordResetHandler{
+		userStore:    userStore,
+		resetStore:   resetStore,
+		sessionStore: sessionStore,
+		auditStore:   auditStore,
+		mailer:       mailer,
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		renderer:     renderer,
+		logger:       logger,
+	}
+}
+
+type resetPasswordData struct {
+	views.Page
+	Token string
+	// Valid is false if the token can't be used, the page then offers to
+	// send a new link
+	Valid bool
+}
+
+// HandleForgotPassword asks for the email address to send a link to
+func (h *PasswordResetHandler) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	page := views.Page{
+		Title:     "Reset password",
+		CSRFToken: utils.GetCSRFTokenFromContext(r),
+	}
+	if r.URL.Query().Get("sent") != "" {
+		page.Flash = views.SuccessFlash("If an account has that email address, a link to reset its password is on its way")
+	}
+	h.render(w, r, http.StatusOK, "forgot_password", struct{ views.Page }{page})
+}
+
+// HandleSendPasswordReset emails a reset link if a user has the address.
+// The response is the same either way, so it doesn't tell who has an
+// account.
+func (h *PasswordResetHandler) HandleSendPasswordReset(w http.ResponseWriter, r *http.Request) {
+	email := NormalizeEmail(r.FormValue("email"))
+	if email == "" || ValidateEmail(email) != nil {
+		page := views.Page{
+			Title:     "Reset password",
+			Flash:     views.ErrorFlash("enter a valid email address"),
+			CSRFToken: utils.GetCSRFTokenFromContext(r),
+		}
+		h.render(w, r, http.StatusUnprocessableEntity, "forgot_password", struct{ views.Page }{page})
+		return
+	}
+
+	user, err := h.userStore.GetUserByEmail(email)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetUserByEmail", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || user.Disabled {
+		http.Redirect(w, r, "/password/forgot?sent=1", http.StatusSeeOther)
+		return
+	}
+
+	sent, err := h.resetStore.CountPasswordResets(user.ID, time.Now().Add(-PasswordResetTTL))
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "CountPasswordResets", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if sent >= maxPasswordResets {
+		h.logger.WarnContext(r.Context(), "too many password resets", "user_id", user.ID)
+		http.Redirect(w, r, "/password/forgot?sent=1", http.StatusSeeOther)
+		return
+	}
+
+	token, err := h.resetStore.CreatePasswordReset(user.ID, time.Now().Add(PasswordResetTTL))
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "CreatePasswordReset", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, &store.AuditEvent{
+		Event:     store.AuditPasswordResetSent,
+		UserID:    user.ID,
+		Username:  user.Username,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+
+	// Sending takes a while and only happens for existing users, so it is
+	// done in the background to not give them away by the response time
+	msg := &mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    h.resetMailBody(user, token),
+	}
+	h.sending.Add(1)
+	go func() {
+		defer h.sending.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), mailTimeout)
+		defer cancel()
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			h.logger.ErrorContext(ctx, "sending password reset", "user_id", user.ID, "error", err)
+		}
+	}()
+
+	http.Redirect(w, r, "/password/forgot?sent=1", http.StatusSeeOther)
+}
+
+func (h *PasswordResetHandler) resetMailBody(user *store.User, token string) string {
+	link := h.baseURL + "/password/reset?token=" + url.QueryEscape(token)
+	return fmt.Sprintf(`Hi %s,
+
+someone asked to reset the password of your account. If it was you, set a
+new password here:
+
+%s
+
+The link works once within the next hour. If you didn't ask for it, you
+can ignore this email, your password stays the same.
+`, user.Username, link)
+}
+
+// HandleResetPassword asks for a new password if the token of the link can
+// be used
+func (h *PasswordResetHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	userID, err := h.resetStore.GetPasswordResetUserID(token)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetPasswordResetUserID", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderReset(w, r, http.StatusOK, token, userID != 0, nil)
+}
+
+// HandleSaveResetPassword sets the new password, uses up the link and signs
+// the user out everywhere
+func (h *PasswordResetHandler) HandleSaveResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	userID, err := h.resetStore.GetPasswordResetUserID(token)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetPasswordResetUserID", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	user, err := h.userStore.GetUserByID(userID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetUserByID", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || user.Disabled {
+		h.renderReset(w, r, http.StatusUnprocessableEntity, token, false, nil)
+		return
+	}
+
+	newPassword := r.FormValue("new_password")
+	if err := ValidatePassword(newPassword, user.Username); err != nil {
+		h.renderReset(w, r, http.StatusUnprocessableEntity, token, true, views.ErrorFlash(err.Error()))
+		return
+	}
+	if newPassword != r.FormValue("confirm_password") {
+		h.renderReset(w, r, http.StatusUnprocessableEntity, token, true, views.ErrorFlash("new passwords do not match"))
+		return
+	}
+
+	if err := user.Password.Set(newPassword); err != nil {
+		h.logger.ErrorContext(r.Context(), "hashing password", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// The token may have been used in the meantime
+	err = h.resetStore.ResetPassword(token, user)
+	if errors.Is(err, store.ErrInvalidPasswordReset) {
+		h.renderReset(w, r, http.StatusUnprocessableEntity, token, false, nil)
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "ResetPassword", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password is signed out
+	if err := h.sessionStore.DeleteUserSessions(user.ID); err != nil {
+		h.logger.ErrorContext(r.Context(), "DeleteUserSessions", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, &store.AuditEvent{
+		Event:     store.AuditPasswordReset,
+		UserID:    user.ID,
+		Username:  user.Username,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	h.logger.InfoContext(r.Context(), "password reset", "user_id", user.ID)
+
+	http.Redirect(w, r, "/?changed=password", http.StatusSeeOther)
+}
+
+// Wait blocks until all emails being sent are out
+func (h *PasswordResetHandler) Wait() {
+	h.sending.Wait()
+}
+
+func (h *PasswordResetHandler) audit(r *http.Request, event *store.AuditEvent) {
+	if err := h.auditStore.RecordAuditEvent(event); err != nil {
+		h.logger.ErrorContext(r.Context(), "recording audit event", "error", err)
+	}
+}
+
+func (h *PasswordResetHandler) renderReset(w http.ResponseWriter, r *http.Request, status int, token string, valid bool, flash *views.Flash) {
+	data := resetPasswordData{
+		Page: views.Page{
+			Title:     "Reset password",
+			Flash:     flash,
+			CSRFToken: utils.GetCSRFTokenFromContext(r),
+		},
+		Token: token,
+		Valid: valid,
+	}
+	if !valid {
+		data.Token = ""
+		data.Flash = views.ErrorFlash(store.ErrInvalidPasswordReset.Error())
+	}
+	h.render(w, r, status, "reset_password", data)
+}
+
+func (h *PasswordResetHandler) render(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	// The token is in the URL, it must not leak to other sites
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	if err := h.renderer.Render(w, name, data); err != nil {
+		h.logger.ErrorContext(r.Context(), "rendering "+name, "error", err)
+	}
+}
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/floriangaechter/rss/internal/middleware"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/views"
+)
+
+// sessionMessages are shown after a redirect back to the sessions page
+var sessionMessages = map[string]string{
+	"one":    "The device has been signed out",
+	"others": "All other devices have been signed out",
+}
+
+type SessionHandler struct {
+	sessionStore  store.SessionStore
+	settingsStore store.UserSettingsStore
+	renderer      *views.Renderer
+	logger        *slog.Logger
+}
+
+func NewSessionHandler(sessionStore store.SessionStore, settingsStore store.UserSettingsStore, renderer *views.Renderer, logger *slog.Logger) *SessionHandler {
+	return &SessionHandler{
+		sessionStore:  sessionStore,
+		settingsStore: settingsStore,
+		renderer:      renderer,
+		logger:        logger,
+	}
+}
+
+// activeSession is a session as shown to its user
+type activeSession struct {
+	*store.Session
+	Device  string `json:"device"`
+	Current bool   `json:"current"`
+}
+
+type sessionsData struct {
+	views.Page
+	Sessions []activeSession
+}
+
+// listSessions returns the sessions of the user, marking the one of the
+// request as current
+func (sh *SessionHandler) listSessions(r *http.Request, user *store.User) ([]activeSession, error) {
+	sessions, err := sh.sessionStore.ListUserSessions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := utils.GetSessionFromContext(r)
+	active := make([]activeSession, 0, len(sessions))
+	for _, session := range sessions {
+		active = append(active, activeSession{
+			Session: session,
+			Device:  describeUserAgent(session.UserAgent),
+			Current: current != nil && session.ID == current.ID,
+		})
+	}
+	return active, nil
+}
+
+func (sh *SessionHandler) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := sh.settingsStore.GetUserSettings(user.ID)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "GetUserSettings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	sessions, err := sh.listSessions(r, user)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "ListUserSessions", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := sessionsData{
+		Page:     userPage(r, "Sessions", user, settings),
+		Sessions: sessions,
+	}
+	if message, ok := sessionMessages[r.URL.Query().Get("signed_out")]; ok {
+		data.Flash = views.SuccessFlash(message)
+	}
+
+	err = sh.renderer.Render(w, "sessions", data)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "HandleSessions", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleLogoutSession signs out one session of the user. Signing out the
+// current one works like logging out.
+func (sh *SessionHandler) HandleLogoutSession(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	current, ok := sh.deleteSession(w, r, user)
+	if !ok {
+		return
+	}
+	if current {
+		middleware.ClearSessionCookie(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/account/sessions?signed_out=one", http.StatusSeeOther)
+}
+
+func (sh *SessionHandler) HandleLogoutOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := sh.deleteOtherSessions(r, user); err != nil {
+		sh.logger.ErrorContext(r.Context(), "DeleteOtherUserSessions", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/account/sessions?signed_out=others", http.StatusSeeOther)
+}
+
+func (sh *SessionHandler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	sessions, err := sh.listSessions(r, user)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "ListUserSessions", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"sessions": sessions})
+}
+
+func (sh *SessionHandler) HandleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	sessionID, err := utils.ReadIDParam(r)
+	if err != nil {
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid session id"})
+		return
+	}
+
+	err = sh.sessionStore.DeleteUserSession(user.ID, sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "DeleteUserSession", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if current := utils.GetSessionFromContext(r); current != nil && current.ID == sessionID {
+		middleware.ClearSessionCookie(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sh *SessionHandler) HandleDeleteOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	if err := sh.deleteOtherSessions(r, user); err != nil {
+		sh.logger.ErrorContext(r.Context(), "DeleteOtherUserSessions", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteSession deletes the session of the id parameter and reports whether
+// it was the current one. It writes the error response if it fails.
+func (sh *SessionHandler) deleteSession(w http.ResponseWriter, r *http.Request, user *store.User) (bool, bool) {
+	sessionID, err := utils.ReadIDParam(r)
+	if err != nil {
+		http.Error(w, "Invalid session id", http.StatusBadRequest)
+		return false, false
+	}
+
+	err = sh.sessionStore.DeleteUserSession(user.ID, sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return false, false
+	}
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "DeleteUserSession", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false, false
+	}
+
+	current := utils.GetSessionFromContext(r)
+	return current != nil && current.ID == sessionID, true
+}
+
+func (sh *SessionHandler) deleteOtherSessions(r *http.Request, user *store.User) error {
+	current := utils.GetSessionFromContext(r)
+	if current == nil {
+		return errors.New("no session in request context")
+	}
+	return sh.sessionStore.DeleteOtherUserSessions(user.ID, current.Token)
+}
+
+// describeUserAgent turns a user agent into something like "Firefox on
+// Linux". It only knows the common browsers and falls back to the raw value.
+func describeUserAgent(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	var browser string
+	switch {
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	var system string
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		system = "iOS"
+	case strings.Contains(userAgent, "Android"):
+		system = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		system = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		system = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		system = "Linux"
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	}
+	return userAgent
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/views"
+)
+
+type SettingsHandler struct {
+	settingsStore store.UserSettingsStore
+	renderer      *views.Renderer
+	logger        *slog.Logger
+}
+
+func NewSettingsHandler(settingsStore store.UserSettingsStore, renderer *views.Renderer, logger *slog.Logger) *SettingsHandler {
+	return &SettingsHandler{
+		settingsStore: settingsStore,
+		renderer:      renderer,
+		logger:        logger,
+	}
+}
+
+type settingsData struct {
+	views.Page
+	Settings    *store.UserSettings
+	DateFormats []views.DateFormat
+}
+
+func validateSettings(settings *store.UserSettings) error {
+	if !slices.Contains([]string{store.ThemeSystem, store.ThemeLight, store.ThemeDark}, settings.Theme) {
+		return errors.New("theme must be system, light or dark")
+	}
+	if !slices.Contains([]string{store.SortNewest, store.SortOldest}, settings.SortOrder) {
+		return errors.New("sort order must be newest or oldest")
+	}
+	if settings.ItemsPerPage < 10 || settings.ItemsPerPage > 500 {
+		return errors.New("items per page must be between 10 and 500")
+	}
+	if _, err := time.LoadLocation(settings.Timezone); err != nil || settings.Timezone == "" {
+		return errors.New("unknown timezone")
+	}
+	if !slices.ContainsFunc(views.DateFormats, func(f views.DateFormat) bool { return f.Name == settings.DateFormat }) {
+		return errors.New("unknown date format")
+	}
+	return nil
+}
+
+func (sh *SettingsHandler) HandleSettings(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := sh.settingsStore.GetUserSettings(user.ID)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "GetUserSettings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	page := userPage(r, "Settings", user, settings)
+	if r.URL.Query().Get("saved") != "" {
+		page.Flash = views.SuccessFlash("Settings saved")
+	}
+	sh.render(w, r, http.StatusOK, page, settings)
+}
+
+func (sh *SettingsHandler) HandleSaveSettings(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemsPerPage, _ := strconv.Atoi(r.FormValue("items_per_page"))
+	settings := &store.UserSettings{
+		UserID:           user.ID,
+		Theme:            r.FormValue("theme"),
+		SortOrder:        r.FormValue("sort_order"),
+		UnreadOnly:       r.FormValue("unread_only") != "",
+		ItemsPerPage:     itemsPerPage,
+		MarkReadOnScroll: r.FormValue("mark_read_on_scroll") != "",
+		Timezone:         strings.TrimSpace(r.FormValue("timezone")),
+		DateFormat:       r.FormValue("date_format"),
+	}
+
+	if err := validateSettings(settings); err != nil {
+		// Show the page as it was saved, with the rejected values in the form
+		saved, getErr := sh.settingsStore.GetUserSettings(user.ID)
+		if getErr != nil {
+			sh.logger.ErrorContext(r.Context(), "GetUserSettings", "error", getErr)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		page := userPage(r, "Settings", user, saved)
+		page.Flash = views.ErrorFlash(err.Error())
+		sh.render(w, r, http.StatusUnprocessableEntity, page, settings)
+		return
+	}
+
+	if err := sh.settingsStore.SaveUserSettings(settings); err != nil {
+		sh.logger.ErrorContext(r.Context(), "SaveUserSettings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings?saved=1", http.StatusSeeOther)
+}
+
+func (sh *SettingsHandler) render(w http.ResponseWriter, r *http.Request, status int, page views.Page, settings *store.UserSettings) {
+	data := settingsData{
+		Page:        page,
+		Settings:    settings,
+		DateFormats: views.DateFormats,
+	}
+
+	w.WriteHeader(status)
+	err := sh.renderer.Render(w, "settings", data)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "HandleSettings", "error", err)
+		return
+	}
+}
+
+// userPage is the page of a signed in user, shown with their settings
+func userPage(r *http.Request, title string, user *store.User, settings *store.UserSettings) views.Page {
+	page := views.Page{
+		Title:     title,
+		Username:  user.Username,
+		IsAdmin:   user.IsAdmin,
+		Locale:    views.Locale{DateFormat: settings.DateFormat},
+		CSRFToken: utils.GetCSRFTokenFromContext(r),
+	}
+	if settings.Theme != store.ThemeSystem {
+		page.Theme = settings.Theme
+	}
+	if loc, err := time.LoadLocation(settings.Timezone); err == nil {
+		page.Locale.Location = loc
+	}
+	return page
+}
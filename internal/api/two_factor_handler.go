@@ -0,0 +1,289 @@
+package api
+
+import (
+	"encoding/base64"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/middleware"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/totp"
+	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/views"
+	"rsc.io/qr"
+)
+
+// totpIssuer names the account in authenticator apps
+const totpIssuer = "RSS"
+
+// twoFactorMessages are shown after a redirect back to the two-factor page
+var twoFactorMessages = map[string]string{
+	"disabled": "Two-factor authentication is disabled",
+}
+
+type TwoFactorHandler struct {
+	twoFactorStore store.TwoFactorStore
+	sessionStore   store.SessionStore
+	settingsStore  store.UserSettingsStore
+	renderer       *views.Renderer
+	logger         *slog.Logger
+}
+
+func NewTwoFactorHandler(twoFactorStore store.TwoFactorStore, sessionStore store.SessionStore, settingsStore store.UserSettingsStore, renderer *views.Renderer, logger *slog.Logger) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactorStore: twoFactorStore,
+		sessionStore:   sessionStore,
+		settingsStore:  settingsStore,
+		renderer:       renderer,
+		logger:         logger,
+	}
+}
+
+type twoFactorData struct {
+	views.Page
+	Enabled bool
+	// Secret, URI and QRCode are set during enrollment
+	Secret string
+	URI    string
+	QRCode template.URL
+	// RecoveryCodes are only shown right after they were generated
+	RecoveryCodes  []string
+	RemainingCodes int
+}
+
+// verifySecondFactor checks a code of the user's authenticator app, or else
+// one of their recovery codes. Either can only be used once.
+func verifySecondFactor(twoFactorStore store.TwoFactorStore, userID int, code string) (bool, error) {
+	enrollment, err := twoFactorStore.GetTOTP(userID)
+	if err != nil || enrollment == nil || !enrollment.Enabled {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(enrollment.Secret, code, time.Now()); ok {
+		return twoFactorStore.UseTOTPStep(userID, step)
+	}
+	return twoFactorStore.UseRecoveryCode(userID, code)
+}
+
+func (th *TwoFactorHandler) HandleTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var flash *views.Flash
+	if message, ok := twoFactorMessages[r.URL.Query().Get("changed")]; ok {
+		flash = views.SuccessFlash(message)
+	}
+	th.render(w, r, http.StatusOK, user, flash, nil)
+}
+
+// HandleSetup starts an enrollment with a new secret, shown as QR code until
+// a code of it is confirmed
+func (th *TwoFactorHandler) HandleSetup(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	current, err := th.twoFactorStore.GetTOTP(user.ID)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "GetTOTP", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if current != nil && current.Enabled {
+		th.render(w, r, http.StatusConflict, user, views.ErrorFlash("two-factor authentication is already enabled"), nil)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "GenerateSecret", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := th.twoFactorStore.SaveTOTPSecret(user.ID, secret); err != nil {
+		th.logger.ErrorContext(r.Context(), "SaveTOTPSecret", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+}
+
+// HandleEnable confirms the enrollment with a code from the app and shows
+// the recovery codes once
+func (th *TwoFactorHandler) HandleEnable(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	pending, err := th.twoFactorStore.GetTOTP(user.ID)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "GetTOTP", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if pending == nil || pending.Enabled {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	step, ok := totp.Validate(pending.Secret, r.FormValue("code"), time.Now())
+	if !ok {
+		th.render(w, r, http.StatusUnprocessableEntity, user, views.ErrorFlash("invalid code, check the time of your device"), nil)
+		return
+	}
+
+	codes, err := store.NewRecoveryCodes()
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "NewRecoveryCodes", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := th.twoFactorStore.EnableTOTP(user.ID, step, codes); err != nil {
+		th.logger.ErrorContext(r.Context(), "EnableTOTP", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !th.rotateSession(w, r) {
+		return
+	}
+
+	th.render(w, r, http.StatusOK, user, views.SuccessFlash("Two-factor authentication is enabled"), codes)
+}
+
+func (th *TwoFactorHandler) HandleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !th.checkPassword(w, r, user) {
+		return
+	}
+
+	codes, err := store.NewRecoveryCodes()
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "NewRecoveryCodes", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := th.twoFactorStore.ReplaceRecoveryCodes(user.ID, codes); err != nil {
+		th.logger.ErrorContext(r.Context(), "ReplaceRecoveryCodes", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	th.render(w, r, http.StatusOK, user, views.SuccessFlash("New recovery codes generated, the old ones no longer work"), codes)
+}
+
+func (th *TwoFactorHandler) HandleDisable(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !th.checkPassword(w, r, user) {
+		return
+	}
+
+	if err := th.twoFactorStore.DisableTOTP(user.ID); err != nil {
+		th.logger.ErrorContext(r.Context(), "DisableTOTP", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !th.rotateSession(w, r) {
+		return
+	}
+
+	http.Redirect(w, r, "/account/2fa?changed=disabled", http.StatusSeeOther)
+}
+
+// rotateSession gives the current session a new token after the way the
+// user signs in changed
+func (th *TwoFactorHandler) rotateSession(w http.ResponseWriter, r *http.Request) bool {
+	session := utils.GetSessionFromContext(r)
+	if session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if err := th.sessionStore.RotateSession(session); err != nil {
+		th.logger.ErrorContext(r.Context(), "RotateSession", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	middleware.SetSessionCookie(w, session)
+	return true
+}
+
+func (th *TwoFactorHandler) checkPassword(w http.ResponseWriter, r *http.Request, user *store.User) bool {
+	matches, err := user.Password.Matches(r.FormValue("password"))
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "checking password", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !matches {
+		th.render(w, r, http.StatusUnprocessableEntity, user, views.ErrorFlash("password is incorrect"), nil)
+		return false
+	}
+	return true
+}
+
+func (th *TwoFactorHandler) render(w http.ResponseWriter, r *http.Request, status int, user *store.User, flash *views.Flash, recoveryCodes []string) {
+	settings, err := th.settingsStore.GetUserSettings(user.ID)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "GetUserSettings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	enrollment, err := th.twoFactorStore.GetTOTP(user.ID)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "GetTOTP", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := twoFactorData{
+		Page:          userPage(r, "Two-factor authentication", user, settings),
+		RecoveryCodes: recoveryCodes,
+	}
+	data.Flash = flash
+
+	switch {
+	case enrollment != nil && enrollment.Enabled:
+		data.Enabled = true
+		data.RemainingCodes, err = th.twoFactorStore.CountRecoveryCodes(user.ID)
+		if err != nil {
+			th.logger.ErrorContext(r.Context(), "CountRecoveryCodes", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	case enrollment != nil:
+		data.Secret = enrollment.Secret
+		data.URI = totp.URI(totpIssuer, user.Username, enrollment.Secret)
+		code, err := qr.Encode(data.URI, qr.M)
+		if err != nil {
+			th.logger.ErrorContext(r.Context(), "encoding QR code", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		code.Scale = 4
+		data.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()))
+	}
+
+	w.WriteHeader(status)
+	err = th.renderer.Render(w, "two_factor", data)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "HandleTwoFactor", "error", err)
+		return
+	}
+}
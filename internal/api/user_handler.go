@@ -3,11 +3,15 @@ package api
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/floriangaechter/rss/internal/config"
+	"github.com/floriangaechter/rss/internal/middleware"
 	"github.com/floriangaechter/rss/internal/store"
 	"github.com/floriangaechter/rss/internal/utils"
 )
@@ -15,11 +19,16 @@ import (
 type createUserRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Email is optional, it is needed to reset a forgotten password
+	Email string `json:"email"`
+	// InviteCode is required when registration is invite only
+	InviteCode string `json:"inviteCode"`
 }
 
 type loginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Remember bool   `json:"remember"`
 }
 
 func (h *UserHandler) validateLoginRequest(req *loginRequest) error {
@@ -32,41 +41,84 @@ func (h *UserHandler) validateLoginRequest(req *loginRequest) error {
 	return nil
 }
 
+type loginTwoFactorRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+const (
+	// loginChallengeTTL is the time to enter the second factor after the
+	// password
+	loginChallengeTTL         = 5 * time.Minute
+	maxLoginChallengeAttempts = 5
+	loginChallengeCookieName  = "login_challenge"
+)
+
 type UserHandler struct {
-	userStore    store.UserStore
-	sessionStore store.SessionStore
-	logger       *log.Logger
+	userStore      store.UserStore
+	sessionStore   store.SessionStore
+	twoFactorStore store.TwoFactorStore
+	throttleStore  store.LoginThrottleStore
+	auditStore     store.AuditStore
+	inviteStore    store.InviteStore
+	// registration is one of the config.Registration* modes
+	registration string
+	// sessionTTL is the inactivity after which a session expires,
+	// rememberTTL the same for "remember me" sessions
+	sessionTTL  time.Duration
+	rememberTTL time.Duration
+	logger      *slog.Logger
 }
 
-func NewUserHandler(userStore store.UserStore, sessionStore store.SessionStore, logger *log.Logger) *UserHandler {
+func NewUserHandler(userStore store.UserStore, sessionStore store.SessionStore, twoFactorStore store.TwoFactorStore, throttleStore store.LoginThrottleStore, auditStore store.AuditStore, inviteStore store.InviteStore, registration string, sessionTTL, rememberTTL time.Duration, logger *slog.Logger) *UserHandler {
 	return &UserHandler{
-		userStore:    userStore,
-		sessionStore: sessionStore,
-		logger:       logger,
+		userStore:      userStore,
+		sessionStore:   sessionStore,
+		twoFactorStore: twoFactorStore,
+		throttleStore:  throttleStore,
+		auditStore:     auditStore,
+		inviteStore:    inviteStore,
+		registration:   registration,
+		sessionTTL:     sessionTTL,
+		rememberTTL:    rememberTTL,
+		logger:         logger,
 	}
 }
 
 func (h *UserHandler) validateCreateRequest(req *createUserRequest) error {
-	if strings.TrimSpace(req.Username) == "" {
-		return errors.New("username is required")
+	if err := ValidateUsername(req.Username); err != nil {
+		return err
 	}
-	if strings.TrimSpace(req.Password) == "" {
-		return errors.New("password is required")
+	if err := ValidatePassword(req.Password, req.Username); err != nil {
+		return err
+	}
+	if err := ValidateEmail(req.Email); err != nil {
+		return err
+	}
+	if h.registration == config.RegistrationInvite && strings.TrimSpace(req.InviteCode) == "" {
+		return errors.New("invite code is required")
 	}
 
 	return nil
 }
 
 func (h *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	if h.registration == config.RegistrationClosed {
+		_ = utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "registration is closed"})
+		return
+	}
+
 	var req createUserRequest
 
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		h.logger.Printf("ERROR: decoding HandleCreateUser %v", err)
+		h.logger.ErrorContext(r.Context(), "decoding HandleCreateUser", "error", err)
 		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request"})
 		return
 	}
 
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = NormalizeEmail(req.Email)
 	err = h.validateCreateRequest(&req)
 	if err != nil {
 		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
@@ -75,23 +127,36 @@ func (h *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 
 	user := &store.User{
 		Username: req.Username,
+		Email:    req.Email,
 	}
 
 	err = user.Password.Set(req.Password)
 	if err != nil {
-		h.logger.Printf("ERROR: hashing password %v", err)
+		h.logger.ErrorContext(r.Context(), "hashing password", "error", err)
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
 
-	err = h.userStore.CreateUser(user)
+	if h.registration == config.RegistrationInvite {
+		err = h.inviteStore.CreateUserWithInvite(user, req.InviteCode)
+	} else {
+		err = h.userStore.CreateUser(user)
+	}
+	if errors.Is(err, store.ErrInvalidInvite) {
+		_ = utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, store.ErrUsernameTaken) || errors.Is(err, store.ErrEmailTaken) {
+		_ = utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		h.logger.Printf("ERROR: creating user %v", err)
+		h.logger.ErrorContext(r.Context(), "creating user", "error", err)
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
 
-	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+	_ = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"user": user})
 }
 
 func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
@@ -102,7 +167,7 @@ func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	if contentType == "application/json" {
 		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
-			h.logger.Printf("ERROR: decoding HandleLogin %v", err)
+			h.logger.ErrorContext(r.Context(), "decoding HandleLogin", "error", err)
 			_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request"})
 			return
 		}
@@ -110,12 +175,13 @@ func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		// Handle form-encoded data
 		err := r.ParseForm()
 		if err != nil {
-			h.logger.Printf("ERROR: parsing form %v", err)
+			h.logger.ErrorContext(r.Context(), "parsing form", "error", err)
 			_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request"})
 			return
 		}
 		req.Username = r.FormValue("username")
 		req.Password = r.FormValue("password")
+		req.Remember = r.FormValue("remember-me") != ""
 	}
 
 	err := h.validateLoginRequest(&req)
@@ -129,9 +195,21 @@ func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	wait, err := h.loginWait(r, req.Username)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "checking login failures", "error", err)
+		h.loginError(w, r, contentType == "application/json", http.StatusInternalServerError, "/", "internal server error")
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		h.loginError(w, r, contentType == "application/json", http.StatusTooManyRequests, "/", "too many failed logins, try again in "+formatWait(wait))
+		return
+	}
+
 	user, err := h.userStore.GetUserByUsername(req.Username)
 	if err != nil {
-		h.logger.Printf("ERROR: getting user %v", err)
+		h.logger.ErrorContext(r.Context(), "getting user", "error", err)
 		if contentType != "application/json" {
 			http.Redirect(w, r, "/?error=internal server error", http.StatusSeeOther)
 			return
@@ -140,6 +218,10 @@ func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if user == nil {
+		// Take as long as a wrong password, not to give away which
+		// usernames exist
+		store.CompareDummyPassword(req.Password)
+		h.recordLoginFailure(r, store.AuditLoginFailed, nil, req.Username, "unknown user")
 		if contentType != "application/json" {
 			http.Redirect(w, r, "/?error=invalid credentials", http.StatusSeeOther)
 			return
@@ -150,7 +232,7 @@ func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 	matches, err := user.Password.Matches(req.Password)
 	if err != nil {
-		h.logger.Printf("ERROR: checking password %v", err)
+		h.logger.ErrorContext(r.Context(), "checking password", "error", err)
 		if contentType != "application/json" {
 			http.Redirect(w, r, "/?error=internal server error", http.StatusSeeOther)
 			return
@@ -159,6 +241,7 @@ func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !matches {
+		h.recordLoginFailure(r, store.AuditLoginFailed, user, req.Username, "wrong password")
 		if contentType != "application/json" {
 			http.Redirect(w, r, "/?error=invalid credentials", http.StatusSeeOther)
 			return
@@ -167,10 +250,15 @@ func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create session (24 hour expiration)
-	session, err := h.sessionStore.CreateSession(user.ID, 24*time.Hour)
+	// Only told after the password, not to give away who is disabled
+	if user.Disabled {
+		h.loginError(w, r, contentType == "application/json", http.StatusForbidden, "/", "this account is disabled")
+		return
+	}
+
+	totp, err := h.twoFactorStore.GetTOTP(user.ID)
 	if err != nil {
-		h.logger.Printf("ERROR: creating session %v", err)
+		h.logger.ErrorContext(r.Context(), "getting totp", "error", err)
 		if contentType != "application/json" {
 			http.Redirect(w, r, "/?error=internal server error", http.StatusSeeOther)
 			return
@@ -178,18 +266,171 @@ func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
+	if totp != nil && totp.Enabled {
+		h.startLoginChallenge(w, r, user, req.Remember, contentType == "application/json")
+		return
+	}
+
+	h.startSession(w, r, user, req.Remember, contentType == "application/json")
+}
+
+// startLoginChallenge asks for the second factor before a session is
+// issued. Browsers keep the challenge in a cookie, JSON clients send it back
+// with the code.
+func (h *UserHandler) startLoginChallenge(w http.ResponseWriter, r *http.Request, user *store.User, remember, isJSON bool) {
+	challenge := &store.LoginChallenge{
+		UserID:    user.ID,
+		Remember:  remember,
+		ExpiresAt: time.Now().Add(loginChallengeTTL),
+	}
+	if err := h.twoFactorStore.CreateLoginChallenge(challenge); err != nil {
+		h.logger.ErrorContext(r.Context(), "creating login challenge", "error", err)
+		h.loginError(w, r, isJSON, http.StatusInternalServerError, "/", "internal server error")
+		return
+	}
+
+	if isJSON {
+		_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+			"twoFactorRequired": true,
+			"challenge":         challenge.Token,
+		})
+		return
+	}
 
-	// Set HTTP-only cookie
 	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    session.Token,
-		Path:     "/",
-		MaxAge:   86400, // 24 hours in seconds
+		Name:     loginChallengeCookieName,
+		Value:    challenge.Token,
+		Path:     "/login",
+		MaxAge:   int(loginChallengeTTL.Seconds()),
 		HttpOnly: true,
 		Secure:   false, // Set to true in production with HTTPS
 		SameSite: http.SameSiteLaxMode,
 	})
 
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Redirect", "/login/2fa")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+}
+
+// HandleLoginTwoFactor completes a login with a code from the authenticator
+// app or a recovery code
+func (h *UserHandler) HandleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req loginTwoFactorRequest
+
+	isJSON := r.Header.Get("Content-Type") == "application/json"
+	if isJSON {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "decoding HandleLoginTwoFactor", "error", err)
+			_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request"})
+			return
+		}
+	} else {
+		req.Code = r.FormValue("code")
+		if cookie, err := r.Cookie(loginChallengeCookieName); err == nil {
+			req.Challenge = cookie.Value
+		}
+	}
+
+	challenge, err := h.twoFactorStore.GetLoginChallenge(req.Challenge)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "getting login challenge", "error", err)
+		h.loginError(w, r, isJSON, http.StatusInternalServerError, "/login/2fa", "internal server error")
+		return
+	}
+	if challenge == nil || challenge.Attempts >= maxLoginChallengeAttempts {
+		if challenge != nil {
+			_ = h.twoFactorStore.DeleteLoginChallenge(challenge.Token)
+		}
+		h.loginError(w, r, isJSON, http.StatusUnauthorized, "/", "login expired, please sign in again")
+		return
+	}
+
+	user, err := h.userStore.GetUserByID(challenge.UserID)
+	if err != nil || user == nil {
+		h.logger.ErrorContext(r.Context(), "getting user", "error", err)
+		h.loginError(w, r, isJSON, http.StatusInternalServerError, "/", "internal server error")
+		return
+	}
+
+	// Codes are guessed like passwords, so they count against the same limits
+	wait, err := h.loginWait(r, user.Username)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "checking login failures", "error", err)
+		h.loginError(w, r, isJSON, http.StatusInternalServerError, "/login/2fa", "internal server error")
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		h.loginError(w, r, isJSON, http.StatusTooManyRequests, "/login/2fa", "too many failed logins, try again in "+formatWait(wait))
+		return
+	}
+
+	ok, err := verifySecondFactor(h.twoFactorStore, user.ID, req.Code)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "verifying second factor", "error", err)
+		h.loginError(w, r, isJSON, http.StatusInternalServerError, "/login/2fa", "internal server error")
+		return
+	}
+	if !ok {
+		if err := h.twoFactorStore.AddLoginChallengeAttempt(challenge.Token); err != nil {
+			h.logger.ErrorContext(r.Context(), "counting login attempt", "error", err)
+		}
+		h.recordLoginFailure(r, store.AuditLoginTwoFactorFailed, user, user.Username, "invalid code")
+		h.loginError(w, r, isJSON, http.StatusUnauthorized, "/login/2fa", "invalid code")
+		return
+	}
+
+	if err := h.twoFactorStore.DeleteLoginChallenge(challenge.Token); err != nil {
+		h.logger.ErrorContext(r.Context(), "deleting login challenge", "error", err)
+	}
+	if !isJSON {
+		http.SetCookie(w, &http.Cookie{
+			Name:     loginChallengeCookieName,
+			Value:    "",
+			Path:     "/login",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   false, // Set to true in production with HTTPS
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	h.startSession(w, r, user, challenge.Remember, isJSON)
+}
+
+// startSession signs the user in and sends them to the dashboard
+func (h *UserHandler) startSession(w http.ResponseWriter, r *http.Request, user *store.User, remember, isJSON bool) {
+	// A session the browser brought along is replaced, not reused
+	if cookie, err := r.Cookie(middleware.SessionCookieName); err == nil && cookie.Value != "" {
+		if err := h.sessionStore.DeleteSession(cookie.Value); err != nil {
+			h.logger.ErrorContext(r.Context(), "deleting session", "error", err)
+		}
+	}
+
+	session := &store.Session{
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IP:        middleware.ClientIP(r),
+		Remember:  remember,
+		Lifetime:  h.sessionTTL,
+	}
+	if remember {
+		session.Lifetime = h.rememberTTL
+	}
+	err := h.sessionStore.CreateSession(session)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "creating session", "error", err)
+		h.loginError(w, r, isJSON, http.StatusInternalServerError, "/", "internal server error")
+		return
+	}
+
+	middleware.SetSessionCookie(w, session)
+	h.clearLoginFailures(r, user.Username)
+
 	// Check if HTMX request
 	isHTMX := r.Header.Get("HX-Request") == "true"
 	if isHTMX {
@@ -199,7 +440,7 @@ func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// For form submissions, redirect to dashboard
-	if contentType != "application/json" {
+	if !isJSON {
 		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 		return
 	}
@@ -211,28 +452,29 @@ func (h *UserHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// loginError sends form submissions back to page with the message, JSON
+// clients get it with status
+func (h *UserHandler) loginError(w http.ResponseWriter, r *http.Request, isJSON bool, status int, page, message string) {
+	if !isJSON {
+		http.Redirect(w, r, page+"?error="+url.QueryEscape(message), http.StatusSeeOther)
+		return
+	}
+	_ = utils.WriteJSON(w, status, utils.Envelope{"error": message})
+}
+
 func (h *UserHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	// Get session token from cookie
-	cookie, err := r.Cookie("session_token")
+	cookie, err := r.Cookie(middleware.SessionCookieName)
 	if err == nil && cookie.Value != "" {
 		// Delete session from database
 		err = h.sessionStore.DeleteSession(cookie.Value)
 		if err != nil {
-			h.logger.Printf("ERROR: deleting session %v", err)
+			h.logger.ErrorContext(r.Context(), "deleting session", "error", err)
 			// Continue anyway - we'll still clear the cookie
 		}
 	}
 
-	// Clear the session cookie by setting it to expire immediately
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1, // Immediately expire
-		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteLaxMode,
-	})
+	middleware.ClearSessionCookie(w)
 
 	// Check if HTMX request
 	isHTMX := r.Header.Get("HX-Request") == "true"
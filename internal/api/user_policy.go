@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 64
+	minPasswordLength = 10
+	// maxPasswordBytes is where bcrypt stops reading
+	maxPasswordBytes = 72
+	maxEmailLength   = 254
+)
+
+// ValidateUsername checks a username chosen by a user. Usernames of single
+// sign-on and proxy auth come from elsewhere and aren't checked.
+func ValidateUsername(username string) error {
+	if username == "" {
+		return errors.New("username is required")
+	}
+	if n := utf8.RuneCountInString(username); n < minUsernameLength || n > maxUsernameLength {
+		return fmt.Errorf("username must be %d to %d characters long", minUsernameLength, maxUsernameLength)
+	}
+	for _, c := range username {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("._-@", c):
+		default:
+			return errors.New("username may only contain letters, digits and . _ - @")
+		}
+	}
+	return nil
+}
+
+// ValidatePassword checks a new password of the user named username
+func ValidatePassword(password, username string) error {
+	if strings.TrimSpace(password) == "" {
+		return errors.New("password is required")
+	}
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters long", minPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes long", maxPasswordBytes)
+	}
+	if strings.EqualFold(password, username) {
+		return errors.New("password must not be the username")
+	}
+	return nil
+}
+
+// NormalizeEmail returns email the way it is stored, so lookups and the
+// unique index don't depend on case
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ValidateEmail checks a normalized email address. An empty one is fine, it
+// removes the address.
+func ValidateEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	if len(email) > maxEmailLength {
+		return fmt.Errorf("email address must be at most %d characters long", maxEmailLength)
+	}
+	// Only a bare address, no name or comments
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return errors.New("email address is invalid")
+	}
+	return nil
+}
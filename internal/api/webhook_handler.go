@@ -0,0 +1,231 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/webhook"
+)
+
+const (
+	// maxDeliveries is how many deliveries of a webhook are listed
+	maxDeliveries = 50
+	// minWebhookSecretLength keeps secrets from being guessed
+	minWebhookSecretLength = 16
+	maxWebhookFilterLength = 100
+)
+
+// WebhookHandler lets users manage the webhooks called with their new items
+type WebhookHandler struct {
+	webhookStore store.WebhookStore
+	feedStore    store.FeedStore
+	dispatcher   *webhook.Dispatcher
+	logger       *slog.Logger
+}
+
+func NewWebhookHandler(webhookStore store.WebhookStore, feedStore store.FeedStore, dispatcher *webhook.Dispatcher, logger *slog.Logger) *WebhookHandler {
+	return &WebhookHandler{
+		webhookStore: webhookStore,
+		feedStore:    feedStore,
+		dispatcher:   dispatcher,
+		logger:       logger,
+	}
+}
+
+type createWebhookRequest struct {
+	URL string `json:"url"`
+	// Secret is generated if empty
+	Secret   string `json:"secret"`
+	FeedID   int    `json:"feedId"`
+	Category string `json:"category"`
+	Keyword  string `json:"keyword"`
+}
+
+func (req *createWebhookRequest) validate() error {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	if req.Secret != "" && len(req.Secret) < minWebhookSecretLength {
+		return errors.New("secret must be at least 16 characters")
+	}
+	if len(req.Category) > maxWebhookFilterLength {
+		return errors.New("category must be at most 100 characters")
+	}
+	if len(req.Keyword) > maxWebhookFilterLength {
+		return errors.New("keyword must be at most 100 characters")
+	}
+	return nil
+}
+
+// HandleCreateWebhook returns the new webhook along with its secret. The
+// secret is shown only this once.
+func (wh *WebhookHandler) HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	var req createWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		wh.logger.ErrorContext(r.Context(), "decoding HandleCreateWebhook", "error", err)
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request"})
+		return
+	}
+	req.Category = strings.TrimSpace(req.Category)
+	req.Keyword = strings.TrimSpace(req.Keyword)
+	if err := req.validate(); err != nil {
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if req.FeedID != 0 {
+		feed, err := wh.feedStore.GetFeedByID(int64(req.FeedID))
+		if err != nil {
+			wh.logger.ErrorContext(r.Context(), "GetFeedByID", "error", err)
+			_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		if feed == nil || feed.UserID != user.ID {
+			_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "feed not found"})
+			return
+		}
+	}
+
+	if req.Secret == "" {
+		secret, err := store.NewWebhookSecret()
+		if err != nil {
+			wh.logger.ErrorContext(r.Context(), "NewWebhookSecret", "error", err)
+			_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		req.Secret = secret
+	}
+
+	hook := &store.Webhook{
+		UserID:   user.ID,
+		URL:      req.URL,
+		Secret:   req.Secret,
+		FeedID:   req.FeedID,
+		Category: req.Category,
+		Keyword:  req.Keyword,
+	}
+	if err := wh.webhookStore.CreateWebhook(hook); err != nil {
+		wh.logger.ErrorContext(r.Context(), "CreateWebhook", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	wh.logger.InfoContext(r.Context(), "webhook created", "webhook_id", hook.ID)
+
+	_ = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"webhook": hook, "secret": hook.Secret})
+}
+
+func (wh *WebhookHandler) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return
+	}
+
+	webhooks, err := wh.webhookStore.ListWebhooks(user.ID)
+	if err != nil {
+		wh.logger.ErrorContext(r.Context(), "ListWebhooks", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"webhooks": webhooks})
+}
+
+// userWebhook returns the webhook of the id parameter if it is the user's,
+// otherwise it writes the error response and returns nil
+func (wh *WebhookHandler) userWebhook(w http.ResponseWriter, r *http.Request) *store.Webhook {
+	user := utils.GetUserFromContext(r)
+	if user == nil {
+		_ = utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "unauthorized"})
+		return nil
+	}
+
+	webhookID, err := utils.ReadIDParam(r)
+	if err != nil {
+		_ = utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid webhook id"})
+		return nil
+	}
+	hook, err := wh.webhookStore.GetWebhook(int(webhookID))
+	if err != nil {
+		wh.logger.ErrorContext(r.Context(), "GetWebhook", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil
+	}
+	// Webhooks of other users don't exist as far as the user is concerned
+	if hook == nil || hook.UserID != user.ID {
+		_ = utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "webhook not found"})
+		return nil
+	}
+
+	return hook
+}
+
+func (wh *WebhookHandler) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook := wh.userWebhook(w, r)
+	if hook == nil {
+		return
+	}
+
+	err := wh.webhookStore.DeleteWebhook(hook.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "webhook not found"})
+		return
+	}
+	if err != nil {
+		wh.logger.ErrorContext(r.Context(), "DeleteWebhook", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListDeliveries returns the latest deliveries of the webhook, newest
+// first
+func (wh *WebhookHandler) HandleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook := wh.userWebhook(w, r)
+	if hook == nil {
+		return
+	}
+
+	deliveries, err := wh.webhookStore.ListWebhookDeliveries(hook.ID, maxDeliveries)
+	if err != nil {
+		wh.logger.ErrorContext(r.Context(), "ListWebhookDeliveries", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"deliveries": deliveries})
+}
+
+// HandleTestWebhook sends a ping event to the webhook and returns how it
+// went
+func (wh *WebhookHandler) HandleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	hook := wh.userWebhook(w, r)
+	if hook == nil {
+		return
+	}
+
+	delivery, err := wh.dispatcher.SendTest(r.Context(), hook)
+	if err != nil {
+		wh.logger.ErrorContext(r.Context(), "SendTest", "error", err)
+		_ = utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"delivery": delivery})
+}
@@ -2,64 +2,432 @@
 package app
 
 import (
+	"context"
 	"database/sql"
-	"log"
-	"net/http"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/netip"
+	"net/url"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/floriangaechter/rss/internal/api"
+	"github.com/floriangaechter/rss/internal/assets"
+	"github.com/floriangaechter/rss/internal/config"
+	"github.com/floriangaechter/rss/internal/digest"
+	"github.com/floriangaechter/rss/internal/events"
 	"github.com/floriangaechter/rss/internal/fetcher"
+	"github.com/floriangaechter/rss/internal/logging"
+	"github.com/floriangaechter/rss/internal/mailer"
+	"github.com/floriangaechter/rss/internal/metrics"
+	"github.com/floriangaechter/rss/internal/middleware"
+	"github.com/floriangaechter/rss/internal/notify"
+	"github.com/floriangaechter/rss/internal/oidc"
 	"github.com/floriangaechter/rss/internal/store"
-	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/internal/views"
+	"github.com/floriangaechter/rss/internal/webhook"
 	"github.com/floriangaechter/rss/migrations"
+	"github.com/floriangaechter/rss/static"
+	"github.com/floriangaechter/rss/templates"
 )
 
 type Application struct {
-	Logger       *log.Logger
-	FeedHandler  *api.FeedHandler
-	UserHandler  *api.UserHandler
-	PageHander   *api.PageHandler
-	SessionStore store.SessionStore
-	UserStore    store.UserStore
-	DB           *sql.DB
+	Config               config.Config
+	Logger               *slog.Logger
+	FeedHandler          *api.FeedHandler
+	UserHandler          *api.UserHandler
+	PageHander           *api.PageHandler
+	SettingsHandler      *api.SettingsHandler
+	AccountHandler       *api.AccountHandler
+	SessionHandler       *api.SessionHandler
+	TwoFactorHandler     *api.TwoFactorHandler
+	OIDCHandler          *api.OIDCHandler
+	InviteHandler        *api.InviteHandler
+	AdminHandler         *api.AdminHandler
+	PasswordResetHandler *api.PasswordResetHandler
+	DigestHandler        *api.DigestHandler
+	WebhookHandler       *api.WebhookHandler
+	AlertHandler         *api.AlertHandler
+	EventsHandler        *api.EventsHandler
+	SessionStore         store.SessionStore
+	UserStore            store.UserStore
+	UserSettingsStore    store.UserSettingsStore
+	TwoFactorStore       store.TwoFactorStore
+	LoginThrottleStore   store.LoginThrottleStore
+	AuditStore           store.AuditStore
+	PasswordResetStore   store.PasswordResetStore
+	WebhookStore         store.WebhookStore
+	ProxyAuth            *middleware.ProxyAuth
+	TrustedProxies       []netip.Prefix
+	FeedStore            store.FeedStore
+	Fetcher              *fetcher.Fetcher
+	Scheduler            *fetcher.Scheduler
+	DigestSender         *digest.Sender
+	WebhookDispatcher    *webhook.Dispatcher
+	Alerter              *notify.Alerter
+	Events               *events.Hub
+	Metrics              *metrics.Metrics
+	Assets               *assets.Assets
+	Renderer             *views.Renderer
+	DB                   *sql.DB
+
+	cancelWorkers context.CancelFunc
+	workers       sync.WaitGroup
 }
 
-func NewApplication() (*Application, error) {
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+// NewApplication opens and migrates the database and wires up the stores,
+// fetcher and handlers. Logs are written to logOutput.
+func NewApplication(cfg config.Config, logOutput io.Writer) (_ *Application, err error) {
+	logger, err := logging.New(logOutput, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	proxyAuth, err := newProxyAuth(cfg, trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	mail, err := newMailer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Registration {
+	case config.RegistrationOpen, config.RegistrationInvite, config.RegistrationClosed:
+	default:
+		return nil, fmt.Errorf("unknown registration mode %q", cfg.Registration)
+	}
+
 	sqliteDB, err := store.Open(logger)
 	if err != nil {
 		return nil, err
 	}
+	// Nobody gets to close the database if setting up fails
+	defer func() {
+		if err != nil {
+			_ = sqliteDB.Close()
+		}
+	}()
 
 	err = store.MigrateFS(sqliteDB, migrations.FS, ".")
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("migrating database: %w", err)
 	}
 
 	feedStore := store.NewSqlite3FeedStore(sqliteDB)
 	feedItemStore := store.NewSqlite3FeedItemStore(sqliteDB)
+	userSettingsStore := store.NewSqlite3UserSettingsStore(sqliteDB)
 	userStore := store.NewSqlite3UserStore(sqliteDB)
 	sessionStore := store.NewSqlite3SessionStore(sqliteDB)
+	twoFactorStore := store.NewSqlite3TwoFactorStore(sqliteDB)
+	identityStore := store.NewSqlite3IdentityStore(sqliteDB)
+	loginThrottleStore := store.NewSqlite3LoginThrottleStore(sqliteDB)
+	auditStore := store.NewSqlite3AuditStore(sqliteDB)
+	inviteStore := store.NewSqlite3InviteStore(sqliteDB)
+	adminStore := store.NewSqlite3AdminStore(sqliteDB)
+	passwordResetStore := store.NewSqlite3PasswordResetStore(sqliteDB)
+	digestStore := store.NewSqlite3DigestStore(sqliteDB)
+	webhookStore := store.NewSqlite3WebhookStore(sqliteDB)
+	alertStore := store.NewSqlite3AlertStore(sqliteDB)
+
+	appMetrics := metrics.New()
+	feedFetcher := fetcher.NewFetcher(feedStore, feedItemStore, cfg.FetchTimeout, appMetrics, logger)
+	webhookDispatcher := webhook.NewDispatcher(webhookStore, cfg.WebhookAllowPrivate, logger)
+	feedFetcher.OnNewItems(webhookDispatcher.Enqueue)
+	alerter := notify.NewAlerter(alertStore, logger)
+	feedFetcher.OnNewItems(alerter.Check)
+	hub := events.NewHub()
+	feedFetcher.OnNewItems(hub.PublishNewItems)
+	feedFetcher.OnFetchResult(hub.PublishFetchResult)
+	scheduler := fetcher.NewScheduler(feedFetcher, feedStore, cfg.FetchInterval, cfg.FetchWorkers, 2*cfg.FetchTimeout, logger)
 
-	fetcher := fetcher.NewFetcher(feedStore, feedItemStore, logger)
+	appMetrics.RegisterDB(sqliteDB)
+	appMetrics.RegisterGauge("active_sessions", "Sessions that have not expired yet.", func() float64 {
+		count, err := sessionStore.CountActiveSessions()
+		if err != nil {
+			logger.Error("metrics: CountActiveSessions", "error", err)
+		}
+		return float64(count)
+	})
+	appMetrics.RegisterGauge("scheduler_queue_depth", "Feeds of the current refresh cycle waiting for a worker.", func() float64 {
+		return float64(scheduler.QueueDepth())
+	})
+	appMetrics.RegisterGauge("event_streams", "Open event streams of the dashboard.", func() float64 {
+		return float64(hub.Subscribers())
+	})
 
-	feedHandler := api.NewFeedHanlder(feedStore, feedItemStore, fetcher, logger)
-	userHandler := api.NewUserHandler(userStore, sessionStore, logger)
-	pageHandler := api.NewPageHandler(feedStore, logger)
+	// Dev mode reads templates and static files from the working directory
+	// so they can be edited without rebuilding
+	var templateFS, staticFS fs.FS = templates.FS, static.FS
+	if cfg.Dev {
+		templateFS, staticFS = os.DirFS("templates"), os.DirFS("static")
+	}
+
+	staticAssets, err := assets.New(staticFS, cfg.Dev)
+	if err != nil {
+		return nil, err
+	}
+	var oidcProvider *oidc.Provider
+	if cfg.OIDCIssuer != "" && proxyAuth == nil {
+		oidcProvider, err = oidc.New(oidc.Config{
+			Issuer:        cfg.OIDCIssuer,
+			ClientID:      cfg.OIDCClientID,
+			ClientSecret:  cfg.OIDCClientSecret,
+			RedirectURL:   cfg.OIDCRedirectURL,
+			UsernameClaim: cfg.OIDCUsernameClaim,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	renderer, err := views.New(templateFS, template.FuncMap{
+		"asset":      staticAssets.Path,
+		"ssoEnabled": func() bool { return oidcProvider != nil },
+		// Proxy auth has no passwords to reset
+		"passwordResetEnabled": func() bool { return mail != nil && proxyAuth == nil },
+		"emailEnabled":         func() bool { return mail != nil },
+		// Behind the proxy there are no passwords, 2FA or renames
+		"proxyAuthEnabled": func() bool { return proxyAuth != nil },
+	}, cfg.Dev)
+	if err != nil {
+		return nil, err
+	}
+
+	feedHandler := api.NewFeedHanlder(feedStore, feedItemStore, feedFetcher, logger)
+	userHandler := api.NewUserHandler(userStore, sessionStore, twoFactorStore, loginThrottleStore, auditStore, inviteStore, cfg.Registration, cfg.SessionTTL, cfg.RememberTTL, logger)
+	pageHandler := api.NewPageHandler(feedStore, feedItemStore, userSettingsStore, feedFetcher, hub, renderer, logger)
+	settingsHandler := api.NewSettingsHandler(userSettingsStore, renderer, logger)
+	accountHandler := api.NewAccountHandler(userStore, sessionStore, userSettingsStore, feedStore, feedItemStore, auditStore, webhookStore, alertStore, digestStore, identityStore, mail, renderer, logger)
+	sessionHandler := api.NewSessionHandler(sessionStore, userSettingsStore, renderer, logger)
+	twoFactorHandler := api.NewTwoFactorHandler(twoFactorStore, sessionStore, userSettingsStore, renderer, logger)
+	inviteHandler := api.NewInviteHandler(inviteStore, logger)
+	webhookHandler := api.NewWebhookHandler(webhookStore, feedStore, webhookDispatcher, logger)
+	alertHandler := api.NewAlertHandler(alertStore, feedStore, alerter, logger)
+	eventsHandler := api.NewEventsHandler(hub, logger)
+	adminHandler := api.NewAdminHandler(adminStore, userStore, sessionStore, userSettingsStore, feedStore, auditStore, feedFetcher, renderer, logger)
+	var passwordResetHandler *api.PasswordResetHandler
+	if mail != nil && proxyAuth == nil {
+		passwordResetHandler = api.NewPasswordResetHandler(userStore, passwordResetStore, sessionStore, auditStore, mail, cfg.BaseURL, renderer, logger)
+	}
+	var digestHandler *api.DigestHandler
+	var digestSender *digest.Sender
+	if mail != nil {
+		digestHandler = api.NewDigestHandler(digestStore, feedStore, userSettingsStore, renderer, logger)
+		digestSender, err = digest.New(digestStore, mail, cfg.BaseURL, templateFS, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var oidcHandler *api.OIDCHandler
+	if oidcProvider != nil {
+		// Single sign-on doesn't get around invites or closed registration
+		autoRegister := cfg.OIDCAutoRegister && cfg.Registration == config.RegistrationOpen
+		if cfg.OIDCAutoRegister && !autoRegister {
+			logger.Warn("oidc: auto-register is off, it needs open registration", "registration", cfg.Registration)
+		}
+		oidcHandler = api.NewOIDCHandler(oidcProvider, userHandler, userStore, sessionStore, identityStore, userSettingsStore, autoRegister, renderer, logger)
+	}
 
 	app := &Application{
-		Logger:       logger,
-		FeedHandler:  feedHandler,
-		UserHandler:  userHandler,
-		PageHander:   pageHandler,
-		DB:           sqliteDB,
-		SessionStore: sessionStore,
-		UserStore:    userStore,
+		Config:               cfg,
+		Logger:               logger,
+		FeedHandler:          feedHandler,
+		UserHandler:          userHandler,
+		PageHander:           pageHandler,
+		SettingsHandler:      settingsHandler,
+		AccountHandler:       accountHandler,
+		SessionHandler:       sessionHandler,
+		TwoFactorHandler:     twoFactorHandler,
+		OIDCHandler:          oidcHandler,
+		InviteHandler:        inviteHandler,
+		AdminHandler:         adminHandler,
+		PasswordResetHandler: passwordResetHandler,
+		DigestHandler:        digestHandler,
+		WebhookHandler:       webhookHandler,
+		AlertHandler:         alertHandler,
+		EventsHandler:        eventsHandler,
+		UserSettingsStore:    userSettingsStore,
+		TwoFactorStore:       twoFactorStore,
+		LoginThrottleStore:   loginThrottleStore,
+		AuditStore:           auditStore,
+		PasswordResetStore:   passwordResetStore,
+		WebhookStore:         webhookStore,
+		ProxyAuth:            proxyAuth,
+		TrustedProxies:       trustedProxies,
+		DB:                   sqliteDB,
+		SessionStore:         sessionStore,
+		UserStore:            userStore,
+		FeedStore:            feedStore,
+		Fetcher:              feedFetcher,
+		Scheduler:            scheduler,
+		DigestSender:         digestSender,
+		WebhookDispatcher:    webhookDispatcher,
+		Alerter:              alerter,
+		Events:               hub,
+		Metrics:              appMetrics,
+		Assets:               staticAssets,
+		Renderer:             renderer,
 	}
 
 	return app, nil
 }
 
-func (a *Application) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"ping": "pong"})
+const (
+	// purgeInterval is how often expired and old records are deleted
+	purgeInterval = time.Hour
+	// webhookDeliveryRetention is how long the log of webhook deliveries
+	// goes back
+	webhookDeliveryRetention = 30 * 24 * time.Hour
+)
+
+// Start launches the background workers. They run until Shutdown is called.
+func (a *Application) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancelWorkers = cancel
+
+	if a.Config.FetchInterval > 0 {
+		a.runWorker(func() { a.Scheduler.Run(ctx) })
+	}
+	a.runWorker(func() { a.purgeExpired(ctx) })
+	a.runWorker(func() { a.WebhookDispatcher.Run(ctx) })
+	a.runWorker(func() { a.Alerter.Run(ctx) })
+	if a.DigestSender != nil {
+		a.runWorker(func() { a.DigestSender.Run(ctx) })
+	}
+}
+
+// purgeExpired deletes expired sessions, login challenges, login failures,
+// password resets and old webhook deliveries now and then every
+// purgeInterval until ctx is done
+func (a *Application) purgeExpired(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := a.SessionStore.DeleteExpiredSessions()
+		if err != nil {
+			a.Logger.Error("purge: sessions", "error", err)
+		} else if deleted > 0 {
+			a.Logger.Info("purge: deleted expired sessions", "count", deleted)
+		}
+		if _, err := a.TwoFactorStore.DeleteExpiredLoginChallenges(); err != nil {
+			a.Logger.Error("purge: login challenges", "error", err)
+		}
+		if _, err := a.LoginThrottleStore.DeleteStaleLoginFailures(time.Now().Add(-api.LoginFailureWindow)); err != nil {
+			a.Logger.Error("purge: login failures", "error", err)
+		}
+		if _, err := a.PasswordResetStore.DeleteExpiredPasswordResets(); err != nil {
+			a.Logger.Error("purge: password resets", "error", err)
+		}
+		if _, err := a.WebhookStore.DeleteOldWebhookDeliveries(time.Now().Add(-webhookDeliveryRetention)); err != nil {
+			a.Logger.Error("purge: webhook deliveries", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Application) runWorker(fn func()) {
+	a.workers.Add(1)
+	go func() {
+		defer a.workers.Done()
+		fn()
+	}()
+}
+
+// Shutdown stops the background workers, waits for in-flight fetches to
+// commit and emails to be sent and closes the database. It gives up waiting
+// once ctx is done, but always closes the database.
+func (a *Application) Shutdown(ctx context.Context) error {
+	if a.cancelWorkers != nil {
+		a.cancelWorkers()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		a.workers.Wait()
+		a.Fetcher.Wait()
+		a.AccountHandler.Wait()
+		if a.PasswordResetHandler != nil {
+			a.PasswordResetHandler.Wait()
+		}
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		a.Logger.Error("shutdown: gave up waiting for background work", "error", err)
+	}
+
+	if closeErr := a.DB.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newProxyAuth returns the proxy auth settings in proxy mode and nil in
+// session mode
+func newProxyAuth(cfg config.Config, trustedProxies []netip.Prefix) (*middleware.ProxyAuth, error) {
+	switch cfg.AuthMode {
+	case config.AuthModeSession:
+		return nil, nil
+	case config.AuthModeProxy:
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.AuthMode)
+	}
+
+	// Trusting the header from anywhere would let anyone sign in as anyone
+	if len(trustedProxies) == 0 {
+		return nil, errors.New("proxy auth mode needs -trusted-proxies")
+	}
+	if cfg.AuthProxyHeader == "" {
+		return nil, errors.New("proxy auth mode needs -auth-proxy-header")
+	}
+
+	return &middleware.ProxyAuth{Header: cfg.AuthProxyHeader, TrustedProxies: trustedProxies}, nil
+}
+
+// newMailer returns the SMTP mailer, or nil if no SMTP host is configured
+func newMailer(cfg config.Config) (mailer.Mailer, error) {
+	if cfg.SMTPHost == "" {
+		return nil, nil
+	}
+	// Links in emails can't be built from the Host header, anyone could set
+	// it to their own site
+	if cfg.BaseURL == "" {
+		return nil, errors.New("sending email needs -base-url")
+	}
+	baseURL, err := url.Parse(cfg.BaseURL)
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", cfg.BaseURL)
+	}
+
+	smtpMailer, err := mailer.NewSMTPMailer(mailer.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return smtpMailer, nil
 }
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/utils"
+	"github.com/floriangaechter/rss/migrations"
+)
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+	// checkWarn is worth a look but doesn't take the instance out of
+	// rotation
+	checkWarn = "warn"
+)
+
+// HandleLiveness reports that the process is up and serving requests. It
+// doesn't touch any dependency so a slow database doesn't get us restarted.
+func (a *Application) HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	_ = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"status": checkOK})
+}
+
+// HandleReadiness checks every dependency needed to serve traffic and
+// responds with 503 if any of them fails. Errors are logged, the response
+// only says what failed since the endpoint is public.
+func (a *Application) HandleReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	checks := utils.Envelope{
+		"database":   a.checkDatabase(ctx),
+		"migrations": a.checkMigrations(ctx),
+		"templates":  a.checkTemplates(ctx),
+		"scheduler":  a.checkScheduler(),
+	}
+
+	status, code := checkOK, http.StatusOK
+	for _, check := range checks {
+		switch check.(utils.Envelope)["status"] {
+		case checkFail:
+			status, code = checkFail, http.StatusServiceUnavailable
+		case checkWarn:
+			if status == checkOK {
+				status = checkWarn
+			}
+		}
+	}
+
+	_ = utils.WriteJSON(w, code, utils.Envelope{"status": status, "checks": checks})
+}
+
+func (a *Application) checkDatabase(ctx context.Context) utils.Envelope {
+	if err := a.DB.PingContext(ctx); err != nil {
+		a.Logger.ErrorContext(ctx, "health: database", "error", err)
+		return utils.Envelope{"status": checkFail, "error": "database unavailable"}
+	}
+	return utils.Envelope{"status": checkOK}
+}
+
+func (a *Application) checkMigrations(ctx context.Context) utils.Envelope {
+	current, latest, err := store.MigrationVersions(ctx, a.DB, migrations.FS)
+	if err != nil {
+		a.Logger.ErrorContext(ctx, "health: migrations", "error", err)
+		return utils.Envelope{"status": checkFail, "error": "migration status unavailable"}
+	}
+
+	result := utils.Envelope{"status": checkOK, "current": current, "latest": latest}
+	if current != latest {
+		result["status"] = checkFail
+	}
+	return result
+}
+
+func (a *Application) checkTemplates(ctx context.Context) utils.Envelope {
+	if err := a.Renderer.Check(); err != nil {
+		a.Logger.ErrorContext(ctx, "health: templates", "error", err)
+		return utils.Envelope{"status": checkFail, "error": "templates failed to load"}
+	}
+	if err := a.Assets.Check(); err != nil {
+		a.Logger.ErrorContext(ctx, "health: assets", "error", err)
+		return utils.Envelope{"status": checkFail, "error": "assets failed to load"}
+	}
+	return utils.Envelope{"status": checkOK}
+}
+
+func (a *Application) checkScheduler() utils.Envelope {
+	if a.Config.FetchInterval <= 0 {
+		return utils.Envelope{"status": checkOK, "enabled": false}
+	}
+
+	health := a.Scheduler.Health()
+	result := utils.Envelope{
+		"status":       checkOK,
+		"enabled":      true,
+		"running":      health.Running,
+		"startedAt":    health.StartedAt,
+		"lastCycleAt":  health.LastCycleAt,
+		"overdue":      health.Overdue,
+		"stuckWorkers": health.StuckWorkers,
+		"queueDepth":   a.Scheduler.QueueDepth(),
+	}
+	// A feed that hangs holds up one worker, the others carry on. Failing
+	// database queries show up as overdue cycles.
+	switch {
+	case !health.Running || health.Overdue:
+		result["status"] = checkFail
+	case len(health.StuckWorkers) > 0:
+		result["status"] = checkWarn
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+// Package assets serves the static files under content-hashed names so they
+// can be cached forever by browsers
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const Prefix = "/static/"
+
+type Assets struct {
+	fsys       fs.FS
+	dev        bool
+	fileServer http.Handler
+
+	// hashed maps a file name to its content-hashed name, byHash the other
+	// way around
+	hashed map[string]string
+	byHash map[string]string
+}
+
+// New indexes every file in fsys. In dev mode nothing is hashed, so files can
+// be edited without restarting the server.
+func New(fsys fs.FS, dev bool) (*Assets, error) {
+	a := &Assets{
+		fsys:       fsys,
+		dev:        dev,
+		fileServer: http.FileServerFS(fsys),
+		hashed:     make(map[string]string),
+		byHash:     make(map[string]string),
+	}
+	if dev {
+		return a, nil
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+
+		ext := path.Ext(name)
+		hashedName := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:6]) + ext
+		a.hashed[name] = hashedName
+		a.byHash[hashedName] = name
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("assets: index %w", err)
+	}
+
+	return a, nil
+}
+
+// Path returns the URL of the named file, e.g. "styles.css" becomes
+// "/static/styles.1a2b3c4d5e6f.css"
+func (a *Assets) Path(name string) string {
+	if hashedName, ok := a.hashed[name]; ok {
+		return Prefix + hashedName
+	}
+	return Prefix + name
+}
+
+// Check opens every indexed file
+func (a *Assets) Check() error {
+	if a.dev {
+		_, err := fs.ReadDir(a.fsys, ".")
+		return err
+	}
+
+	for name := range a.hashed {
+		f, err := a.fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		_ = f.Close()
+	}
+	return nil
+}
+
+// ServeHTTP serves files relative to Prefix. Hashed names never change
+// content and are cached for a year, plain names have to be revalidated.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" || strings.HasSuffix(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if original, ok := a.byHash[name]; ok {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + original
+		a.fileServer.ServeHTTP(w, r2)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	a.fileServer.ServeHTTP(w, r)
+}
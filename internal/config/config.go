@@ -0,0 +1,126 @@
+// Package config holds the runtime configuration of the server
+package config
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	AuthModeSession = "session"
+	AuthModeProxy   = "proxy"
+)
+
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationClosed = "closed"
+)
+
+type Config struct {
+	Port            int
+	ShutdownTimeout time.Duration
+	FetchInterval   time.Duration
+	FetchWorkers    int
+	FetchTimeout    time.Duration
+	SessionTTL      time.Duration
+	RememberTTL     time.Duration
+	// AuthMode is AuthModeSession or AuthModeProxy, the latter trusts
+	// AuthProxyHeader on requests from TrustedProxies. In either mode
+	// TrustedProxies may set X-Forwarded-For and X-Real-IP.
+	AuthMode        string
+	AuthProxyHeader string
+	TrustedProxies  string
+	// Registration is RegistrationOpen, RegistrationInvite or
+	// RegistrationClosed. Admins can always create users on the command line.
+	Registration string
+	// OIDC* configure single sign-on, which is off without an issuer
+	OIDCIssuer        string
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCRedirectURL   string
+	OIDCUsernameClaim string
+	OIDCAutoRegister  bool
+	// BaseURL is the address the server is reached at, used for links in
+	// emails
+	BaseURL string
+	// SMTP* configure sending email, which is off without a host
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	LogFormat    string
+	LogLevel     string
+	Dev          bool
+
+	// WebhookAllowPrivate lets webhooks call loopback, link-local and
+	// private addresses, for servers whose users are trusted with the
+	// network it runs in
+	WebhookAllowPrivate bool
+}
+
+// RegisterFlags binds every config field to a flag on fs. Defaults can be
+// overridden with RSS_* environment variables, flags take precedence.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.Port, "port", envInt("RSS_PORT", 8080), "Server port")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", envDuration("RSS_SHUTDOWN_TIMEOUT", 30*time.Second), "Time to drain requests and fetches on shutdown")
+	fs.DurationVar(&c.FetchInterval, "fetch-interval", envDuration("RSS_FETCH_INTERVAL", 30*time.Minute), "Interval between scheduled feed refreshes (0 disables)")
+	fs.IntVar(&c.FetchWorkers, "fetch-workers", envInt("RSS_FETCH_WORKERS", 4), "Number of concurrent feed fetches")
+	fs.DurationVar(&c.FetchTimeout, "fetch-timeout", envDuration("RSS_FETCH_TIMEOUT", 30*time.Second), "Timeout for a single feed fetch")
+	fs.DurationVar(&c.SessionTTL, "session-ttl", envDuration("RSS_SESSION_TTL", 24*time.Hour), "Inactivity after which a session expires")
+	fs.DurationVar(&c.RememberTTL, "remember-ttl", envDuration("RSS_REMEMBER_TTL", 30*24*time.Hour), "Inactivity after which a \"remember me\" session expires")
+	fs.StringVar(&c.AuthMode, "auth-mode", envString("RSS_AUTH_MODE", AuthModeSession), "How users sign in: session (login page) or proxy (trust -auth-proxy-header)")
+	fs.StringVar(&c.AuthProxyHeader, "auth-proxy-header", envString("RSS_AUTH_PROXY_HEADER", "X-Forwarded-User"), "Header an authenticating proxy puts the username in")
+	fs.StringVar(&c.TrustedProxies, "trusted-proxies", envString("RSS_TRUSTED_PROXIES", ""), "Comma separated CIDRs of the reverse proxies trusted with the client address, and the username in proxy auth mode")
+	fs.StringVar(&c.Registration, "registration", envString("RSS_REGISTRATION", RegistrationOpen), "Who may sign up: open (anyone), invite (with a code from an admin) or closed")
+	fs.StringVar(&c.OIDCIssuer, "oidc-issuer", envString("RSS_OIDC_ISSUER", ""), "OpenID Connect issuer URL, enables single sign-on")
+	fs.StringVar(&c.OIDCClientID, "oidc-client-id", envString("RSS_OIDC_CLIENT_ID", ""), "OpenID Connect client id")
+	fs.StringVar(&c.OIDCClientSecret, "oidc-client-secret", envString("RSS_OIDC_CLIENT_SECRET", ""), "OpenID Connect client secret, better set through the environment")
+	fs.StringVar(&c.OIDCRedirectURL, "oidc-redirect-url", envString("RSS_OIDC_REDIRECT_URL", ""), "Callback URL registered with the provider, ending in /login/oidc/callback")
+	fs.StringVar(&c.OIDCUsernameClaim, "oidc-username-claim", envString("RSS_OIDC_USERNAME_CLAIM", "preferred_username"), "ID token claim used as username")
+	fs.BoolVar(&c.OIDCAutoRegister, "oidc-auto-register", envBool("RSS_OIDC_AUTO_REGISTER", false), "Create users on their first single sign-on, needs -registration open")
+	fs.StringVar(&c.BaseURL, "base-url", envString("RSS_BASE_URL", ""), "Public URL of the server, like https://rss.example.com, used in links sent by email")
+	fs.StringVar(&c.SMTPHost, "smtp-host", envString("RSS_SMTP_HOST", ""), "SMTP server for sending email, enables password resets")
+	fs.IntVar(&c.SMTPPort, "smtp-port", envInt("RSS_SMTP_PORT", 587), "SMTP server port")
+	fs.StringVar(&c.SMTPUsername, "smtp-username", envString("RSS_SMTP_USERNAME", ""), "SMTP username, no authentication when empty")
+	fs.StringVar(&c.SMTPPassword, "smtp-password", envString("RSS_SMTP_PASSWORD", ""), "SMTP password, better set through the environment")
+	fs.StringVar(&c.SMTPFrom, "smtp-from", envString("RSS_SMTP_FROM", ""), "Sender address of email, like \"RSS <rss@example.com>\"")
+	fs.BoolVar(&c.WebhookAllowPrivate, "webhook-allow-private", envBool("RSS_WEBHOOK_ALLOW_PRIVATE", false), "Let webhooks call loopback, link-local and private addresses")
+	fs.StringVar(&c.LogFormat, "log-format", envString("RSS_LOG_FORMAT", "text"), "Log output format: text or json")
+	fs.StringVar(&c.LogLevel, "log-level", envString("RSS_LOG_LEVEL", "info"), "Minimum log level: debug, info, warn or error")
+	fs.BoolVar(&c.Dev, "dev", envBool("RSS_DEV", false), "Reload templates and static files from disk on every request")
+}
+
+func envString(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	v, err := strconv.Atoi(envString(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v, err := time.ParseDuration(envString(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envBool(key string, fallback bool) bool {
+	v, err := strconv.ParseBool(envString(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
@@ -0,0 +1,204 @@
+// Package digest emails users a daily or weekly summary of the items they
+// haven't read yet
+package digest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/mailer"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/views"
+)
+
+const (
+	// checkInterval is how often due digests are looked for
+	checkInterval = 5 * time.Minute
+	sendTimeout   = 30 * time.Second
+)
+
+// Sender sends the digests that are due
+type Sender struct {
+	digestStore store.DigestStore
+	mailer      mailer.Mailer
+	// baseURL is where the links in the email point to
+	baseURL string
+	html    *htmltemplate.Template
+	text    *texttemplate.Template
+	logger  *slog.Logger
+}
+
+// New parses the email/digest.html and email/digest.txt templates of fsys
+func New(digestStore store.DigestStore, mailer mailer.Mailer, baseURL string, fsys fs.FS, logger *slog.Logger) (*Sender, error) {
+	html, err := htmltemplate.ParseFS(fsys, "email/digest.html")
+	if err != nil {
+		return nil, fmt.Errorf("digest: parse %w", err)
+	}
+	text, err := texttemplate.ParseFS(fsys, "email/digest.txt")
+	if err != nil {
+		return nil, fmt.Errorf("digest: parse %w", err)
+	}
+	return &Sender{
+		digestStore: digestStore,
+		mailer:      mailer,
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		html:        html,
+		text:        text,
+		logger:      logger,
+	}, nil
+}
+
+// Next returns when the digest is sent next after the given time, at its
+// hour in loc. It is zero for digests that are off.
+func Next(digest *store.Digest, loc *time.Location, after time.Time) time.Time {
+	if digest.Schedule != store.DigestDaily && digest.Schedule != store.DigestWeekly {
+		return time.Time{}
+	}
+
+	local := after.In(loc)
+	for days := 0; ; days++ {
+		// time.Date normalizes the day and picks the right offset around
+		// daylight saving time changes
+		next := time.Date(local.Year(), local.Month(), local.Day()+days, digest.Hour, 0, 0, 0, loc)
+		if !next.After(after) {
+			continue
+		}
+		if digest.Schedule == store.DigestWeekly && next.Weekday() != digest.Weekday {
+			continue
+		}
+		return next
+	}
+}
+
+// Run sends due digests every checkInterval until ctx is done
+func (s *Sender) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		s.SendDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue sends the digests due at now. A digest that can't be sent stays
+// due and is tried again the next time.
+func (s *Sender) SendDue(ctx context.Context, now time.Time) {
+	due, err := s.digestStore.ListDueDigests(now)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "digest: ListDueDigests", "error", err)
+		return
+	}
+
+	for _, digest := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.send(ctx, digest, now); err != nil {
+			s.logger.ErrorContext(ctx, "digest: sending", "user_id", digest.UserID, "error", err)
+		}
+	}
+}
+
+func (s *Sender) send(ctx context.Context, digest *store.DueDigest, now time.Time) error {
+	loc, err := time.LoadLocation(digest.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	items, err := s.digestStore.GetDigestItems(digest.UserID, digest.LastItemID, digest.MaxItems)
+	if err != nil {
+		return err
+	}
+	next := Next(digest.Digest, loc, now)
+
+	// Nobody wants an email saying there is nothing new
+	if len(items.Items) == 0 {
+		return s.digestStore.AdvanceDigest(digest.UserID, items.LastItemID, next, false)
+	}
+
+	msg, err := s.message(digest, items, loc)
+	if err != nil {
+		return err
+	}
+	// An email on its way is sent even while shutting down, so it isn't
+	// sent again
+	sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sendTimeout)
+	defer cancel()
+	if err := s.mailer.Send(sendCtx, msg); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "digest: sent", "user_id", digest.UserID, "items", len(items.Items))
+	return s.digestStore.AdvanceDigest(digest.UserID, items.LastItemID, next, true)
+}
+
+type digestData struct {
+	Username string
+	Schedule string
+	Items    []digestItem
+	// More is how many unread items didn't make it into the digest
+	More        int
+	URL         string
+	SettingsURL string
+}
+
+type digestItem struct {
+	Title     string
+	FeedTitle string
+	Link      string
+	Published string
+}
+
+func (s *Sender) message(digest *store.DueDigest, items *store.DigestItems, loc *time.Location) (*mailer.Message, error) {
+	locale := views.Locale{Location: loc}
+	data := digestData{
+		Username:    digest.Username,
+		Schedule:    digest.Schedule,
+		More:        items.Total - len(items.Items),
+		URL:         s.baseURL + "/",
+		SettingsURL: s.baseURL + "/settings/digest",
+	}
+	for _, item := range items.Items {
+		data.Items = append(data.Items, digestItem{
+			Title:     item.Title,
+			FeedTitle: item.FeedTitle,
+			Link:      views.WebLink(item.Link),
+			Published: locale.FormatDate(item.PublishedAt),
+		})
+	}
+
+	var text, html bytes.Buffer
+	if err := s.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("digest: execute %w", err)
+	}
+	if err := s.html.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("digest: execute %w", err)
+	}
+
+	return &mailer.Message{
+		To:      digest.Email,
+		Subject: fmt.Sprintf("Your %s digest: %s", digest.Schedule, plural(items.Total, "new item")),
+		Body:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,211 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/mailer"
+	"github.com/floriangaechter/rss/internal/mailer/mailertest"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNext(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		digest store.Digest
+		after  time.Time
+		want   time.Time
+	}{
+		{
+			name:   "off",
+			digest: store.Digest{Schedule: store.DigestOff, Hour: 7},
+			after:  time.Date(2025, 3, 10, 6, 0, 0, 0, zurich),
+		},
+		{
+			name:   "daily later today",
+			digest: store.Digest{Schedule: store.DigestDaily, Hour: 7},
+			after:  time.Date(2025, 3, 10, 6, 0, 0, 0, zurich),
+			want:   time.Date(2025, 3, 10, 7, 0, 0, 0, zurich),
+		},
+		{
+			name:   "daily at the hour is tomorrow",
+			digest: store.Digest{Schedule: store.DigestDaily, Hour: 7},
+			after:  time.Date(2025, 3, 10, 7, 0, 0, 0, zurich),
+			want:   time.Date(2025, 3, 11, 7, 0, 0, 0, zurich),
+		},
+		{
+			name:   "daily across daylight saving time",
+			digest: store.Digest{Schedule: store.DigestDaily, Hour: 7},
+			after:  time.Date(2025, 3, 29, 8, 0, 0, 0, zurich),
+			want:   time.Date(2025, 3, 30, 5, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "weekly",
+			digest: store.Digest{Schedule: store.DigestWeekly, Hour: 7, Weekday: time.Monday},
+			after:  time.Date(2025, 3, 10, 8, 0, 0, 0, zurich),
+			want:   time.Date(2025, 3, 17, 7, 0, 0, 0, zurich),
+		},
+		{
+			name:   "weekly in the timezone of the user",
+			digest: store.Digest{Schedule: store.DigestWeekly, Hour: 0, Weekday: time.Tuesday},
+			after:  time.Date(2025, 3, 10, 22, 0, 0, 0, time.UTC),
+			want:   time.Date(2025, 3, 10, 23, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Next(&tt.digest, zurich, tt.after)
+			assert.True(t, tt.want.Equal(got), "got %v, want %v", got, tt.want)
+		})
+	}
+}
+
+// fakeStore hands out one digest and remembers how it was advanced
+type fakeStore struct {
+	store.DigestStore
+	due      *store.DueDigest
+	items    *store.DigestItems
+	advanced []advance
+}
+
+type advance struct {
+	lastItemID int64
+	nextAt     time.Time
+	sent       bool
+}
+
+func (s *fakeStore) ListDueDigests(now time.Time) ([]*store.DueDigest, error) {
+	if s.due.NextAt.After(now) {
+		return nil, nil
+	}
+	return []*store.DueDigest{s.due}, nil
+}
+
+func (s *fakeStore) GetDigestItems(userID int, afterID int64, limit int) (*store.DigestItems, error) {
+	if afterID >= s.items.LastItemID {
+		return &store.DigestItems{LastItemID: afterID}, nil
+	}
+	return s.items, nil
+}
+
+func (s *fakeStore) AdvanceDigest(userID int, lastItemID int64, nextAt time.Time, sent bool) error {
+	s.advanced = append(s.advanced, advance{lastItemID, nextAt, sent})
+	s.due.LastItemID = lastItemID
+	s.due.NextAt = nextAt
+	return nil
+}
+
+func newFakeStore(now time.Time) *fakeStore {
+	return &fakeStore{
+		due: &store.DueDigest{
+			Digest: &store.Digest{
+				UserID:   1,
+				Schedule: store.DigestDaily,
+				Hour:     7,
+				MaxItems: 2,
+				NextAt:   now,
+			},
+			Username:   "alice",
+			Email:      "alice@example.com",
+			Timezone:   "UTC",
+			LastItemID: 10,
+		},
+		items: &store.DigestItems{
+			Items: []*store.FeedItem{
+				{ID: 13, Title: "Fish & chips", FeedTitle: "News", Link: "https://example.com/13", PublishedAt: "2025-03-10T05:00:00Z"},
+				{ID: 12, Title: "Second", FeedTitle: "Blog", Link: "javascript:alert(1)", PublishedAt: "2025-03-09T05:00:00Z"},
+			},
+			Total:      3,
+			LastItemID: 13,
+		},
+	}
+}
+
+func newSender(t *testing.T, digestStore store.DigestStore, m mailer.Mailer) *Sender {
+	sender, err := New(digestStore, m, "https://rss.example.com/", os.DirFS("../../templates"), slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	return sender
+}
+
+func TestSendDue(t *testing.T) {
+	server := mailertest.NewServer(t)
+	m, err := mailer.NewSMTPMailer(server.Config())
+	require.NoError(t, err)
+
+	now := time.Date(2025, 3, 10, 7, 0, 0, 0, time.UTC)
+	digestStore := newFakeStore(now)
+	sender := newSender(t, digestStore, m)
+
+	sender.SendDue(context.Background(), now)
+	require.Equal(t, []advance{{13, now.AddDate(0, 0, 1), true}}, digestStore.advanced)
+
+	envelopes := server.Envelopes()
+	require.Len(t, envelopes, 1)
+	assert.Equal(t, []string{"<alice@example.com>"}, envelopes[0].To)
+	msg, err := envelopes[0].Message()
+	require.NoError(t, err)
+	assert.Equal(t, "Your daily digest: 3 new items", msg.Header.Get("Subject"))
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		// NextPart decodes quoted-printable
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+	}
+	require.Len(t, bodies, 2)
+	assert.Contains(t, bodies[0], "Fish & chips\r\nNews · March 10, 2025\r\nhttps://example.com/13\r\n")
+	assert.Contains(t, bodies[0], "and 1 more unread: https://rss.example.com/\r\n")
+	assert.Contains(t, bodies[0], "https://rss.example.com/settings/digest")
+	assert.Contains(t, bodies[1], `<a href="https://example.com/13"`)
+	assert.Contains(t, bodies[1], "Fish &amp; chips")
+	// Links that aren't web pages are left out
+	for _, body := range bodies {
+		assert.NotContains(t, body, "javascript")
+	}
+	assert.Contains(t, bodies[0], "Second\r\nBlog · March 9, 2025\r\n\r\n")
+
+	// Nothing is sent twice, a digest without new items isn't sent at all
+	now = now.AddDate(0, 0, 1)
+	sender.SendDue(context.Background(), now)
+	assert.Equal(t, advance{13, now.AddDate(0, 0, 1), false}, digestStore.advanced[1])
+	assert.Len(t, server.Envelopes(), 1)
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, msg *mailer.Message) error {
+	return errors.New("connection refused")
+}
+
+func TestSendDueFailure(t *testing.T) {
+	now := time.Date(2025, 3, 10, 7, 0, 0, 0, time.UTC)
+	digestStore := newFakeStore(now)
+	sender := newSender(t, digestStore, failingMailer{})
+
+	// The digest stays due and is tried again
+	sender.SendDue(context.Background(), now)
+	assert.Empty(t, digestStore.advanced)
+	assert.Equal(t, int64(10), digestStore.due.LastItemID)
+}
@@ -0,0 +1,176 @@
+// Package events fans live updates out to the open event streams of users.
+//
+// The fetcher and the handlers publish to a Hub, the /events endpoint
+// subscribes to it and writes what it gets as Server-Sent Events.
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/floriangaechter/rss/internal/store"
+)
+
+// Types of events
+const (
+	// TypeItems is sent when a fetch stored new items
+	TypeItems = "items"
+	// TypeRead is sent when items were marked read or unread
+	TypeRead = "read"
+	// TypeFeed is sent after a feed was fetched, with how it went
+	TypeFeed = "feed"
+)
+
+// bufferSize is how many events a subscriber may fall behind before it is
+// dropped
+const bufferSize = 32
+
+// Event is sent as the event type and the JSON of Data
+type Event struct {
+	Type string
+	Data any
+}
+
+type ItemsData struct {
+	FeedID  int   `json:"feedId"`
+	ItemIDs []int `json:"itemIds"`
+}
+
+type ReadData struct {
+	// ItemID is 0 when several items were marked at once
+	ItemID int   `json:"itemId,omitempty"`
+	Read   bool  `json:"read"`
+	Count  int64 `json:"count"`
+}
+
+type FeedData struct {
+	FeedID int `json:"feedId"`
+	// Error is empty if the fetch succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// ItemRead is the event of one item marked read or unread
+func ItemRead(itemID int, read bool) Event {
+	return Event{Type: TypeRead, Data: ReadData{ItemID: itemID, Read: read, Count: 1}}
+}
+
+// ItemsRead is the event of count items marked read at once
+func ItemsRead(count int64) Event {
+	return Event{Type: TypeRead, Data: ReadData{Read: true, Count: count}}
+}
+
+// Subscription gets the events of one user on C. C is closed when the
+// subscriber fell behind or the hub was closed, the stream should end then.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	userID int
+}
+
+// Hub keeps the subscriptions of every user
+type Hub struct {
+	mu            sync.Mutex
+	subscriptions map[int]map[*Subscription]struct{}
+	closed        bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subscriptions: map[int]map[*Subscription]struct{}{}}
+}
+
+// Subscribe returns a subscription to the events of the user. It must be
+// ended with Unsubscribe.
+func (h *Hub) Subscribe(userID int) *Subscription {
+	c := make(chan Event, bufferSize)
+	sub := &Subscription{C: c, c: c, userID: userID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(c)
+		return sub
+	}
+	if h.subscriptions[userID] == nil {
+		h.subscriptions[userID] = map[*Subscription]struct{}{}
+	}
+	h.subscriptions[userID][sub] = struct{}{}
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+// remove closes the channel of sub unless it was removed already. h.mu must
+// be held.
+func (h *Hub) remove(sub *Subscription) {
+	subs := h.subscriptions[sub.userID]
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subscriptions, sub.userID)
+	}
+	close(sub.c)
+}
+
+// Publish sends event to the subscriptions of the user. It never blocks, a
+// subscriber too slow to keep up is dropped and may reconnect to catch up.
+func (h *Hub) Publish(userID int, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscriptions[userID] {
+		select {
+		case sub.c <- event:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// Subscribers returns the number of open subscriptions
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, subs := range h.subscriptions {
+		n += len(subs)
+	}
+	return n
+}
+
+// Close ends every subscription, so the streams let the server shut down.
+// Later subscriptions are closed right away.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subscriptions {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
+
+// PublishNewItems tells the feed's user about the items a fetch stored. It
+// is meant for Fetcher.OnNewItems.
+func (h *Hub) PublishNewItems(ctx context.Context, feed *store.Feed, items []*store.FeedItem) {
+	data := ItemsData{FeedID: feed.ID, ItemIDs: make([]int, 0, len(items))}
+	for _, item := range items {
+		data.ItemIDs = append(data.ItemIDs, item.ID)
+	}
+	h.Publish(feed.UserID, Event{Type: TypeItems, Data: data})
+}
+
+// PublishFetchResult tells the feed's user how a fetch went. It is meant
+// for Fetcher.OnFetchResult.
+func (h *Hub) PublishFetchResult(ctx context.Context, feed *store.Feed, fetchErr error) {
+	data := FeedData{FeedID: feed.ID}
+	if fetchErr != nil {
+		data.Error = fetchErr.Error()
+	}
+	h.Publish(feed.UserID, Event{Type: TypeFeed, Data: data})
+}
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	alice1 := hub.Subscribe(1)
+	alice2 := hub.Subscribe(1)
+	bob := hub.Subscribe(2)
+	assert.Equal(t, 3, hub.Subscribers())
+
+	// Every subscription of the user gets the event, others don't
+	hub.Publish(1, ItemRead(42, true))
+	for _, sub := range []*Subscription{alice1, alice2} {
+		require.Len(t, sub.C, 1)
+		assert.Equal(t, Event{Type: TypeRead, Data: ReadData{ItemID: 42, Read: true, Count: 1}}, <-sub.C)
+	}
+	assert.Empty(t, bob.C)
+
+	hub.Unsubscribe(alice2)
+	hub.Unsubscribe(alice2)
+	_, ok := <-alice2.C
+	assert.False(t, ok)
+	assert.Equal(t, 2, hub.Subscribers())
+
+	hub.Close()
+	_, ok = <-alice1.C
+	assert.False(t, ok)
+	_, ok = <-bob.C
+	assert.False(t, ok)
+	assert.Zero(t, hub.Subscribers())
+	hub.Unsubscribe(bob)
+
+	late := hub.Subscribe(1)
+	_, ok = <-late.C
+	assert.False(t, ok)
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(1)
+	defer hub.Unsubscribe(sub)
+
+	for range bufferSize + 1 {
+		hub.Publish(1, ItemsRead(1))
+	}
+	assert.Zero(t, hub.Subscribers())
+
+	// The buffered events are still delivered before the channel ends
+	n := 0
+	for range sub.C {
+		n++
+	}
+	assert.Equal(t, bufferSize, n)
+}
+
+func TestFetcherEvents(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(1)
+	defer hub.Unsubscribe(sub)
+
+	feed := &store.Feed{ID: 7, UserID: 1}
+	hub.PublishNewItems(context.Background(), feed, []*store.FeedItem{{ID: 3}, {ID: 4}})
+	hub.PublishFetchResult(context.Background(), feed, nil)
+	hub.PublishFetchResult(context.Background(), feed, errors.New("unexpected status 404 Not Found"))
+	hub.PublishFetchResult(context.Background(), &store.Feed{ID: 8, UserID: 2}, nil)
+
+	require.Len(t, sub.C, 3)
+	assert.Equal(t, Event{Type: TypeItems, Data: ItemsData{FeedID: 7, ItemIDs: []int{3, 4}}}, <-sub.C)
+	assert.Equal(t, Event{Type: TypeFeed, Data: FeedData{FeedID: 7}}, <-sub.C)
+	assert.Equal(t, Event{Type: TypeFeed, Data: FeedData{FeedID: 7, Error: "unexpected status 404 Not Found"}}, <-sub.C)
+}
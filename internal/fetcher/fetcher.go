@@ -2,11 +2,18 @@
 package fetcher
 
 import (
+	"context"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/floriangaechter/rss/internal/logging"
+	"github.com/floriangaechter/rss/internal/metrics"
 	"github.com/floriangaechter/rss/internal/store"
 	"github.com/floriangaechter/rss/internal/utils"
 	"github.com/mmcdole/gofeed"
@@ -15,19 +22,99 @@ import (
 type Fetcher struct {
 	feedStore     store.FeedStore
 	feedItemStore store.FeedItemStore
-	logger        *log.Logger
+	client        *http.Client
+	timeout       time.Duration
+	metrics       *metrics.Metrics
+	logger        *slog.Logger
+	// onNewItems are called after a fetch stored new items
+	onNewItems []NewItemsFunc
+	// onFetchResult are called after every fetch of an existing feed
+	onFetchResult []FetchResultFunc
+
+	// inflight tracks running fetches so shutdown can wait for their
+	// inserts to finish before the database is closed
+	inflight sync.WaitGroup
 }
 
-func NewFetcher(feedStore store.FeedStore, feedItemStore store.FeedItemStore, logger *log.Logger) *Fetcher {
+// NewItemsFunc gets the items a fetch of the feed stored, in the order of
+// the feed. It runs on the goroutine of the fetch.
+type NewItemsFunc func(ctx context.Context, feed *store.Feed, items []*store.FeedItem)
+
+// FetchResultFunc gets the outcome of a fetch of the feed once it is
+// recorded, err is nil if the fetch succeeded
+type FetchResultFunc func(ctx context.Context, feed *store.Feed, err error)
+
+func NewFetcher(feedStore store.FeedStore, feedItemStore store.FeedItemStore, timeout time.Duration, metrics *metrics.Metrics, logger *slog.Logger) *Fetcher {
 	return &Fetcher{
 		feedStore:     feedStore,
 		feedItemStore: feedItemStore,
+		client:        &http.Client{},
+		timeout:       timeout,
+		metrics:       metrics,
 		logger:        logger,
 	}
 }
 
-func (f *Fetcher) FetchFeedItems(feedID int64) error {
-	feed, err := f.feedStore.GetFeedByID(feedID)
+// OnNewItems registers fn to be called with the new items of every fetch.
+// It must be called before fetching starts.
+func (f *Fetcher) OnNewItems(fn NewItemsFunc) {
+	f.onNewItems = append(f.onNewItems, fn)
+}
+
+// OnFetchResult registers fn to be called with the outcome of every fetch.
+// It must be called before fetching starts.
+func (f *Fetcher) OnFetchResult(fn FetchResultFunc) {
+	f.onFetchResult = append(f.onFetchResult, fn)
+}
+
+func (f *Fetcher) FetchFeedItems(ctx context.Context, feedID int64) (err error) {
+	f.inflight.Add(1)
+	defer f.inflight.Done()
+
+	ctx = logging.WithFeedID(ctx, feedID)
+	start := time.Now()
+	var statusCode, newItemsCount int
+	body := &countingReader{}
+	var feed *store.Feed
+	defer func() {
+		duration := time.Since(start)
+		outcome := metrics.FetchSuccess
+		switch {
+		case err != nil:
+			outcome = metrics.FetchError
+		case statusCode == http.StatusNotModified:
+			outcome = metrics.FetchNotModified
+		}
+		f.metrics.ObserveFetch(outcome, body.n, duration, newItemsCount)
+
+		// A missing feed has nothing to record the error on
+		if feed != nil {
+			var fetchErr string
+			if err != nil {
+				fetchErr = err.Error()
+			}
+			if recordErr := f.feedStore.RecordFetchResult(feedID, fetchErr); recordErr != nil {
+				f.logger.ErrorContext(ctx, "recording fetch result", "error", recordErr)
+			}
+			for _, fn := range f.onFetchResult {
+				fn(ctx, feed, err)
+			}
+		}
+
+		attrs := []any{
+			"duration", duration,
+			"status_code", statusCode,
+			"bytes", body.n,
+			"new_items", newItemsCount,
+		}
+		if err != nil {
+			f.logger.ErrorContext(ctx, "feed fetch failed", append(attrs, "error", err)...)
+			return
+		}
+		f.logger.InfoContext(ctx, "feed fetched", attrs...)
+	}()
+
+	feed, err = f.feedStore.GetFeedByID(feedID)
 	if err != nil {
 		return err
 	}
@@ -35,16 +122,47 @@ func (f *Fetcher) FetchFeedItems(feedID int64) error {
 		return errors.New("feed not found")
 	}
 
-	fp := gofeed.NewParser()
-	fp.UserAgent = "RSS/1.0"
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.Link, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "RSS/1.0")
+	if feed.ETag != "" {
+		req.Header.Set("If-None-Match", feed.ETag)
+	}
+	if feed.LastModified != "" {
+		req.Header.Set("If-Modified-Since", feed.LastModified)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
 
-	parsedFeed, err := fp.ParseURL(feed.Link)
+	statusCode = resp.StatusCode
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body.r = resp.Body
+	parsedFeed, err := gofeed.NewParser().Parse(body)
 	if err != nil {
 		return err
 	}
 
-	var newItemsCount int
+	var newItems []*store.FeedItem
+
 	for _, item := range parsedFeed.Items {
+		// Links are stored as the feed has them, they also tell items
+		// apart. Whether one is safe to open is decided where it is shown,
+		// with views.WebLink.
 		feedItem := &store.FeedItem{
 			FeedID:      feed.ID,
 			Title:       item.Title,
@@ -68,13 +186,60 @@ func (f *Fetcher) FetchFeedItems(feedID int64) error {
 				continue
 			}
 			// Some other error - log it but continue with other items
-			f.logger.Printf("ERROR: Failed to create feed item %s: %v", item.Link, err)
+			f.logger.ErrorContext(ctx, "failed to create feed item", "link", item.Link, "error", err)
 			continue
 		}
 
-		newItemsCount++
+		newItems = append(newItems, feedItem)
+	}
+	newItemsCount = len(newItems)
+
+	// Only remember the validators once the items are stored, so a
+	// response that failed to parse is requested in full again
+	err = f.feedStore.UpdateFeedCacheHeaders(feedID, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return err
+	}
+
+	if len(newItems) > 0 {
+		for _, fn := range f.onNewItems {
+			fn(ctx, feed, newItems)
+		}
 	}
 
-	f.logger.Printf("Fetched %d new items for feed %d", newItemsCount, feedID)
 	return nil
 }
+
+// Discover fetches link and returns a feed filled in with the title and
+// description it advertises. Nothing is stored.
+func (f *Fetcher) Discover(ctx context.Context, link string) (*store.Feed, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	parsedFeed, err := gofeed.NewParser().ParseURLWithContext(link, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.Feed{
+		Title:       strings.TrimSpace(parsedFeed.Title),
+		Description: strings.TrimSpace(parsedFeed.Description),
+		Link:        link,
+	}, nil
+}
+
+// Wait blocks until all running fetches have finished
+func (f *Fetcher) Wait() {
+	f.inflight.Wait()
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
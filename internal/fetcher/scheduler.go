@@ -0,0 +1,199 @@
+package fetcher
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/store"
+)
+
+// Scheduler periodically refreshes every feed using a fixed pool of workers
+type Scheduler struct {
+	fetcher    *Fetcher
+	feedStore  store.FeedStore
+	interval   time.Duration
+	workers    int
+	stuckAfter time.Duration
+	logger     *slog.Logger
+
+	// queued is the number of feeds of the current cycle still waiting
+	// for a free worker
+	queued atomic.Int64
+
+	mu        sync.Mutex
+	startedAt time.Time
+	lastCycle time.Time
+	busy      map[int]busyWorker
+}
+
+type busyWorker struct {
+	feedID int64
+	since  time.Time
+}
+
+// SchedulerHealth is a snapshot of the scheduler state for health checks
+type SchedulerHealth struct {
+	Running      bool          `json:"running"`
+	StartedAt    time.Time     `json:"startedAt"`
+	LastCycleAt  time.Time     `json:"lastCycleAt"`
+	Overdue      bool          `json:"overdue"`
+	StuckWorkers []StuckWorker `json:"stuckWorkers"`
+}
+
+type StuckWorker struct {
+	Worker int       `json:"worker"`
+	FeedID int64     `json:"feedID"`
+	Since  time.Time `json:"since"`
+}
+
+// NewScheduler returns a scheduler running workers concurrent fetches every
+// interval. A worker busy with one feed for longer than stuckAfter is
+// reported as stuck.
+func NewScheduler(fetcher *Fetcher, feedStore store.FeedStore, interval time.Duration, workers int, stuckAfter time.Duration, logger *slog.Logger) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Scheduler{
+		fetcher:    fetcher,
+		feedStore:  feedStore,
+		interval:   interval,
+		workers:    workers,
+		stuckAfter: stuckAfter,
+		logger:     logger,
+		busy:       make(map[int]busyWorker),
+	}
+}
+
+// Run refreshes all feeds every interval until ctx is cancelled. Fetches that
+// are already running when ctx is cancelled are allowed to finish, queued
+// ones are dropped.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.startedAt = time.Now()
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.startedAt = time.Time{}
+		s.mu.Unlock()
+	}()
+
+	queue := make(chan int64)
+	var cycle sync.WaitGroup
+
+	var wg sync.WaitGroup
+	for worker := range s.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for feedID := range queue {
+				s.setBusy(worker, feedID)
+				// Detach from ctx so a shutdown doesn't abort a fetch
+				// halfway. Failures are logged by the fetcher.
+				_ = s.fetcher.FetchFeedItems(context.WithoutCancel(ctx), feedID)
+				s.setIdle(worker)
+				cycle.Done()
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(queue)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.enqueue(ctx, queue, &cycle) {
+				cycle.Wait()
+				s.mu.Lock()
+				s.lastCycle = time.Now()
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// enqueue hands every feed to the workers and reports whether the whole
+// cycle was dispatched
+func (s *Scheduler) enqueue(ctx context.Context, queue chan<- int64, cycle *sync.WaitGroup) bool {
+	feeds, err := s.feedStore.GetAllFeeds()
+	if err != nil {
+		s.logger.Error("scheduler GetAllFeeds", "error", err)
+		return false
+	}
+
+	s.queued.Store(int64(len(feeds)))
+	defer s.queued.Store(0)
+
+	for _, feed := range feeds {
+		cycle.Add(1)
+		select {
+		case <-ctx.Done():
+			cycle.Done()
+			return false
+		case queue <- int64(feed.ID):
+			s.queued.Add(-1)
+		}
+	}
+
+	return true
+}
+
+func (s *Scheduler) setBusy(worker int, feedID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy[worker] = busyWorker{feedID: feedID, since: time.Now()}
+}
+
+func (s *Scheduler) setIdle(worker int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.busy, worker)
+}
+
+// QueueDepth returns the number of feeds waiting to be fetched
+func (s *Scheduler) QueueDepth() int64 {
+	return s.queued.Load()
+}
+
+// Health reports when the last full refresh cycle finished and which workers
+// have been busy with a single feed for too long. The scheduler is overdue
+// when no cycle has finished within two intervals.
+func (s *Scheduler) Health() SchedulerHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	health := SchedulerHealth{
+		Running:      !s.startedAt.IsZero(),
+		StartedAt:    s.startedAt,
+		LastCycleAt:  s.lastCycle,
+		StuckWorkers: []StuckWorker{},
+	}
+
+	if health.Running {
+		since := s.lastCycle
+		if since.IsZero() {
+			since = s.startedAt
+		}
+		health.Overdue = now.Sub(since) > 2*s.interval+s.stuckAfter
+	}
+
+	for worker, b := range s.busy {
+		if now.Sub(b.since) > s.stuckAfter {
+			health.StuckWorkers = append(health.StuckWorkers, StuckWorker{
+				Worker: worker,
+				FeedID: b.feedID,
+				Since:  b.since,
+			})
+		}
+	}
+
+	return health
+}
@@ -0,0 +1,127 @@
+// Package logging sets up the structured logger and carries per-request
+// log fields through the context
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// New returns a logger writing in the given format ("text" or "json") at the
+// given level. Records logged with a context pick up the request fields.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("logging: level %w", err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text", "":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+type contextKey string
+
+const fieldsContextKey contextKey = "logFields"
+
+// Fields are attached to every record logged with a context carrying them.
+// They are filled in as the request passes through middleware and handlers.
+type Fields struct {
+	RequestID string
+	UserID    int
+	FeedID    int64
+}
+
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, fieldsContextKey, &Fields{RequestID: requestID})
+}
+
+func FromContext(ctx context.Context) *Fields {
+	fields, _ := ctx.Value(fieldsContextKey).(*Fields)
+	return fields
+}
+
+func SetUserID(ctx context.Context, userID int) {
+	if fields := FromContext(ctx); fields != nil {
+		fields.UserID = userID
+	}
+}
+
+// SetFeedID records the feed id a handler works on in the request fields
+func SetFeedID(ctx context.Context, feedID int64) {
+	if fields := FromContext(ctx); fields != nil {
+		fields.FeedID = feedID
+	}
+}
+
+// WithFeedID returns a context whose fields are a copy of those of ctx with
+// the feed id, or new ones when ctx doesn't carry any (e.g. scheduled
+// fetches). Fetches running side by side each log their own feed.
+func WithFeedID(ctx context.Context, feedID int64) context.Context {
+	fields := Fields{FeedID: feedID}
+	if parent := FromContext(ctx); parent != nil {
+		fields = *parent
+		fields.FeedID = feedID
+	}
+	return context.WithValue(ctx, fieldsContextKey, &fields)
+}
+
+// Detach returns a context carrying a copy of the fields of ctx and nothing
+// else, for work that outlives the request
+func Detach(ctx context.Context) context.Context {
+	detached := context.Background()
+	if fields := FromContext(ctx); fields != nil {
+		copied := *fields
+		detached = context.WithValue(detached, fieldsContextKey, &copied)
+	}
+	return detached
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if fields := FromContext(ctx); fields != nil {
+		if fields.RequestID != "" {
+			r.AddAttrs(slog.String("request_id", fields.RequestID))
+		}
+		if fields.UserID != 0 {
+			r.AddAttrs(slog.Int("user_id", fields.UserID))
+		}
+		if fields.FeedID != 0 {
+			r.AddAttrs(slog.Int64("feed_id", fields.FeedID))
+		}
+	}
+
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			r.AddAttrs(slog.String("route", pattern))
+		}
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithFeedID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "text", "info")
+	require.NoError(t, err)
+
+	ctx := NewContext(context.Background(), "abc")
+	SetUserID(ctx, 7)
+	one := WithFeedID(ctx, 1)
+	two := WithFeedID(ctx, 2)
+
+	// Each fetch keeps its own feed, the request fields are left alone
+	logger.InfoContext(one, "one")
+	assert.Contains(t, buf.String(), "request_id=abc user_id=7 feed_id=1")
+	buf.Reset()
+	logger.InfoContext(two, "two")
+	assert.Contains(t, buf.String(), "request_id=abc user_id=7 feed_id=2")
+	assert.Zero(t, FromContext(ctx).FeedID)
+
+	SetFeedID(ctx, 3)
+	assert.Equal(t, int64(3), FromContext(ctx).FeedID)
+	assert.Equal(t, int64(1), FromContext(one).FeedID)
+
+	detached := Detach(ctx)
+	assert.Equal(t, &Fields{RequestID: "abc", UserID: 7, FeedID: 3}, FromContext(detached))
+	assert.NotSame(t, FromContext(ctx), FromContext(detached))
+
+	scheduled := WithFeedID(context.Background(), 4)
+	assert.Equal(t, &Fields{FeedID: 4}, FromContext(scheduled))
+}
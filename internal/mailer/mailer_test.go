@@ -0,0 +1,113 @@
+package mailer_test
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"strings"
+	"testing"
+
+	"github.com/floriangaechter/rss/internal/mailer"
+	"github.com/floriangaechter/rss/internal/mailer/mailertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSMTPMailerSend(t *testing.T) {
+	server := mailertest.NewServer(t)
+	config := server.Config()
+	config.Username = "rss"
+	config.Password = "secret"
+	m, err := mailer.NewSMTPMailer(config)
+	require.NoError(t, err)
+
+	err = m.Send(context.Background(), &mailer.Message{
+		To:      "alice@example.com",
+		Subject: "Reset your password",
+		Body:    "Open this link:\nhttps://rss.example.com/password/reset?token=" + strings.Repeat("ab", 32) + "\n",
+	})
+	require.NoError(t, err)
+
+	envelopes := server.Envelopes()
+	require.Len(t, envelopes, 1)
+	assert.Equal(t, "PLAIN AHJzcwBzZWNyZXQ=", envelopes[0].Auth)
+	assert.Equal(t, "<rss@example.com>", envelopes[0].From)
+	assert.Equal(t, []string{"<alice@example.com>"}, envelopes[0].To)
+
+	msg, err := envelopes[0].Message()
+	require.NoError(t, err)
+	assert.Equal(t, `"RSS" <rss@example.com>`, msg.Header.Get("From"))
+	assert.Equal(t, "<alice@example.com>", msg.Header.Get("To"))
+	assert.Equal(t, "Reset your password", msg.Header.Get("Subject"))
+	assert.Equal(t, "quoted-printable", msg.Header.Get("Content-Transfer-Encoding"))
+
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	require.NoError(t, err)
+	assert.Equal(t, "Open this link:\r\nhttps://rss.example.com/password/reset?token="+strings.Repeat("ab", 32)+"\r\n", string(body))
+}
+
+func TestSMTPMailerSendHTML(t *testing.T) {
+	server := mailertest.NewServer(t)
+	m, err := mailer.NewSMTPMailer(server.Config())
+	require.NoError(t, err)
+
+	err = m.Send(context.Background(), &mailer.Message{
+		To:      "alice@example.com",
+		Subject: "Your digest · 3 new items",
+		Body:    "3 new items",
+		HTML:    "<p>3 new items</p>",
+	})
+	require.NoError(t, err)
+
+	envelopes := server.Envelopes()
+	require.Len(t, envelopes, 1)
+	msg, err := envelopes[0].Message()
+	require.NoError(t, err)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Your digest · 3 new items", subject)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	// Plain text first, clients show the last part they understand
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var types, bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		types = append(types, part.Header.Get("Content-Type"))
+		bodies = append(bodies, string(body))
+	}
+	assert.Equal(t, []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"}, types)
+	assert.Equal(t, []string{"3 new items", "<p>3 new items</p>"}, bodies)
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	server := mailertest.NewServer(t)
+	m, err := mailer.NewSMTPMailer(server.Config())
+	require.NoError(t, err)
+
+	err = m.Send(context.Background(), &mailer.Message{To: "alice@example.com", Subject: "Hi\r\nBcc: eve@example.com", Body: "Hi"})
+	assert.Error(t, err)
+	err = m.Send(context.Background(), &mailer.Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hi", Body: "Hi"})
+	assert.Error(t, err)
+
+	assert.Empty(t, server.Envelopes())
+}
+
+func TestNewSMTPMailer(t *testing.T) {
+	_, err := mailer.NewSMTPMailer(mailer.SMTPConfig{Port: 587, From: "rss@example.com"})
+	assert.Error(t, err)
+	_, err = mailer.NewSMTPMailer(mailer.SMTPConfig{Host: "smtp.example.com", Port: 587, From: "not an address"})
+	assert.Error(t, err)
+}
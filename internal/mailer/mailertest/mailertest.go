@@ -0,0 +1,150 @@
+// Package mailertest runs a fake SMTP server for tests, so no real mail
+// service is needed
+package mailertest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/mailer"
+)
+
+// Envelope is one message as received by the server
+type Envelope struct {
+	// Auth is the argument of the AUTH command, if any
+	Auth string
+	From string
+	To   []string
+	// Data is the raw message with its headers
+	Data string
+}
+
+// Message parses the data of the envelope
+func (e Envelope) Message() (*mail.Message, error) {
+	return mail.ReadMessage(strings.NewReader(e.Data))
+}
+
+// Server speaks just enough SMTP for net/smtp and keeps what it receives
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	envelopes []Envelope
+}
+
+// NewServer starts a server on a free local port, it is stopped when the
+// test ends
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("mailertest: listen %v", err)
+	}
+
+	server := &Server{listener: listener}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.wg.Add(1)
+			go func() {
+				defer server.wg.Done()
+				server.serve(conn)
+			}()
+		}
+	}()
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+		server.wg.Wait()
+	})
+	return server
+}
+
+// Config returns the settings of a mailer sending to the server
+func (s *Server) Config() mailer.SMTPConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return mailer.SMTPConfig{Host: "localhost", Port: addr.Port, From: "RSS <rss@example.com>"}
+}
+
+// Envelopes returns the messages received so far
+func (s *Server) Envelopes() []Envelope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Envelope(nil), s.envelopes...)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = io.WriteString(conn, line+"\r\n")
+	}
+
+	var envelope Envelope
+	reply("220 localhost ESMTP mailertest")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			envelope.Auth = arg
+			reply("235 ok")
+		case "MAIL":
+			envelope.From = strings.TrimPrefix(arg, "FROM:")
+			reply("250 ok")
+		case "RCPT":
+			envelope.To = append(envelope.To, strings.TrimPrefix(arg, "TO:"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			envelope.Data = data.String()
+			s.mu.Lock()
+			s.envelopes = append(s.envelopes, envelope)
+			s.mu.Unlock()
+			envelope = Envelope{Auth: envelope.Auth}
+			reply("250 queued")
+		case "RSET":
+			envelope = Envelope{Auth: envelope.Auth}
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
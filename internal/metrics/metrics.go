@@ -0,0 +1,115 @@
+// Package metrics defines the Prometheus metrics exposed on /metrics
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "rss"
+
+// Fetch outcomes used as the "outcome" label on fetch metrics
+const (
+	FetchSuccess     = "success"
+	FetchNotModified = "not_modified"
+	FetchError       = "error"
+)
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+
+	fetches       *prometheus.CounterVec
+	fetchBytes    *prometheus.CounterVec
+	fetchDuration *prometheus.HistogramVec
+	itemsIngested prometheus.Counter
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method and route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		fetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "feed_fetches_total",
+			Help:      "Feed fetch attempts by outcome (success, not_modified, error).",
+		}, []string{"outcome"}),
+		fetchBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "feed_fetch_bytes_total",
+			Help:      "Bytes read from feed responses by outcome.",
+		}, []string{"outcome"}),
+		fetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "feed_fetch_duration_seconds",
+			Help:      "Feed fetch duration by outcome.",
+			Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"outcome"}),
+		itemsIngested: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "items_ingested_total",
+			Help:      "New feed items stored by the fetcher.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.fetches,
+		m.fetchBytes,
+		m.fetchDuration,
+		m.itemsIngested,
+	)
+
+	return m
+}
+
+// RegisterDB exposes the connection pool stats of db
+func (m *Metrics) RegisterDB(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, "sqlite"))
+}
+
+// RegisterGauge exposes a gauge whose value is computed by fn on every scrape
+func (m *Metrics) RegisterGauge(name, help string, fn func() float64) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration) {
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.httpRequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+func (m *Metrics) ObserveFetch(outcome string, bytes int64, duration time.Duration, newItems int) {
+	m.fetches.WithLabelValues(outcome).Inc()
+	m.fetchBytes.WithLabelValues(outcome).Add(float64(bytes))
+	m.fetchDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+	m.itemsIngested.Add(float64(newItems))
+}
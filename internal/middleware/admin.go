@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/floriangaechter/rss/internal/store"
+)
+
+// RequireAdmin only lets admins through. It goes after RequireAuth, which
+// puts the user into the context.
+func RequireAdmin(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _ := r.Context().Value(UserContextKey).(*store.User)
+			if user == nil {
+				handleUnauthorized(w, r, logger)
+				return
+			}
+			if !user.IsAdmin {
+				logger.WarnContext(r.Context(), "admin route denied", "user_id", user.ID, "path", r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -3,20 +3,40 @@ package middleware
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/floriangaechter/rss/internal/logging"
 	"github.com/floriangaechter/rss/internal/store"
 )
 
 type contextKey string
 
-const UserContextKey contextKey = "user"
+const (
+	UserContextKey      contextKey = "user"
+	SessionContextKey   contextKey = "session"
+	CSRFTokenContextKey contextKey = "csrf_token"
+	ClientIPContextKey  contextKey = "client_ip"
+)
+
+// SessionCookieName is the cookie holding the session token
+const SessionCookieName = "session_token"
+
+// sessionTouchInterval throttles the writes recording when a session was
+// last seen
+const sessionTouchInterval = 5 * time.Minute
 
-func RequireAuth(sessionStore store.SessionStore, userStore store.UserStore, logger *log.Logger) func(http.Handler) http.Handler {
+// RequireAuth puts the user of the session cookie into the request context,
+// or with proxyAuth set the user named by the proxy
+func RequireAuth(sessionStore store.SessionStore, userStore store.UserStore, proxyAuth *ProxyAuth, logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
+		if proxyAuth != nil {
+			return requireProxyAuth(proxyAuth, userStore, logger, next)
+		}
+
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("session_token")
+			cookie, err := r.Cookie(SessionCookieName)
 			if err != nil {
 				handleUnauthorized(w, r, logger)
 				return
@@ -24,7 +44,7 @@ func RequireAuth(sessionStore store.SessionStore, userStore store.UserStore, log
 
 			session, err := sessionStore.GetSession(cookie.Value)
 			if err != nil {
-				logger.Printf("ERROR: getting session %v", err)
+				logger.ErrorContext(r.Context(), "getting session", "error", err)
 				handleUnauthorized(w, r, logger)
 				return
 			}
@@ -35,22 +55,39 @@ func RequireAuth(sessionStore store.SessionStore, userStore store.UserStore, log
 
 			user, err := userStore.GetUserByID(session.UserID)
 			if err != nil {
-				logger.Printf("ERROR: getting user %v", err)
+				logger.ErrorContext(r.Context(), "getting user", "error", err)
 				handleUnauthorized(w, r, logger)
 				return
 			}
-			if user == nil {
+			// Disabled users are signed out too, in case disabling them
+			// didn't get to delete their sessions
+			if user == nil || user.Disabled {
 				handleUnauthorized(w, r, logger)
 				return
 			}
 
+			// Every use extends the session, remembered ones need the
+			// cookie extended as well
+			ip := ClientIP(r)
+			if time.Since(session.LastSeenAt) > sessionTouchInterval || session.IP != ip {
+				session.IP = ip
+				if err := sessionStore.TouchSession(session); err != nil {
+					// Not worth failing the request for
+					logger.ErrorContext(r.Context(), "touching session", "error", err)
+				} else if session.Remember {
+					SetSessionCookie(w, session)
+				}
+			}
+
+			logging.SetUserID(r.Context(), user.ID)
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
+			ctx = context.WithValue(ctx, SessionContextKey, session)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func handleUnauthorized(w http.ResponseWriter, r *http.Request, logger *log.Logger) {
+func handleUnauthorized(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
 	// Check if HTMX request
 	if r.Header.Get("HX-Request") == "true" {
 		w.Header().Set("HX-Redirect", "/")
@@ -61,3 +98,33 @@ func handleUnauthorized(w http.ResponseWriter, r *http.Request, logger *log.Logg
 	// Regular HTTP request - redirect to login
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// SetSessionCookie hands the session token to the browser as an HTTP-only
+// cookie. Only remembered sessions outlive the browser.
+func SetSessionCookie(w http.ResponseWriter, session *store.Session) {
+	cookie := &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		SameSite: http.SameSiteLaxMode,
+	}
+	if session.Remember {
+		cookie.MaxAge = int(time.Until(session.ExpiresAt).Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+// ClearSessionCookie removes the session cookie by expiring it immediately
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		SameSite: http.SameSiteLaxMode,
+	})
+}
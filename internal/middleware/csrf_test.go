@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRF(t *testing.T) {
+	handler := CSRF(slog.New(slog.NewTextHandler(io.Discard, nil)))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Context().Value(CSRFTokenContextKey).(string))
+	}))
+
+	// A GET hands out the token
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	token := cookies[0].Value
+	assert.Equal(t, token, w.Body.String())
+
+	post := func(body string, header map[string]string) int {
+		r := httptest.NewRequest(http.MethodPost, "/feeds", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for name, value := range header {
+			r.Header.Set(name, value)
+		}
+		r.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: token})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, post("", nil))
+	assert.Equal(t, http.StatusForbidden, post("csrf_token="+strings.Repeat("0", len(token)), nil))
+	assert.Equal(t, http.StatusOK, post(url.Values{CSRFField: {token}}.Encode(), nil))
+	assert.Equal(t, http.StatusOK, post("", map[string]string{CSRFHeader: token}))
+	assert.Equal(t, http.StatusOK, post("", map[string]string{"Authorization": "Bearer abc"}))
+}
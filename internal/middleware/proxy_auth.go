@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/floriangaechter/rss/internal/logging"
+	"github.com/floriangaechter/rss/internal/store"
+)
+
+// ProxyAuth trusts the username an authenticating reverse proxy sets in
+// Header, as long as the request comes straight from one of TrustedProxies
+type ProxyAuth struct {
+	Header         string
+	TrustedProxies []netip.Prefix
+}
+
+// trusted reports whether the request was sent by one of the proxies. The
+// forwarding headers don't count, the proxy has to be the peer itself.
+func (p *ProxyAuth) trusted(r *http.Request) bool {
+	addr, err := netip.ParseAddr(peerIP(r))
+	return err == nil && containsAddr(p.TrustedProxies, addr)
+}
+
+// authenticate returns the user named in the header, creating them on first
+// sight. It returns nil if the request can't be trusted or has no username.
+func (p *ProxyAuth) authenticate(r *http.Request, userStore store.UserStore, logger *slog.Logger) (*store.User, error) {
+	if !p.trusted(r) {
+		return nil, nil
+	}
+	username := strings.TrimSpace(r.Header.Get(p.Header))
+	if username == "" {
+		return nil, nil
+	}
+
+	user, err := userStore.GetUserByUsername(username)
+	if err != nil || user != nil {
+		return user, err
+	}
+
+	// The proxy does the authentication, the password is never used
+	user = &store.User{Username: username}
+	if err := user.Password.SetRandom(); err != nil {
+		return nil, err
+	}
+	if err := userStore.CreateUser(user); err != nil {
+		// A concurrent request may have created the user first
+		existing, getErr := userStore.GetUserByUsername(username)
+		if getErr != nil || existing == nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	logger.InfoContext(r.Context(), "proxy auth: created user", "username", username)
+	return user, nil
+}
+
+// requireProxyAuth is RequireAuth for the proxy mode. There is no login
+// page to send the user to, so requests without a trusted username are
+// refused.
+func requireProxyAuth(proxyAuth *ProxyAuth, userStore store.UserStore, logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := proxyAuth.authenticate(r, userStore, logger)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "proxy auth", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			if !proxyAuth.trusted(r) {
+				logger.WarnContext(r.Context(), "proxy auth: request from untrusted address", "ip", peerIP(r))
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if user.Disabled {
+			http.Error(w, "Account disabled", http.StatusForbidden)
+			return
+		}
+
+		logging.SetUserID(r.Context(), user.ID)
+		ctx := context.WithValue(r.Context(), UserContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
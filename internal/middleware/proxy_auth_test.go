@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyAuthTrusted(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1,,2001:db8::/32")
+	require.NoError(t, err)
+	proxyAuth := &ProxyAuth{Header: "X-Forwarded-User", TrustedProxies: trustedProxies}
+
+	for remoteAddr, trusted := range map[string]bool{
+		"10.1.2.3:1234":          true,
+		"192.0.2.1:1234":         true,
+		"192.0.2.2:1234":         false,
+		"[::ffff:10.0.0.1]:1234": true,
+		"[2001:db8::1]:1234":     true,
+		"[2001:db9::1]:1234":     false,
+		"garbage":                false,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		assert.Equal(t, trusted, proxyAuth.trusted(r), remoteAddr)
+	}
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies("proxy.local")
+	assert.Error(t, err)
+}
@@ -0,0 +1,213 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/logging"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/internal/views"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	// maxAlertsPerRule keeps a feed that suddenly matches a lot, like a
+	// newly added one, from flooding the notifier
+	maxAlertsPerRule = 5
+	// queueSize is how many rules with matches can wait for their
+	// notifications to be sent, more are dropped
+	queueSize = 100
+	// MaxPatternLength keeps patterns, and what it takes to match them,
+	// small
+	MaxPatternLength = 200
+)
+
+// Matcher tells whether an item matches an alert rule
+type Matcher struct {
+	field   string
+	keyword string
+	re      *regexp.Regexp
+}
+
+// NewMatcher returns the matcher of the rule, or an error if the pattern of
+// a regex rule doesn't compile. Keywords match regardless of case.
+func NewMatcher(rule *store.AlertRule) (*Matcher, error) {
+	m := &Matcher{field: rule.Field}
+	if !rule.Regex {
+		m.keyword = strings.ToLower(rule.Pattern)
+		return m, nil
+	}
+
+	re, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	m.re = re
+	return m, nil
+}
+
+func (m *Matcher) Match(item *store.FeedItem) bool {
+	switch m.field {
+	case store.AlertFieldTitle:
+		return m.matchString(item.Title)
+	case store.AlertFieldBody:
+		return m.matchString(item.Description)
+	default:
+		return m.matchString(item.Title) || m.matchString(item.Description)
+	}
+}
+
+func (m *Matcher) matchString(s string) bool {
+	if m.re != nil {
+		return m.re.MatchString(s)
+	}
+	return strings.Contains(strings.ToLower(s), m.keyword)
+}
+
+// Alerter sends notifications for the new items that match alert rules
+type Alerter struct {
+	alertStore store.AlertStore
+	client     *http.Client
+	logger     *slog.Logger
+	queue      chan *alert
+}
+
+// alert is a rule with the items it matched, waiting to be sent
+type alert struct {
+	// ctx carries the log fields of the fetch that found the items
+	ctx       context.Context
+	rule      *store.AlertRule
+	feedTitle string
+	items     []*store.FeedItem
+}
+
+func NewAlerter(alertStore store.AlertStore, logger *slog.Logger) *Alerter {
+	return &Alerter{
+		alertStore: alertStore,
+		client:     &http.Client{Timeout: requestTimeout},
+		logger:     logger,
+		queue:      make(chan *alert, queueSize),
+	}
+}
+
+// Check evaluates the rules of the feed against its new items and queues
+// notifications about those that match for Run to send. It is meant for
+// Fetcher.OnNewItems.
+func (a *Alerter) Check(ctx context.Context, feed *store.Feed, items []*store.FeedItem) {
+	rules, err := a.alertStore.ListFeedAlertRules(feed.ID)
+	if err != nil {
+		a.logger.ErrorContext(ctx, "alert: ListFeedAlertRules", "error", err)
+		return
+	}
+
+	for _, rule := range rules {
+		matcher, err := NewMatcher(rule)
+		if err != nil {
+			a.logger.WarnContext(ctx, "alert: invalid pattern", "rule_id", rule.ID, "error", err)
+			continue
+		}
+
+		var matched []*store.FeedItem
+		for _, item := range items {
+			if matcher.Match(item) {
+				matched = append(matched, item)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		if len(matched) > maxAlertsPerRule {
+			a.logger.InfoContext(ctx, "alert: too many matches", "rule_id", rule.ID, "matched", len(matched), "sent", maxAlertsPerRule)
+			matched = matched[:maxAlertsPerRule]
+		}
+
+		select {
+		case a.queue <- &alert{ctx: logging.Detach(ctx), rule: rule, feedTitle: feed.Title, items: matched}:
+		default:
+			a.logger.WarnContext(ctx, "alert: queue full, dropping notifications", "rule_id", rule.ID, "matched", len(matched))
+		}
+	}
+}
+
+// Run sends the queued notifications until ctx is done
+func (a *Alerter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case queued := <-a.queue:
+			a.send(ctx, queued)
+		}
+	}
+}
+
+// SendQueued sends the notifications queued so far
+func (a *Alerter) SendQueued(ctx context.Context) {
+	for ctx.Err() == nil {
+		select {
+		case queued := <-a.queue:
+			a.send(ctx, queued)
+		default:
+			return
+		}
+	}
+}
+
+// send notifies about the items of the alert, it stops early once ctx is
+// done
+func (a *Alerter) send(ctx context.Context, alert *alert) {
+	rule := alert.rule
+	notifier, err := a.notifier(rule.NotifierID)
+	if err != nil {
+		a.logger.ErrorContext(alert.ctx, "alert: loading notifier", "rule_id", rule.ID, "error", err)
+		return
+	}
+	// Deleted meanwhile, its rules are gone too
+	if notifier == nil {
+		return
+	}
+
+	for _, item := range alert.items {
+		if ctx.Err() != nil {
+			return
+		}
+		err := notifier.Notify(alert.ctx, &Notification{
+			Rule:      rule.Name,
+			FeedTitle: alert.feedTitle,
+			Title:     item.Title,
+			Link:      views.WebLink(item.Link),
+		})
+		if err != nil {
+			a.logger.WarnContext(alert.ctx, "alert: notification failed", "rule_id", rule.ID, "notifier_id", rule.NotifierID, "error", err)
+			// The notifier is likely down, the other items would fail
+			// the same way
+			return
+		}
+	}
+}
+
+// notifier returns nil if the notifier doesn't exist
+func (a *Alerter) notifier(id int) (Notifier, error) {
+	notifier, err := a.alertStore.GetNotifier(id)
+	if err != nil || notifier == nil {
+		return nil, err
+	}
+	return New(notifier, a.client)
+}
+
+// SendTest sends a test notification through the notifier right away
+func (a *Alerter) SendTest(ctx context.Context, notifier *store.Notifier) error {
+	n, err := New(notifier, a.client)
+	if err != nil {
+		return err
+	}
+	return n.Notify(ctx, &Notification{
+		Rule:      notifier.Name,
+		FeedTitle: "RSS",
+		Title:     "This is a test notification",
+	})
+}
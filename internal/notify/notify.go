@@ -0,0 +1,206 @@
+// Package notify sends alerts about new items to chat and push services.
+//
+// Notifiers speak the API of one kind of service: Slack compatible incoming
+// webhooks, the Matrix client-server API, and ntfy and Gotify push servers.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/floriangaechter/rss/internal/store"
+)
+
+// Notification is a new item that matched an alert rule
+type Notification struct {
+	// Rule is the name of the rule that matched
+	Rule      string
+	FeedTitle string
+	Title     string
+	Link      string
+}
+
+// Text is the notification as one line of plain text, without the link
+func (n *Notification) Text() string {
+	return oneLine(fmt.Sprintf("[%s] %s: %s", n.Rule, n.FeedTitle, n.Title))
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// New returns the notifier of the kind of notifier, sending with client
+func New(notifier *store.Notifier, client *http.Client) (Notifier, error) {
+	switch notifier.Kind {
+	case store.NotifierSlack:
+		return &Slack{URL: notifier.URL, Client: client}, nil
+	case store.NotifierMatrix:
+		return &Matrix{Homeserver: notifier.URL, Token: notifier.Token, Room: notifier.Room, Client: client}, nil
+	case store.NotifierNtfy:
+		return &Ntfy{URL: notifier.URL, Token: notifier.Token, Client: client}, nil
+	case store.NotifierGotify:
+		return &Gotify{URL: notifier.URL, Token: notifier.Token, Client: client}, nil
+	default:
+		return nil, fmt.Errorf("notify: unknown kind %q", notifier.Kind)
+	}
+}
+
+// Slack posts to a Slack incoming webhook, or one of the many services
+// accepting the same payload, like Mattermost and Rocket.Chat
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *Slack) Notify(ctx context.Context, n *Notification) error {
+	text := slackEscape(n.Text())
+	if n.Link != "" {
+		text += "\n<" + n.Link + ">"
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return send(ctx, s.Client, http.MethodPost, s.URL, "application/json", body, nil)
+}
+
+// slackEscape escapes the characters Slack reads as markup
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// Matrix sends a message to a room through the client-server API of a
+// homeserver. The user of the access token must have joined the room.
+type Matrix struct {
+	Homeserver string
+	Token      string
+	Room       string
+	Client     *http.Client
+}
+
+func (m *Matrix) Notify(ctx context.Context, n *Notification) error {
+	text := n.Text()
+	formatted := html.EscapeString(text)
+	if n.Link != "" {
+		text += "\n" + n.Link
+		formatted = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(n.Link), formatted)
+	}
+	body, err := json.Marshal(map[string]string{
+		"msgtype":        "m.text",
+		"body":           text,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": formatted,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The transaction id makes the homeserver drop the message if it is
+	// sent twice
+	txnID := make([]byte, 16)
+	if _, err := rand.Read(txnID); err != nil {
+		return err
+	}
+	endpoint := strings.TrimRight(m.Homeserver, "/") + "/_matrix/client/v3/rooms/" +
+		url.PathEscape(m.Room) + "/send/m.room.message/" + hex.EncodeToString(txnID)
+	header := http.Header{"Authorization": {"Bearer " + m.Token}}
+	return send(ctx, m.Client, http.MethodPut, endpoint, "application/json", body, header)
+}
+
+// Ntfy publishes to the topic URL of an ntfy server, like
+// https://ntfy.sh/mytopic. The token is optional.
+type Ntfy struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func (nt *Ntfy) Notify(ctx context.Context, n *Notification) error {
+	header := http.Header{
+		"Title": {oneLine(n.Rule + ": " + n.FeedTitle)},
+		"Tags":  {"newspaper"},
+	}
+	if n.Link != "" {
+		header.Set("Click", n.Link)
+	}
+	if nt.Token != "" {
+		header.Set("Authorization", "Bearer "+nt.Token)
+	}
+	body := oneLine(n.Title)
+	if body == "" {
+		body = n.Link
+	}
+	return send(ctx, nt.Client, http.MethodPost, nt.URL, "text/plain; charset=utf-8", []byte(body), header)
+}
+
+// Gotify creates a message on a Gotify server with an application token
+type Gotify struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+// gotifyPriority shows the message as a notification on most clients
+const gotifyPriority = 5
+
+func (g *Gotify) Notify(ctx context.Context, n *Notification) error {
+	message := oneLine(n.Title)
+	payload := map[string]any{
+		"title":    oneLine(n.Rule + ": " + n.FeedTitle),
+		"message":  message,
+		"priority": gotifyPriority,
+	}
+	if n.Link != "" {
+		payload["message"] = message + "\n" + n.Link
+		payload["extras"] = map[string]any{
+			"client::notification": map[string]any{"click": map[string]string{"url": n.Link}},
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"X-Gotify-Key": {g.Token}}
+	return send(ctx, g.Client, http.MethodPost, strings.TrimRight(g.URL, "/")+"/message", "application/json", body, header)
+}
+
+// send makes the request and returns an error unless the response is 2xx
+func send(ctx context.Context, client *http.Client, method, endpoint, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "RSS/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	// Reading the body lets the connection be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// oneLine collapses whitespace, titles of items may span lines and headers
+// can't
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,216 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// standIn records the requests it gets and answers with status
+type standIn struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+	w.WriteHeader(s.status)
+}
+
+func newStandIn(t *testing.T, status int) (*standIn, *httptest.Server) {
+	s := &standIn{status: status}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+var notification = &Notification{
+	Rule:      "CVEs",
+	FeedTitle: "Advisories",
+	Title:     "CVE-2026-1234 in <libfoo>\n",
+	Link:      "https://example.com/cve-2026-1234",
+}
+
+func TestSlack(t *testing.T) {
+	s, server := newStandIn(t, http.StatusOK)
+	n, err := New(&store.Notifier{Kind: store.NotifierSlack, URL: server.URL + "/services/T0/B0/x"}, server.Client())
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), notification))
+
+	require.Len(t, s.requests, 1)
+	assert.Equal(t, http.MethodPost, s.requests[0].Method)
+	assert.Equal(t, "/services/T0/B0/x", s.requests[0].URL.Path)
+	var payload map[string]string
+	require.NoError(t, json.Unmarshal(s.bodies[0], &payload))
+	assert.Equal(t, "[CVEs] Advisories: CVE-2026-1234 in &lt;libfoo&gt;\n<https://example.com/cve-2026-1234>", payload["text"])
+}
+
+func TestMatrix(t *testing.T) {
+	s, server := newStandIn(t, http.StatusOK)
+	n, err := New(&store.Notifier{Kind: store.NotifierMatrix, URL: server.URL + "/", Token: "syt_token", Room: "!abc:example.com"}, server.Client())
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), notification))
+	require.NoError(t, n.Notify(context.Background(), notification))
+
+	require.Len(t, s.requests, 2)
+	req := s.requests[0]
+	assert.Equal(t, http.MethodPut, req.Method)
+	assert.True(t, strings.HasPrefix(req.URL.Path, "/_matrix/client/v3/rooms/!abc:example.com/send/m.room.message/"), req.URL.Path)
+	assert.NotEqual(t, req.URL.Path, s.requests[1].URL.Path, "every message has its own transaction")
+	assert.Equal(t, "Bearer syt_token", req.Header.Get("Authorization"))
+	var payload map[string]string
+	require.NoError(t, json.Unmarshal(s.bodies[0], &payload))
+	assert.Equal(t, "m.text", payload["msgtype"])
+	assert.Equal(t, "[CVEs] Advisories: CVE-2026-1234 in <libfoo>\nhttps://example.com/cve-2026-1234", payload["body"])
+	assert.Equal(t, `<a href="https://example.com/cve-2026-1234">[CVEs] Advisories: CVE-2026-1234 in &lt;libfoo&gt;</a>`, payload["formatted_body"])
+}
+
+func TestNtfy(t *testing.T) {
+	s, server := newStandIn(t, http.StatusOK)
+	n, err := New(&store.Notifier{Kind: store.NotifierNtfy, URL: server.URL + "/alerts", Token: "tk_token"}, server.Client())
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), notification))
+
+	require.Len(t, s.requests, 1)
+	req := s.requests[0]
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, "/alerts", req.URL.Path)
+	assert.Equal(t, "CVEs: Advisories", req.Header.Get("Title"))
+	assert.Equal(t, "https://example.com/cve-2026-1234", req.Header.Get("Click"))
+	assert.Equal(t, "Bearer tk_token", req.Header.Get("Authorization"))
+	assert.Equal(t, "CVE-2026-1234 in <libfoo>", string(s.bodies[0]))
+
+	// The token is optional
+	n, err = New(&store.Notifier{Kind: store.NotifierNtfy, URL: server.URL + "/alerts"}, server.Client())
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), notification))
+	assert.Empty(t, s.requests[1].Header.Get("Authorization"))
+}
+
+func TestGotify(t *testing.T) {
+	s, server := newStandIn(t, http.StatusOK)
+	n, err := New(&store.Notifier{Kind: store.NotifierGotify, URL: server.URL, Token: "app_token"}, server.Client())
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), notification))
+
+	require.Len(t, s.requests, 1)
+	req := s.requests[0]
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, "/message", req.URL.Path)
+	assert.Equal(t, "app_token", req.Header.Get("X-Gotify-Key"))
+	var payload struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+		Extras   map[string]struct {
+			Click struct {
+				URL string `json:"url"`
+			} `json:"click"`
+		} `json:"extras"`
+	}
+	require.NoError(t, json.Unmarshal(s.bodies[0], &payload))
+	assert.Equal(t, "CVEs: Advisories", payload.Title)
+	assert.Equal(t, "CVE-2026-1234 in <libfoo>\nhttps://example.com/cve-2026-1234", payload.Message)
+	assert.Equal(t, gotifyPriority, payload.Priority)
+	assert.Equal(t, "https://example.com/cve-2026-1234", payload.Extras["client::notification"].Click.URL)
+}
+
+func TestNotifyFailure(t *testing.T) {
+	_, server := newStandIn(t, http.StatusUnauthorized)
+	n, err := New(&store.Notifier{Kind: store.NotifierGotify, URL: server.URL, Token: "wrong"}, server.Client())
+	require.NoError(t, err)
+	assert.ErrorContains(t, n.Notify(context.Background(), notification), "401")
+
+	_, err = New(&store.Notifier{Kind: "carrier-pigeon"}, server.Client())
+	assert.Error(t, err)
+}
+
+func TestMatcher(t *testing.T) {
+	item := &store.FeedItem{Title: "Security release: CVE-2026-1234", Description: "Fixes a heap overflow in the parser"}
+
+	match := func(rule *store.AlertRule) bool {
+		m, err := NewMatcher(rule)
+		require.NoError(t, err)
+		return m.Match(item)
+	}
+	assert.True(t, match(&store.AlertRule{Pattern: "SECURITY", Field: store.AlertFieldAny}))
+	assert.True(t, match(&store.AlertRule{Pattern: "overflow", Field: store.AlertFieldAny}))
+	assert.True(t, match(&store.AlertRule{Pattern: "overflow", Field: store.AlertFieldBody}))
+	assert.False(t, match(&store.AlertRule{Pattern: "overflow", Field: store.AlertFieldTitle}))
+	assert.True(t, match(&store.AlertRule{Pattern: `CVE-\d{4}-\d+`, Regex: true, Field: store.AlertFieldTitle}))
+	assert.False(t, match(&store.AlertRule{Pattern: `cve-\d{4}-\d+`, Regex: true, Field: store.AlertFieldTitle}))
+	assert.True(t, match(&store.AlertRule{Pattern: `(?i)cve-\d{4}-\d+`, Regex: true, Field: store.AlertFieldTitle}))
+
+	_, err := NewMatcher(&store.AlertRule{Pattern: "(unclosed", Regex: true})
+	assert.Error(t, err)
+}
+
+// fakeStore keeps rules and notifiers in memory
+type fakeStore struct {
+	store.AlertStore
+	notifiers []*store.Notifier
+	rules     []*store.AlertRule
+}
+
+func (s *fakeStore) GetNotifier(id int) (*store.Notifier, error) {
+	for _, notifier := range s.notifiers {
+		if notifier.ID == id {
+			return notifier, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeStore) ListFeedAlertRules(feedID int) ([]*store.AlertRule, error) {
+	return s.rules, nil
+}
+
+func TestAlerter(t *testing.T) {
+	s, server := newStandIn(t, http.StatusOK)
+	alertStore := &fakeStore{
+		notifiers: []*store.Notifier{{ID: 1, Kind: store.NotifierSlack, URL: server.URL}},
+		rules: []*store.AlertRule{
+			{ID: 1, Name: "CVEs", Pattern: `CVE-\d+`, Regex: true, Field: store.AlertFieldTitle, NotifierID: 1},
+			{ID: 2, Name: "Broken", Pattern: "(", Regex: true, NotifierID: 1},
+			{ID: 3, Name: "Gone", Pattern: "cve", NotifierID: 2},
+		},
+	}
+	alerter := NewAlerter(alertStore, slog.New(slog.DiscardHandler))
+
+	items := []*store.FeedItem{{Title: "Release notes", Link: "https://example.com/1"}}
+	for i := range maxAlertsPerRule + 2 {
+		items = append(items, &store.FeedItem{Title: "CVE-" + strings.Repeat("1", i+1), Link: "https://example.com/cve"})
+	}
+	alerter.Check(context.Background(), &store.Feed{ID: 7, Title: "Advisories"}, items)
+	assert.Empty(t, s.requests)
+	alerter.SendQueued(context.Background())
+
+	require.Len(t, s.requests, maxAlertsPerRule)
+	var payload map[string]string
+	require.NoError(t, json.Unmarshal(s.bodies[0], &payload))
+	assert.Equal(t, "[CVEs] Advisories: CVE-1\n<https://example.com/cve>", payload["text"])
+}
+
+func TestAlerterSendTest(t *testing.T) {
+	s, server := newStandIn(t, http.StatusOK)
+	alerter := NewAlerter(&fakeStore{}, slog.New(slog.DiscardHandler))
+	require.NoError(t, alerter.SendTest(context.Background(), &store.Notifier{Name: "Phone", Kind: store.NotifierNtfy, URL: server.URL + "/topic"}))
+	require.Len(t, s.requests, 1)
+	assert.Equal(t, "Phone: RSS", s.requests[0].Header.Get("Title"))
+}
@@ -0,0 +1,219 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider is a minimal OpenID Connect provider. authorize stands in for
+// the user signing in and approving the client.
+type fakeProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu sync.Mutex
+	// grants are the pending codes with the PKCE challenge and nonce they
+	// were issued for
+	grants map[string]fakeGrant
+	claims map[string]any
+}
+
+type fakeGrant struct {
+	challenge string
+	nonce     string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &fakeProvider{
+		t:      t,
+		key:    key,
+		grants: map[string]fakeGrant{},
+		claims: map[string]any{"preferred_username": "alice", "email": "alice@example.com"},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("GET /keys", p.handleKeys)
+	mux.HandleFunc("POST /token", p.handleToken)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *fakeProvider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *fakeProvider) handleKeys(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &p.key.PublicKey, KeyID: "test", Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+}
+
+func (p *fakeProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	grant, ok := p.grants[r.FormValue("code")]
+	delete(p.grants, r.FormValue("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   p.server.URL,
+		"sub":   "user-1",
+		"aud":   "rss",
+		"nonce": grant.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for name, value := range p.claims {
+		claims[name] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     p.sign(claims),
+	})
+}
+
+func (p *fakeProvider) sign(claims map[string]any) string {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: p.key, KeyID: "test"}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	require.NoError(p.t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(p.t, err)
+	signed, err := signer.Sign(payload)
+	require.NoError(p.t, err)
+	token, err := signed.CompactSerialize()
+	require.NoError(p.t, err)
+	return token
+}
+
+// authorize checks the authorization request and returns the code the
+// provider would redirect back with
+func (p *fakeProvider) authorize(authURL string) string {
+	u, err := url.Parse(authURL)
+	require.NoError(p.t, err)
+	query := u.Query()
+	assert.Equal(p.t, p.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(p.t, "code", query.Get("response_type"))
+	assert.Equal(p.t, "rss", query.Get("client_id"))
+	assert.Equal(p.t, "S256", query.Get("code_challenge_method"))
+
+	code := "code-" + query.Get("state")
+	p.mu.Lock()
+	p.grants[code] = fakeGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	p.mu.Unlock()
+	return code
+}
+
+func (p *fakeProvider) newProvider(t *testing.T, usernameClaim string) *Provider {
+	provider, err := New(Config{
+		Issuer:        p.server.URL,
+		ClientID:      "rss",
+		ClientSecret:  "secret",
+		RedirectURL:   "http://rss.test/login/oidc/callback",
+		UsernameClaim: usernameClaim,
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+func TestExchange(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider := fake.newProvider(t, "")
+	ctx := context.Background()
+
+	flow, err := NewFlow()
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(ctx, flow)
+	require.NoError(t, err)
+	assert.Contains(t, authURL, "state="+flow.State)
+	assert.NotContains(t, authURL, flow.Verifier)
+
+	identity, err := provider.Exchange(ctx, fake.authorize(authURL), flow)
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Issuer: fake.server.URL, Subject: "user-1", Username: "alice"}, identity)
+}
+
+func TestExchangeUsernameClaim(t *testing.T) {
+	fake := newFakeProvider(t)
+	ctx := context.Background()
+
+	provider := fake.newProvider(t, "email")
+	flow, err := NewFlow()
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(ctx, flow)
+	require.NoError(t, err)
+	identity, err := provider.Exchange(ctx, fake.authorize(authURL), flow)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", identity.Username)
+
+	provider = fake.newProvider(t, "nickname")
+	authURL, err = provider.AuthCodeURL(ctx, flow)
+	require.NoError(t, err)
+	_, err = provider.Exchange(ctx, fake.authorize(authURL), flow)
+	assert.ErrorContains(t, err, `no "nickname" claim`)
+}
+
+func TestExchangeRejectsForeignFlow(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider := fake.newProvider(t, "")
+	ctx := context.Background()
+
+	flow, err := NewFlow()
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(ctx, flow)
+	require.NoError(t, err)
+
+	// A code can only be redeemed with the verifier it was requested with
+	other, err := NewFlow()
+	require.NoError(t, err)
+	_, err = provider.Exchange(ctx, fake.authorize(authURL), other)
+	assert.ErrorContains(t, err, "exchanging code")
+
+	// and the ID token has to carry the nonce of the flow
+	wrongNonce := *flow
+	wrongNonce.Nonce = other.Nonce
+	_, err = provider.Exchange(ctx, fake.authorize(authURL), &wrongNonce)
+	assert.ErrorContains(t, err, "nonce")
+}
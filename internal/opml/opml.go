@@ -0,0 +1,119 @@
+// Package opml reads and writes OPML subscription lists
+package opml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type OPML struct {
+	XMLName xml.Name  `xml:"opml"`
+	Version string    `xml:"version,attr"`
+	Head    Head      `xml:"head"`
+	Body    []Outline `xml:"body>outline"`
+}
+
+type Head struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type Outline struct {
+	Text     string    `xml:"text,attr"`
+	Title    string    `xml:"title,attr,omitempty"`
+	Type     string    `xml:"type,attr,omitempty"`
+	XMLURL   string    `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string    `xml:"htmlUrl,attr,omitempty"`
+	Outlines []Outline `xml:"outline"`
+}
+
+// Subscription is a single feed found in an OPML document
+type Subscription struct {
+	Title    string
+	XMLURL   string
+	Category string
+}
+
+// Parse reads an OPML document and returns its feeds. Nested outlines are
+// treated as categories, using the name of the outermost one.
+func Parse(r io.Reader) ([]Subscription, error) {
+	var doc OPML
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("opml: decode %w", err)
+	}
+
+	var subscriptions []Subscription
+	var walk func(outlines []Outline, category string)
+	walk = func(outlines []Outline, category string) {
+		for _, o := range outlines {
+			if o.XMLURL != "" {
+				title := o.Title
+				if title == "" {
+					title = o.Text
+				}
+				subscriptions = append(subscriptions, Subscription{
+					Title:    title,
+					XMLURL:   o.XMLURL,
+					Category: category,
+				})
+				continue
+			}
+
+			nested := category
+			if nested == "" {
+				nested = o.Text
+			}
+			walk(o.Outlines, nested)
+		}
+	}
+	walk(doc.Body, "")
+
+	return subscriptions, nil
+}
+
+// Write encodes the subscriptions as an OPML 2.0 document
+func Write(w io.Writer, title string, subscriptions []Subscription) error {
+	doc := OPML{
+		Version: "2.0",
+		Head: Head{
+			Title:       title,
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+
+	categories := map[string]int{}
+	for _, s := range subscriptions {
+		outline := Outline{
+			Text:   s.Title,
+			Title:  s.Title,
+			Type:   "rss",
+			XMLURL: s.XMLURL,
+		}
+
+		if s.Category == "" {
+			doc.Body = append(doc.Body, outline)
+			continue
+		}
+
+		i, ok := categories[s.Category]
+		if !ok {
+			doc.Body = append(doc.Body, Outline{Text: s.Category, Title: s.Category})
+			i = len(doc.Body) - 1
+			categories[s.Category] = i
+		}
+		doc.Body[i].Outlines = append(doc.Body[i].Outlines, outline)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("opml: encode %w", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
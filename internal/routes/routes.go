@@ -3,35 +3,136 @@ package routes
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/floriangaechter/rss/internal/app"
+	"github.com/floriangaechter/rss/internal/assets"
 	"github.com/floriangaechter/rss/internal/middleware"
 	"github.com/go-chi/chi/v5"
 )
 
 func SetupRoutes(app *app.Application) *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP(app.TrustedProxies))
+	r.Use(middleware.RequestLogger(app.Logger))
+	r.Use(middleware.Metrics(app.Metrics))
+	r.Use(middleware.CSRF(app.Logger))
 
 	// Serve static files from /static/ path
-	r.Mount("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
+	r.Mount(assets.Prefix, http.StripPrefix(strings.TrimSuffix(assets.Prefix, "/"), app.Assets))
 
 	// Public routes
-	r.Get("/", app.PageHander.HandleHome)
-	r.Get("/health", app.HealthCheck)
-	r.Post("/users", app.UserHandler.HandleCreateUser)
-	r.Post("/login", app.UserHandler.HandleLogin)
+	if app.ProxyAuth != nil {
+		// The proxy signs users in, there is no login page
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		})
+	} else {
+		r.Get("/", app.PageHander.HandleHome)
+	}
+	r.Get("/health", app.HandleLiveness)
+	r.Get("/health/live", app.HandleLiveness)
+	r.Get("/health/ready", app.HandleReadiness)
+	r.Method(http.MethodGet, "/metrics", app.Metrics.Handler())
+	// Local login and registration, proxy auth leaves signing in to the proxy
+	if app.ProxyAuth == nil {
+		r.Post("/users", app.UserHandler.HandleCreateUser)
+		r.Post("/login", app.UserHandler.HandleLogin)
+		r.Get("/login/2fa", app.PageHander.HandleLoginTwoFactor)
+		r.Post("/login/2fa", app.UserHandler.HandleLoginTwoFactor)
+	}
+	// Password resets are only routed when email can be sent
+	if app.PasswordResetHandler != nil {
+		r.Get("/password/forgot", app.PasswordResetHandler.HandleForgotPassword)
+		r.Post("/password/forgot", app.PasswordResetHandler.HandleSendPasswordReset)
+		r.Get("/password/reset", app.PasswordResetHandler.HandleResetPassword)
+		r.Post("/password/reset", app.PasswordResetHandler.HandleSaveResetPassword)
+	}
+	// Single sign-on is only routed when configured
+	if app.OIDCHandler != nil {
+		r.Get("/login/oidc", app.OIDCHandler.HandleLogin)
+		r.Get("/login/oidc/callback", app.OIDCHandler.HandleCallback)
+	}
 
 	// Protected routes - require authentication
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.RequireAuth(app.SessionStore, app.UserStore, app.Logger))
+		r.Use(middleware.RequireAuth(app.SessionStore, app.UserStore, app.ProxyAuth, app.Logger))
 
 		r.Get("/dashboard", app.PageHander.HandleDashboard)
+		r.Get("/dashboard/items", app.PageHander.HandleItems)
+		r.Get("/dashboard/sidebar", app.PageHander.HandleSidebar)
+		r.Post("/dashboard/items/read", app.PageHander.HandleMarkAllRead)
+		r.Get("/dashboard/items/{id}", app.PageHander.HandleItem)
+		r.Post("/dashboard/items/{id}/read", app.PageHander.HandleToggleRead)
+		r.Post("/dashboard/items/{id}/star", app.PageHander.HandleToggleStar)
+		r.Post("/dashboard/refresh", app.PageHander.HandleRefresh)
+		r.Get("/events", app.EventsHandler.HandleEvents)
+		r.Get("/dashboard/feeds/new", app.PageHander.HandleNewFeed)
+		r.Post("/dashboard/feeds", app.PageHander.HandleAddFeed)
 		r.Post("/logout", app.UserHandler.HandleLogout)
+		r.Get("/settings", app.SettingsHandler.HandleSettings)
+		r.Post("/settings", app.SettingsHandler.HandleSaveSettings)
+		if app.DigestHandler != nil {
+			r.Get("/settings/digest", app.DigestHandler.HandleDigest)
+			r.Post("/settings/digest", app.DigestHandler.HandleSaveDigest)
+		}
+		r.Get("/account", app.AccountHandler.HandleAccount)
+		r.Get("/account/export", app.AccountHandler.HandleExport)
+		r.Post("/account/email", app.AccountHandler.HandleChangeEmail)
+		// Proxy users have a password they never learn and a username the
+		// proxy picks, signing in is up to the proxy
+		if app.ProxyAuth == nil {
+			r.Post("/account/username", app.AccountHandler.HandleChangeUsername)
+			r.Post("/account/password", app.AccountHandler.HandleChangePassword)
+			r.Post("/account/delete", app.AccountHandler.HandleDeleteAccount)
+			r.Get("/account/2fa", app.TwoFactorHandler.HandleTwoFactor)
+			r.Post("/account/2fa/setup", app.TwoFactorHandler.HandleSetup)
+			r.Post("/account/2fa/enable", app.TwoFactorHandler.HandleEnable)
+			r.Post("/account/2fa/recovery-codes", app.TwoFactorHandler.HandleRecoveryCodes)
+			r.Post("/account/2fa/disable", app.TwoFactorHandler.HandleDisable)
+		}
+		if app.OIDCHandler != nil {
+			r.Get("/account/sso", app.OIDCHandler.HandleIdentities)
+			r.Post("/account/sso/link", app.OIDCHandler.HandleLink)
+			r.Post("/account/sso/{id}/unlink", app.OIDCHandler.HandleUnlink)
+		}
+		r.Get("/account/sessions", app.SessionHandler.HandleSessions)
+		r.Post("/account/sessions/others/logout", app.SessionHandler.HandleLogoutOtherSessions)
+		r.Post("/account/sessions/{id}/logout", app.SessionHandler.HandleLogoutSession)
+		r.Get("/sessions", app.SessionHandler.HandleListSessions)
+		r.Delete("/sessions", app.SessionHandler.HandleDeleteOtherSessions)
+		r.Delete("/sessions/{id}", app.SessionHandler.HandleDeleteSession)
 		r.Get("/feeds/{id}", app.FeedHandler.HandleGetFeedByID)
 		r.Post("/feeds", app.FeedHandler.HandleCreateFeed)
 		r.Put("/feeds/{id}", app.FeedHandler.HandleUpdateFeedByID)
 		r.Delete("/feeds/{id}", app.FeedHandler.HandleDeleteFeedByID)
 		r.Post("/feeds/{id}/fetch", app.FeedHandler.HandleFetchFeedItems)
+		r.Get("/webhooks", app.WebhookHandler.HandleListWebhooks)
+		r.Post("/webhooks", app.WebhookHandler.HandleCreateWebhook)
+		r.Delete("/webhooks/{id}", app.WebhookHandler.HandleDeleteWebhook)
+		r.Get("/webhooks/{id}/deliveries", app.WebhookHandler.HandleListDeliveries)
+		r.Post("/webhooks/{id}/test", app.WebhookHandler.HandleTestWebhook)
+		r.Get("/notifiers", app.AlertHandler.HandleListNotifiers)
+		r.Post("/notifiers", app.AlertHandler.HandleCreateNotifier)
+		r.Delete("/notifiers/{id}", app.AlertHandler.HandleDeleteNotifier)
+		r.Post("/notifiers/{id}/test", app.AlertHandler.HandleTestNotifier)
+		r.Get("/alerts", app.AlertHandler.HandleListAlertRules)
+		r.Post("/alerts", app.AlertHandler.HandleCreateAlertRule)
+		r.Delete("/alerts/{id}", app.AlertHandler.HandleDeleteAlertRule)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireAdmin(app.Logger))
+
+			r.Get("/invites", app.InviteHandler.HandleListInvites)
+			r.Post("/invites", app.InviteHandler.HandleCreateInvite)
+			r.Delete("/invites/{id}", app.InviteHandler.HandleDeleteInvite)
+			r.Get("/admin", app.AdminHandler.HandleAdmin)
+			r.Post("/admin/users/{id}/disable", app.AdminHandler.HandleDisableUser)
+			r.Post("/admin/users/{id}/enable", app.AdminHandler.HandleEnableUser)
+			r.Post("/admin/users/{id}/delete", app.AdminHandler.HandleDeleteUser)
+			r.Post("/admin/feeds/{id}/refresh", app.AdminHandler.HandleRefreshFeed)
+		})
 	})
 
 	return r
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserStats is a user as listed for admins
+type UserStats struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	IsAdmin  bool   `json:"isAdmin"`
+	Disabled bool   `json:"disabled"`
+	Feeds    int    `json:"feeds"`
+	Items    int    `json:"items"`
+	// StorageBytes estimates the space taken by the feeds and items of the
+	// user, without indexes
+	StorageBytes int64 `json:"storageBytes"`
+}
+
+// FeedSubscriptions are the feeds of a link across users
+type FeedSubscriptions struct {
+	Link        string `json:"link"`
+	Title       string `json:"title"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// FailingFeed is a feed whose last fetches failed
+type FailingFeed struct {
+	ID             int       `json:"id"`
+	Username       string    `json:"username"`
+	Title          string    `json:"title"`
+	Link           string    `json:"link"`
+	FetchErrors    int       `json:"fetchErrors"`
+	LastFetchError string    `json:"lastFetchError"`
+	LastFetchedAt  time.Time `json:"lastFetchedAt"`
+}
+
+type Sqlite3AdminStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3AdminStore(db *sql.DB) *Sqlite3AdminStore {
+	return &Sqlite3AdminStore{db: db}
+}
+
+// AdminStore answers questions about the whole instance, the other stores
+// only look at one user at a time
+type AdminStore interface {
+	ListUserStats() ([]*UserStats, error)
+	MostSubscribedFeeds(limit int) ([]*FeedSubscriptions, error)
+	FailingFeeds(limit int) ([]*FailingFeed, error)
+	DatabaseSize() (int64, error)
+}
+
+func (s *Sqlite3AdminStore) ListUserStats() ([]*UserStats, error) {
+	query := `
+		SELECT
+			u.id,
+			u.username,
+			u.is_admin,
+			u.disabled_at IS NOT NULL,
+			(
+				SELECT COUNT(*) FROM feeds f WHERE f.user_id = u.id
+			),
+			(
+				SELECT COUNT(*) FROM feed_items fi JOIN feeds f ON f.id = fi.feed_id WHERE f.user_id = u.id
+			),
+			(
+				SELECT
+					COALESCE(SUM(
+						length(CAST(f.title AS BLOB))
+						+ length(CAST(COALESCE(f.description, '') AS BLOB))
+						+ length(CAST(f.link AS BLOB))
+					), 0)
+				FROM feeds f
+				WHERE f.user_id = u.id
+			) + (
+				SELECT
+					COALESCE(SUM(
+						length(CAST(fi.title AS BLOB))
+						+ length(CAST(COALESCE(fi.description, '') AS BLOB))
+						+ length(CAST(fi.link AS BLOB))
+					), 0)
+				FROM feed_items fi
+				JOIN feeds f ON f.id = fi.feed_id
+				WHERE f.user_id = u.id
+			)
+		FROM
+			users u
+		ORDER BY u.id
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	users := []*UserStats{}
+	for rows.Next() {
+		user := &UserStats{}
+		err = rows.Scan(&user.ID, &user.Username, &user.IsAdmin, &user.Disabled, &user.Feeds, &user.Items, &user.StorageBytes)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// MostSubscribedFeeds returns the links with the most feeds, users subscribe
+// to the same link separately
+func (s *Sqlite3AdminStore) MostSubscribedFeeds(limit int) ([]*FeedSubscriptions, error) {
+	query := `
+		SELECT
+			link,
+			MAX(title),
+			COUNT(*) AS subscribers
+		FROM
+			feeds
+		GROUP BY link
+		ORDER BY subscribers DESC, link
+		LIMIT ?
+	`
+	rows, err := s.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	feeds := []*FeedSubscriptions{}
+	for rows.Next() {
+		feed := &FeedSubscriptions{}
+		if err := rows.Scan(&feed.Link, &feed.Title, &feed.Subscribers); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return feeds, nil
+}
+
+// FailingFeeds returns the feeds with the most fetch failures in a row
+func (s *Sqlite3AdminStore) FailingFeeds(limit int) ([]*FailingFeed, error) {
+	query := `
+		SELECT
+			f.id,
+			u.username,
+			f.title,
+			f.link,
+			f.fetch_errors,
+			f.last_fetch_error,
+			f.last_fetched_at
+		FROM
+			feeds f
+		JOIN
+			users u ON u.id = f.user_id
+		WHERE
+			f.fetch_errors > 0
+		ORDER BY f.fetch_errors DESC, f.last_fetched_at DESC
+		LIMIT ?
+	`
+	rows, err := s.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	feeds := []*FailingFeed{}
+	for rows.Next() {
+		feed := &FailingFeed{}
+		var lastFetchedAt string
+		err := rows.Scan(&feed.ID, &feed.Username, &feed.Title, &feed.Link, &feed.FetchErrors, &feed.LastFetchError, &lastFetchedAt)
+		if err != nil {
+			return nil, err
+		}
+		feed.LastFetchedAt, err = time.Parse(time.RFC3339, lastFetchedAt)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return feeds, nil
+}
+
+// DatabaseSize returns the size of the database file in bytes
+func (s *Sqlite3AdminStore) DatabaseSize() (int64, error) {
+	var size int64
+	query := `
+		SELECT
+			page_count * page_size
+		FROM
+			pragma_page_count(), pragma_page_size()
+	`
+	err := s.db.QueryRow(query).Scan(&size)
+	return size, err
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminStats(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	userStore := NewSqlite3UserStore(db)
+	feedStore := NewSqlite3FeedStore(db)
+	itemStore := NewSqlite3FeedItemStore(db)
+	store := NewSqlite3AdminStore(db)
+
+	alice := &User{Username: "alice", IsAdmin: true}
+	require.NoError(t, alice.Password.Set("secret"))
+	require.NoError(t, userStore.CreateUser(alice))
+	bob := &User{Username: "bob"}
+	require.NoError(t, bob.Password.Set("secret"))
+	require.NoError(t, userStore.CreateUser(bob))
+	require.NoError(t, userStore.SetUserDisabled(bob.ID, true))
+
+	news, err := feedStore.CreateFeed(&Feed{UserID: alice.ID, Title: "News", Link: "https://example.com/news.xml"})
+	require.NoError(t, err)
+	_, err = feedStore.CreateFeed(&Feed{UserID: alice.ID, Title: "Blog", Link: "https://example.com/blog.xml"})
+	require.NoError(t, err)
+	bobsNews, err := feedStore.CreateFeed(&Feed{UserID: bob.ID, Title: "News", Link: "https://example.com/news.xml"})
+	require.NoError(t, err)
+	for _, link := range []string{"https://example.com/1", "https://example.com/2"} {
+		_, err := itemStore.CreateFeedItem(&FeedItem{FeedID: news.ID, Title: "Item", Link: link, PublishedAt: "2025-01-01T00:00:00Z"})
+		require.NoError(t, err)
+	}
+
+	users, err := store.ListUserStats()
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "alice", users[0].Username)
+	assert.True(t, users[0].IsAdmin)
+	assert.Equal(t, 2, users[0].Feeds)
+	assert.Equal(t, 2, users[0].Items)
+	assert.True(t, users[1].Disabled)
+	assert.Equal(t, 1, users[1].Feeds)
+	assert.Zero(t, users[1].Items)
+	// Both have the same news feed, alice has its items and another feed
+	assert.Greater(t, users[0].StorageBytes, users[1].StorageBytes)
+	assert.Positive(t, users[1].StorageBytes)
+
+	popular, err := store.MostSubscribedFeeds(1)
+	require.NoError(t, err)
+	require.Len(t, popular, 1)
+	assert.Equal(t, &FeedSubscriptions{Link: "https://example.com/news.xml", Title: "News", Subscribers: 2}, popular[0])
+
+	failing, err := store.FailingFeeds(10)
+	require.NoError(t, err)
+	assert.Empty(t, failing)
+
+	require.NoError(t, feedStore.RecordFetchResult(int64(bobsNews.ID), "unexpected status 404 Not Found"))
+	require.NoError(t, feedStore.RecordFetchResult(int64(bobsNews.ID), "unexpected status 404 Not Found"))
+	require.NoError(t, feedStore.RecordFetchResult(int64(news.ID), ""))
+	failing, err = store.FailingFeeds(10)
+	require.NoError(t, err)
+	require.Len(t, failing, 1)
+	assert.Equal(t, bobsNews.ID, failing[0].ID)
+	assert.Equal(t, "bob", failing[0].Username)
+	assert.Equal(t, 2, failing[0].FetchErrors)
+	assert.Equal(t, "unexpected status 404 Not Found", failing[0].LastFetchError)
+	assert.False(t, failing[0].LastFetchedAt.IsZero())
+
+	// A success resets the count
+	require.NoError(t, feedStore.RecordFetchResult(int64(bobsNews.ID), ""))
+	failing, err = store.FailingFeeds(10)
+	require.NoError(t, err)
+	assert.Empty(t, failing)
+
+	size, err := store.DatabaseSize()
+	require.NoError(t, err)
+	assert.Positive(t, size)
+}
@@ -0,0 +1,424 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Kinds of notifiers
+const (
+	NotifierSlack  = "slack"
+	NotifierMatrix = "matrix"
+	NotifierNtfy   = "ntfy"
+	NotifierGotify = "gotify"
+)
+
+// Fields an alert rule looks at
+const (
+	AlertFieldAny   = "any"
+	AlertFieldTitle = "title"
+	AlertFieldBody  = "body"
+)
+
+// Notifier is where alerts of a user are sent
+type Notifier struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"-"`
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	// URL is the incoming webhook for Slack, the homeserver for Matrix, the
+	// topic for ntfy and the server for Gotify
+	URL string `json:"url"`
+	// Token is the access token of Matrix, ntfy and Gotify, it is never
+	// shown again
+	Token string `json:"-"`
+	// Room is the Matrix room id
+	Room      string    `json:"room,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AlertRule sends new items whose field matches pattern to a notifier
+type AlertRule struct {
+	ID      int    `json:"id"`
+	UserID  int    `json:"-"`
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	// Regex is false for patterns that are keywords, they match
+	// regardless of case
+	Regex      bool   `json:"regex"`
+	Field      string `json:"field"`
+	NotifierID int    `json:"notifierId"`
+	// AllFeeds makes the rule look at every feed of the user, otherwise it
+	// is limited to FeedIDs
+	AllFeeds  bool      `json:"allFeeds"`
+	FeedIDs   []int     `json:"feedIds"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Sqlite3AlertStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3AlertStore(db *sql.DB) *Sqlite3AlertStore {
+	return &Sqlite3AlertStore{db: db}
+}
+
+type AlertStore interface {
+	CreateNotifier(*Notifier) error
+	GetNotifier(id int) (*Notifier, error)
+	ListNotifiers(userID int) ([]*Notifier, error)
+	DeleteNotifier(id int) error
+	CreateAlertRule(*AlertRule) error
+	GetAlertRule(id int) (*AlertRule, error)
+	ListAlertRules(userID int) ([]*AlertRule, error)
+	ListFeedAlertRules(feedID int) ([]*AlertRule, error)
+	DeleteAlertRule(id int) error
+}
+
+func (s *Sqlite3AlertStore) CreateNotifier(notifier *Notifier) error {
+	query := `
+		INSERT INTO notifiers (user_id, name, kind, url, token, room)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id, created_at
+	`
+	var createdAt string
+	err := s.db.QueryRow(
+		query,
+		notifier.UserID,
+		notifier.Name,
+		notifier.Kind,
+		notifier.URL,
+		notifier.Token,
+		notifier.Room,
+	).Scan(&notifier.ID, &createdAt)
+	if err != nil {
+		return err
+	}
+
+	notifier.CreatedAt, err = time.Parse(time.RFC3339, createdAt)
+	return err
+}
+
+const notifierColumns = `
+	id,
+	user_id,
+	name,
+	kind,
+	url,
+	token,
+	room,
+	created_at
+`
+
+func scanNotifier(row scanner) (*Notifier, error) {
+	notifier := &Notifier{}
+	var createdAt string
+	err := row.Scan(
+		&notifier.ID,
+		&notifier.UserID,
+		&notifier.Name,
+		&notifier.Kind,
+		&notifier.URL,
+		&notifier.Token,
+		&notifier.Room,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier.CreatedAt, err = time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	return notifier, nil
+}
+
+func (s *Sqlite3AlertStore) GetNotifier(id int) (*Notifier, error) {
+	query := `
+		SELECT` + notifierColumns + `
+		FROM
+			notifiers
+		WHERE
+			id = ?
+	`
+	notifier, err := scanNotifier(s.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return notifier, nil
+}
+
+func (s *Sqlite3AlertStore) ListNotifiers(userID int) ([]*Notifier, error) {
+	query := `
+		SELECT` + notifierColumns + `
+		FROM
+			notifiers
+		WHERE
+			user_id = ?
+		ORDER BY id
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	notifiers := []*Notifier{}
+	for rows.Next() {
+		notifier, err := scanNotifier(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notifiers, nil
+}
+
+// DeleteNotifier deletes the notifier along with the rules using it
+func (s *Sqlite3AlertStore) DeleteNotifier(id int) error {
+	query := `
+		DELETE FROM
+			notifiers
+		WHERE
+			id = ?
+	`
+	result, err := s.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreateAlertRule stores the rule and the feeds it is limited to, ignoring
+// feeds of other users. A rule left without feeds matches nothing.
+func (s *Sqlite3AlertStore) CreateAlertRule(rule *AlertRule) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `
+		INSERT INTO alert_rules (user_id, name, pattern, is_regex, field, notifier_id, all_feeds)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id, created_at
+	`
+	var createdAt string
+	err = tx.QueryRow(
+		query,
+		rule.UserID,
+		rule.Name,
+		rule.Pattern,
+		rule.Regex,
+		rule.Field,
+		rule.NotifierID,
+		rule.AllFeeds,
+	).Scan(&rule.ID, &createdAt)
+	if err != nil {
+		return err
+	}
+	if rule.CreatedAt, err = time.Parse(time.RFC3339, createdAt); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO alert_rule_feeds (rule_id, feed_id)
+		SELECT ?, id FROM feeds WHERE id = ? AND user_id = ?
+	`
+	feedIDs := []int{}
+	if !rule.AllFeeds {
+		for _, feedID := range rule.FeedIDs {
+			result, err := tx.Exec(query, rule.ID, feedID, rule.UserID)
+			if err != nil {
+				return err
+			}
+			if rowsAffected, err := result.RowsAffected(); err != nil {
+				return err
+			} else if rowsAffected > 0 {
+				feedIDs = append(feedIDs, feedID)
+			}
+		}
+	}
+	rule.FeedIDs = feedIDs
+
+	return tx.Commit()
+}
+
+const alertRuleColumns = `
+	id,
+	user_id,
+	name,
+	pattern,
+	is_regex,
+	field,
+	notifier_id,
+	all_feeds,
+	created_at
+`
+
+func (s *Sqlite3AlertStore) listAlertRules(query string, args ...any) ([]*AlertRule, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	rules := []*AlertRule{}
+	for rows.Next() {
+		rule := &AlertRule{}
+		var createdAt string
+		err := rows.Scan(
+			&rule.ID,
+			&rule.UserID,
+			&rule.Name,
+			&rule.Pattern,
+			&rule.Regex,
+			&rule.Field,
+			&rule.NotifierID,
+			&rule.AllFeeds,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if rule.CreatedAt, err = time.Parse(time.RFC3339, createdAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		if rule.FeedIDs, err = s.alertRuleFeedIDs(rule.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, nil
+}
+
+func (s *Sqlite3AlertStore) alertRuleFeedIDs(ruleID int) ([]int, error) {
+	query := `
+		SELECT
+			feed_id
+		FROM
+			alert_rule_feeds
+		WHERE
+			rule_id = ?
+		ORDER BY feed_id
+	`
+	rows, err := s.db.Query(query, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	feedIDs := []int{}
+	for rows.Next() {
+		var feedID int
+		if err := rows.Scan(&feedID); err != nil {
+			return nil, err
+		}
+		feedIDs = append(feedIDs, feedID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return feedIDs, nil
+}
+
+func (s *Sqlite3AlertStore) GetAlertRule(id int) (*AlertRule, error) {
+	query := `
+		SELECT` + alertRuleColumns + `
+		FROM
+			alert_rules
+		WHERE
+			id = ?
+	`
+	rules, err := s.listAlertRules(query, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	return rules[0], nil
+}
+
+func (s *Sqlite3AlertStore) ListAlertRules(userID int) ([]*AlertRule, error) {
+	query := `
+		SELECT` + alertRuleColumns + `
+		FROM
+			alert_rules
+		WHERE
+			user_id = ?
+		ORDER BY id
+	`
+	return s.listAlertRules(query, userID)
+}
+
+// ListFeedAlertRules returns the rules of the feed's user that look at its
+// items
+func (s *Sqlite3AlertStore) ListFeedAlertRules(feedID int) ([]*AlertRule, error) {
+	query := `
+		SELECT` + alertRuleColumns + `
+		FROM
+			alert_rules
+		WHERE
+			user_id = (SELECT user_id FROM feeds WHERE id = ?)
+		AND (
+			all_feeds = 1
+			OR EXISTS (SELECT 1 FROM alert_rule_feeds WHERE rule_id = alert_rules.id AND feed_id = ?)
+		)
+		ORDER BY id
+	`
+	return s.listAlertRules(query, feedID, feedID)
+}
+
+func (s *Sqlite3AlertStore) DeleteAlertRule(id int) error {
+	query := `
+		DELETE FROM
+			alert_rules
+		WHERE
+			id = ?
+	`
+	result, err := s.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlerts(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	feedStore := NewSqlite3FeedStore(db)
+	store := NewSqlite3AlertStore(db)
+
+	news, err := feedStore.CreateFeed(&Feed{UserID: 1, Title: "News", Link: "https://example.com/news.xml"})
+	require.NoError(t, err)
+	blog, err := feedStore.CreateFeed(&Feed{UserID: 1, Title: "Blog", Link: "https://example.com/blog.xml"})
+	require.NoError(t, err)
+	other, err := feedStore.CreateFeed(&Feed{UserID: 2, Title: "Other", Link: "https://example.com/other.xml"})
+	require.NoError(t, err)
+
+	matrix := &Notifier{UserID: 1, Name: "Security room", Kind: NotifierMatrix, URL: "https://matrix.example.com", Token: "token", Room: "!room:example.com"}
+	require.NoError(t, store.CreateNotifier(matrix))
+	assert.NotZero(t, matrix.ID)
+	assert.False(t, matrix.CreatedAt.IsZero())
+	require.NoError(t, store.CreateNotifier(&Notifier{UserID: 2, Name: "Bob", Kind: NotifierSlack, URL: "https://hooks.example.com/bob"}))
+
+	loaded, err := store.GetNotifier(matrix.ID)
+	require.NoError(t, err)
+	assert.Equal(t, matrix, loaded)
+	loaded, err = store.GetNotifier(12345)
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+
+	notifiers, err := store.ListNotifiers(1)
+	require.NoError(t, err)
+	assert.Equal(t, []*Notifier{matrix}, notifiers)
+
+	all := &AlertRule{UserID: 1, Name: "CVEs", Pattern: `CVE-\d+-\d+`, Regex: true, Field: AlertFieldAny, NotifierID: matrix.ID, AllFeeds: true}
+	require.NoError(t, store.CreateAlertRule(all))
+	assert.NotZero(t, all.ID)
+	assert.Empty(t, all.FeedIDs)
+	// The feed of another user is left out
+	newsOnly := &AlertRule{UserID: 1, Name: "Go", Pattern: "golang", Field: AlertFieldTitle, NotifierID: matrix.ID, FeedIDs: []int{news.ID, other.ID}}
+	require.NoError(t, store.CreateAlertRule(newsOnly))
+	assert.Equal(t, []int{news.ID}, newsOnly.FeedIDs)
+	// Limited to no feeds it matches nothing, rather than everything
+	none := &AlertRule{UserID: 1, Name: "None", Pattern: "golang", Field: AlertFieldAny, NotifierID: matrix.ID, FeedIDs: []int{other.ID}}
+	require.NoError(t, store.CreateAlertRule(none))
+	assert.Empty(t, none.FeedIDs)
+
+	rule, err := store.GetAlertRule(newsOnly.ID)
+	require.NoError(t, err)
+	assert.Equal(t, newsOnly, rule)
+	rule, err = store.GetAlertRule(all.ID)
+	require.NoError(t, err)
+	assert.True(t, rule.Regex)
+	assert.True(t, rule.AllFeeds)
+	rule, err = store.GetAlertRule(12345)
+	require.NoError(t, err)
+	assert.Nil(t, rule)
+
+	rules, err := store.ListAlertRules(1)
+	require.NoError(t, err)
+	assert.Len(t, rules, 3)
+
+	ids := func(rules []*AlertRule) []int {
+		ids := []int{}
+		for _, rule := range rules {
+			ids = append(ids, rule.ID)
+		}
+		return ids
+	}
+	rules, err = store.ListFeedAlertRules(news.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []int{all.ID, newsOnly.ID}, ids(rules))
+	rules, err = store.ListFeedAlertRules(blog.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []int{all.ID}, ids(rules))
+	rules, err = store.ListFeedAlertRules(other.ID)
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	require.NoError(t, store.DeleteAlertRule(newsOnly.ID))
+	assert.ErrorIs(t, store.DeleteAlertRule(newsOnly.ID), sql.ErrNoRows)
+	require.NoError(t, store.DeleteNotifier(matrix.ID))
+	assert.ErrorIs(t, store.DeleteNotifier(matrix.ID), sql.ErrNoRows)
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"database/sql"
+)
+
+// Audit events
+const (
+	AuditLoginFailed          = "login_failed"
+	AuditLoginLocked          = "login_locked"
+	AuditLoginTwoFactorFailed = "login_2fa_failed"
+	AuditUserDisabled         = "user_disabled"
+	AuditUserEnabled          = "user_enabled"
+	AuditUserDeleted          = "user_deleted"
+	AuditPasswordResetSent    = "password_reset_sent"
+	AuditPasswordReset        = "password_reset"
+	AuditEmailChanged         = "email_changed"
+)
+
+// AuditEvent records a security relevant event, like a failed login
+type AuditEvent struct {
+	ID    int64  `json:"id"`
+	Event string `json:"event"`
+	// UserID is 0 if the event isn't about an existing user
+	UserID    int    `json:"userId,omitempty"`
+	Username  string `json:"username"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Detail    string `json:"detail"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type Sqlite3AuditStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3AuditStore(db *sql.DB) *Sqlite3AuditStore {
+	return &Sqlite3AuditStore{db: db}
+}
+
+type AuditStore interface {
+	RecordAuditEvent(*AuditEvent) error
+	ListAuditEvents(limit int) ([]*AuditEvent, error)
+}
+
+func (s *Sqlite3AuditStore) RecordAuditEvent(event *AuditEvent) error {
+	var userID sql.NullInt64
+	if event.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(event.UserID), Valid: true}
+	}
+
+	query := `
+		INSERT INTO audit_log (event, user_id, username, ip, user_agent, detail)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id, created_at
+	`
+	return s.db.QueryRow(
+		query,
+		event.Event,
+		userID,
+		event.Username,
+		event.IP,
+		event.UserAgent,
+		event.Detail,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+// ListAuditEvents returns the latest events first
+func (s *Sqlite3AuditStore) ListAuditEvents(limit int) ([]*AuditEvent, error) {
+	query := `
+		SELECT
+			id,
+			event,
+			user_id,
+			username,
+			ip,
+			user_agent,
+			detail,
+			created_at
+		FROM
+			audit_log
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`
+	rows, err := s.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		event := &AuditEvent{}
+		var userID sql.NullInt64
+		err = rows.Scan(
+			&event.ID,
+			&event.Event,
+			&userID,
+			&event.Username,
+			&event.IP,
+			&event.UserAgent,
+			&event.Detail,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		event.UserID = int(userID.Int64)
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
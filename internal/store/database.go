@@ -1,17 +1,20 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pressly/goose/v3"
 )
 
-func Open(logger *log.Logger) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "database/rss.sqlite")
+func Open(logger *slog.Logger) (*sql.DB, error) {
+	// Foreign keys are off by default in SQLite, the ON DELETE CASCADE
+	// clauses in the schema depend on them
+	db, err := sql.Open("sqlite3", "database/rss.sqlite?_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("db: open %w", err)
 	}
@@ -20,7 +23,7 @@ func Open(logger *log.Logger) (*sql.DB, error) {
 		return nil, fmt.Errorf("db: ping %w", err)
 	}
 
-	logger.Printf("db: connection ok")
+	logger.Info("db: connection ok")
 
 	return db, err
 }
@@ -45,3 +48,58 @@ func Migrate(db *sql.DB, dir string) error {
 	}
 	return nil
 }
+
+// MigrationVersions returns the version the database is at and the latest
+// version available in migrationFS
+func MigrationVersions(ctx context.Context, db *sql.DB, migrationFS fs.FS) (current, latest int64, err error) {
+	provider, err := goose.NewProvider(goose.DialectSQLite3, db, migrationFS)
+	if err != nil {
+		return 0, 0, fmt.Errorf("db: goose provider %w", err)
+	}
+	return provider.GetVersions(ctx)
+}
+
+func MigrateDownFS(db *sql.DB, migrationFS fs.FS, dir string) error {
+	goose.SetBaseFS(migrationFS)
+	defer func() {
+		goose.SetBaseFS(nil)
+	}()
+
+	err := goose.SetDialect("sqlite3")
+	if err != nil {
+		return fmt.Errorf("db: migrate %w", err)
+	}
+
+	err = goose.Down(db, dir)
+	if err != nil {
+		return fmt.Errorf("db: goose down %w", err)
+	}
+	return nil
+}
+
+// MigrationStatusFS logs the applied state of every migration
+func MigrationStatusFS(db *sql.DB, migrationFS fs.FS, dir string) error {
+	goose.SetBaseFS(migrationFS)
+	defer func() {
+		goose.SetBaseFS(nil)
+	}()
+
+	err := goose.SetDialect("sqlite3")
+	if err != nil {
+		return fmt.Errorf("db: migrate %w", err)
+	}
+
+	err = goose.Status(db, dir)
+	if err != nil {
+		return fmt.Errorf("db: goose status %w", err)
+	}
+	return nil
+}
+
+func Vacuum(db *sql.DB) error {
+	_, err := db.Exec("VACUUM")
+	if err != nil {
+		return fmt.Errorf("db: vacuum %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package store
+
+import (
+	"database/sql"
+	"slices"
+	"time"
+)
+
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Digest is how a user gets emailed a summary of new unread items
+type Digest struct {
+	UserID   int    `json:"-"`
+	Schedule string `json:"schedule"`
+	// Hour and Weekday are when the digest is sent in the timezone of the
+	// user, Weekday only for weekly digests
+	Hour    int          `json:"hour"`
+	Weekday time.Weekday `json:"weekday"`
+	// MaxItems is how many of the newest items are listed at most
+	MaxItems int `json:"maxItems"`
+	// AllFeeds makes the digest list every feed of the user, otherwise it
+	// is limited to FeedIDs
+	AllFeeds bool  `json:"allFeeds"`
+	FeedIDs  []int `json:"feedIds"`
+	// NextAt is when the digest is sent next, it is zero while it is off
+	NextAt     time.Time `json:"nextAt,omitzero"`
+	LastSentAt time.Time `json:"lastSentAt,omitzero"`
+}
+
+// DefaultDigest is used until a user saves their own
+func DefaultDigest(userID int) *Digest {
+	return &Digest{
+		UserID:   userID,
+		Schedule: DigestOff,
+		Hour:     7,
+		Weekday:  time.Monday,
+		MaxItems: 20,
+		AllFeeds: true,
+	}
+}
+
+// HasFeed reports whether the digest is limited to the feed, among others
+func (d *Digest) HasFeed(feedID int) bool {
+	return slices.Contains(d.FeedIDs, feedID)
+}
+
+// DueDigest is a digest to be sent along with its recipient
+type DueDigest struct {
+	*Digest
+	Username string
+	Email    string
+	Timezone string
+	// LastItemID is the newest item the last digest looked at
+	LastItemID int64
+}
+
+// DigestItems are the unread items of a digest
+type DigestItems struct {
+	// Items are the newest of them, up to the limit
+	Items []*FeedItem
+	Total int
+	// LastItemID is the newest item looked at, whether it is listed or not
+	LastItemID int64
+}
+
+type Sqlite3DigestStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3DigestStore(db *sql.DB) *Sqlite3DigestStore {
+	return &Sqlite3DigestStore{db: db}
+}
+
+type DigestStore interface {
+	GetDigest(userID int) (*Digest, error)
+	SaveDigest(*Digest) error
+	ListDueDigests(now time.Time) ([]*DueDigest, error)
+	GetDigestItems(userID int, afterID int64, limit int) (*DigestItems, error)
+	AdvanceDigest(userID int, lastItemID int64, nextAt time.Time, sent bool) error
+}
+
+// GetDigest returns the defaults if the user never saved a digest
+func (s *Sqlite3DigestStore) GetDigest(userID int) (*Digest, error) {
+	digest := &Digest{UserID: userID}
+	var nextAt, lastSentAt sql.NullString
+	query := `
+		SELECT
+			schedule,
+			hour,
+			weekday,
+			max_items,
+			all_feeds,
+			next_at,
+			last_sent_at
+		FROM
+			digests
+		WHERE
+			user_id = ?
+	`
+	err := s.db.QueryRow(query, userID).Scan(
+		&digest.Schedule,
+		&digest.Hour,
+		&digest.Weekday,
+		&digest.MaxItems,
+		&digest.AllFeeds,
+		&nextAt,
+		&lastSentAt,
+	)
+	if err == sql.ErrNoRows {
+		return DefaultDigest(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parseDigestTimes(digest, nextAt, lastSentAt); err != nil {
+		return nil, err
+	}
+	digest.FeedIDs, err = s.digestFeedIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return digest, nil
+}
+
+func parseDigestTimes(digest *Digest, nextAt, lastSentAt sql.NullString) error {
+	var err error
+	if nextAt.Valid {
+		if digest.NextAt, err = time.Parse(time.RFC3339, nextAt.String); err != nil {
+			return err
+		}
+	}
+	if lastSentAt.Valid {
+		if digest.LastSentAt, err = time.Parse(time.RFC3339, lastSentAt.String); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sqlite3DigestStore) digestFeedIDs(userID int) ([]int, error) {
+	query := `
+		SELECT
+			feed_id
+		FROM
+			digest_feeds
+		WHERE
+			user_id = ?
+		ORDER BY feed_id
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	feedIDs := []int{}
+	for rows.Next() {
+		var feedID int
+		if err := rows.Scan(&feedID); err != nil {
+			return nil, err
+		}
+		feedIDs = append(feedIDs, feedID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return feedIDs, nil
+}
+
+// SaveDigest stores the digest and the feeds it is limited to, ignoring
+// feeds of other users. A digest left without feeds lists nothing. A digest that is turned on starts with the items
+// fetched after that, not with everything unread.
+func (s *Sqlite3DigestStore) SaveDigest(digest *Digest) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var nextAt sql.NullString
+	if digest.Schedule != DigestOff && !digest.NextAt.IsZero() {
+		nextAt = sql.NullString{String: digest.NextAt.UTC().Format(sessionTimeFormat), Valid: true}
+	}
+
+	query := `
+		INSERT INTO digests (user_id, schedule, hour, weekday, max_items, all_feeds, last_item_id, next_at)
+		VALUES (?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(id), 0) FROM feed_items), ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			schedule = excluded.schedule,
+			hour = excluded.hour,
+			weekday = excluded.weekday,
+			max_items = excluded.max_items,
+			all_feeds = excluded.all_feeds,
+			last_item_id = CASE WHEN digests.schedule = 'off' THEN excluded.last_item_id ELSE digests.last_item_id END,
+			next_at = excluded.next_at
+	`
+	_, err = tx.Exec(
+		query,
+		digest.UserID,
+		digest.Schedule,
+		digest.Hour,
+		digest.Weekday,
+		digest.MaxItems,
+		digest.AllFeeds,
+		nextAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM
+			digest_feeds
+		WHERE
+			user_id = ?
+	`
+	if _, err := tx.Exec(query, digest.UserID); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO digest_feeds (user_id, feed_id)
+		SELECT user_id, id FROM feeds WHERE id = ? AND user_id = ?
+	`
+	if !digest.AllFeeds {
+		for _, feedID := range digest.FeedIDs {
+			if _, err := tx.Exec(query, feedID, digest.UserID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListDueDigests returns the digests to send at now. Users without an
+// email address and disabled users are left out.
+func (s *Sqlite3DigestStore) ListDueDigests(now time.Time) ([]*DueDigest, error) {
+	query := `
+		SELECT
+			d.user_id,
+			d.schedule,
+			d.hour,
+			d.weekday,
+			d.max_items,
+			d.all_feeds,
+			d.next_at,
+			d.last_sent_at,
+			d.last_item_id,
+			u.username,
+			u.email,
+			COALESCE(us.timezone, 'UTC')
+		FROM
+			digests d
+		JOIN
+			users u ON u.id = d.user_id
+		LEFT JOIN
+			user_settings us ON us.user_id = d.user_id
+		WHERE
+			d.schedule != 'off'
+		AND
+			d.next_at <= ?
+		AND
+			u.email != ''
+		AND
+			u.disabled_at IS NULL
+		ORDER BY d.next_at
+	`
+	rows, err := s.db.Query(query, now.UTC().Format(sessionTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	digests := []*DueDigest{}
+	for rows.Next() {
+		due := &DueDigest{Digest: &Digest{}}
+		var nextAt, lastSentAt sql.NullString
+		err := rows.Scan(
+			&due.UserID,
+			&due.Schedule,
+			&due.Hour,
+			&due.Weekday,
+			&due.MaxItems,
+			&due.AllFeeds,
+			&nextAt,
+			&lastSentAt,
+			&due.LastItemID,
+			&due.Username,
+			&due.Email,
+			&due.Timezone,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := parseDigestTimes(due.Digest, nextAt, lastSentAt); err != nil {
+			return nil, err
+		}
+		digests = append(digests, due)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, due := range digests {
+		if due.FeedIDs, err = s.digestFeedIDs(due.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	return digests, nil
+}
+
+// GetDigestItems returns the unread items of the user's digest feeds that
+// came in after afterID, newest first
+func (s *Sqlite3DigestStore) GetDigestItems(userID int, afterID int64, limit int) (*DigestItems, error) {
+	digestItems := &DigestItems{Items: []*FeedItem{}}
+
+	// The newest item is looked up first, items fetched meanwhile are left
+	// to the next digest. Read items and those of other feeds are passed
+	// over too, so they don't come up again.
+	query := `
+		SELECT
+			COALESCE(MAX(feed_items.id), ?)
+		FROM
+			feed_items
+			JOIN feeds ON feeds.id = feed_items.feed_id
+		WHERE
+			feeds.user_id = ?
+		AND
+			feed_items.id > ?
+	`
+	if err := s.db.QueryRow(query, afterID, userID, afterID).Scan(&digestItems.LastItemID); err != nil {
+		return nil, err
+	}
+
+	where := `
+		feeds.user_id = ?
+		AND feed_items.id > ?
+		AND feed_items.id <= ?
+		AND feed_items.read_at IS NULL
+		AND (
+			EXISTS (SELECT 1 FROM digests WHERE user_id = feeds.user_id AND all_feeds = 1)
+			OR feed_items.feed_id IN (SELECT feed_id FROM digest_feeds WHERE user_id = feeds.user_id)
+		)
+	`
+	query = `
+		SELECT` + feedItemColumns + `
+		FROM
+			feed_items
+			JOIN feeds ON feeds.id = feed_items.feed_id
+		WHERE` + where + `
+		ORDER BY feed_items.published_at DESC, feed_items.id DESC
+		LIMIT ?
+	`
+	rows, err := s.db.Query(query, userID, afterID, digestItems.LastItemID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		item, err := scanFeedItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		digestItems.Items = append(digestItems.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT
+			COUNT(*)
+		FROM
+			feed_items
+			JOIN feeds ON feeds.id = feed_items.feed_id
+		WHERE` + where
+	if err := s.db.QueryRow(query, userID, afterID, digestItems.LastItemID).Scan(&digestItems.Total); err != nil {
+		return nil, err
+	}
+
+	return digestItems, nil
+}
+
+// AdvanceDigest moves the digest on to the items after lastItemID and
+// schedules the next one at nextAt. sent is false if there was nothing to
+// send.
+func (s *Sqlite3DigestStore) AdvanceDigest(userID int, lastItemID int64, nextAt time.Time, sent bool) error {
+	query := `
+		UPDATE digests
+		SET
+			last_item_id = ?,
+			next_at = ?,
+			last_sent_at = CASE WHEN ? THEN ` + sessionNow + ` ELSE last_sent_at END
+		WHERE
+			user_id = ?
+	`
+	result, err := s.db.Exec(query, lastItemID, nextAt.UTC().Format(sessionTimeFormat), sent, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigests(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	users := NewSqlite3UserStore(db)
+	feedStore := NewSqlite3FeedStore(db)
+	itemStore := NewSqlite3FeedItemStore(db)
+	store := NewSqlite3DigestStore(db)
+
+	alice := &User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, alice.Password.Set("secret"))
+	require.NoError(t, users.CreateUser(alice))
+	bob := &User{Username: "bob"}
+	require.NoError(t, bob.Password.Set("secret"))
+	require.NoError(t, users.CreateUser(bob))
+
+	news, err := feedStore.CreateFeed(&Feed{UserID: alice.ID, Title: "News", Link: "https://example.com/news.xml"})
+	require.NoError(t, err)
+	blog, err := feedStore.CreateFeed(&Feed{UserID: alice.ID, Title: "Blog", Link: "https://example.com/blog.xml"})
+	require.NoError(t, err)
+	other, err := feedStore.CreateFeed(&Feed{UserID: bob.ID, Title: "Other", Link: "https://example.com/other.xml"})
+	require.NoError(t, err)
+
+	createItem := func(feedID int, title, publishedAt string) *FeedItem {
+		item := &FeedItem{FeedID: feedID, Title: title, Link: "https://example.com/" + title, PublishedAt: publishedAt}
+		_, err := itemStore.CreateFeedItem(item)
+		require.NoError(t, err)
+		return item
+	}
+	createItem(news.ID, "before", "2025-01-01T00:00:00Z")
+
+	digest, err := store.GetDigest(alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultDigest(alice.ID), digest)
+
+	// Feeds of other users are ignored
+	nextAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	digest = &Digest{
+		UserID:   alice.ID,
+		Schedule: DigestDaily,
+		Hour:     8,
+		Weekday:  time.Monday,
+		MaxItems: 1,
+		FeedIDs:  []int{news.ID, other.ID},
+		NextAt:   nextAt,
+	}
+	require.NoError(t, store.SaveDigest(digest))
+	loaded, err := store.GetDigest(alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DigestDaily, loaded.Schedule)
+	assert.Equal(t, 8, loaded.Hour)
+	assert.Equal(t, []int{news.ID}, loaded.FeedIDs)
+	assert.True(t, nextAt.Equal(loaded.NextAt))
+
+	// Bob has no email address
+	require.NoError(t, store.SaveDigest(&Digest{UserID: bob.ID, Schedule: DigestDaily, MaxItems: 5, NextAt: nextAt}))
+
+	due, err := store.ListDueDigests(time.Now())
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, alice.ID, due[0].UserID)
+	assert.Equal(t, "alice@example.com", due[0].Email)
+	assert.Equal(t, "UTC", due[0].Timezone)
+	assert.Equal(t, []int{news.ID}, due[0].FeedIDs)
+
+	due, err = store.ListDueDigests(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	// The digest starts with the items after it was turned on
+	due, err = store.ListDueDigests(time.Now())
+	require.NoError(t, err)
+	items, err := store.GetDigestItems(alice.ID, due[0].LastItemID, due[0].MaxItems)
+	require.NoError(t, err)
+	assert.Empty(t, items.Items)
+	assert.Equal(t, due[0].LastItemID, items.LastItemID)
+
+	createItem(news.ID, "older", "2025-01-02T00:00:00Z")
+	newer := createItem(news.ID, "newer", "2025-01-03T00:00:00Z")
+	read := createItem(news.ID, "read", "2025-01-04T00:00:00Z")
+	read.ReadAt = "2025-01-05T00:00:00Z"
+	require.NoError(t, itemStore.UpdateFeedItem(read))
+	last := createItem(blog.ID, "not in digest", "2025-01-04T00:00:00Z")
+	createItem(other.ID, "not mine", "2025-01-04T00:00:00Z")
+
+	items, err = store.GetDigestItems(alice.ID, due[0].LastItemID, due[0].MaxItems)
+	require.NoError(t, err)
+	require.Len(t, items.Items, 1)
+	assert.Equal(t, newer.ID, items.Items[0].ID)
+	assert.Equal(t, "News", items.Items[0].FeedTitle)
+	assert.Equal(t, 2, items.Total)
+	assert.Equal(t, int64(last.ID), items.LastItemID)
+
+	nextAt = time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	require.NoError(t, store.AdvanceDigest(alice.ID, items.LastItemID, nextAt, true))
+	due, err = store.ListDueDigests(time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	loaded, err = store.GetDigest(alice.ID)
+	require.NoError(t, err)
+	assert.True(t, nextAt.Equal(loaded.NextAt))
+	assert.False(t, loaded.LastSentAt.IsZero())
+
+	// Nothing is sent twice
+	due, err = store.ListDueDigests(nextAt)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	items, err = store.GetDigestItems(alice.ID, due[0].LastItemID, due[0].MaxItems)
+	require.NoError(t, err)
+	assert.Empty(t, items.Items)
+	assert.Zero(t, items.Total)
+
+	// Saving again keeps the cursor
+	require.NoError(t, store.SaveDigest(digest))
+	due, err = store.ListDueDigests(time.Now())
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, int64(last.ID), due[0].LastItemID)
+
+	// Limited to no feeds of the user it lists nothing, rather than everything
+	digest.FeedIDs = []int{other.ID}
+	require.NoError(t, store.SaveDigest(digest))
+	loaded, err = store.GetDigest(alice.ID)
+	require.NoError(t, err)
+	assert.False(t, loaded.AllFeeds)
+	assert.Empty(t, loaded.FeedIDs)
+	createItem(blog.ID, "blog", "2025-01-06T00:00:00Z")
+	items, err = store.GetDigestItems(alice.ID, int64(last.ID), 10)
+	require.NoError(t, err)
+	assert.Zero(t, items.Total)
+
+	digest.AllFeeds = true
+	require.NoError(t, store.SaveDigest(digest))
+	items, err = store.GetDigestItems(alice.ID, int64(last.ID), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, items.Total)
+
+	assert.ErrorIs(t, store.AdvanceDigest(12345, 0, nextAt, false), sql.ErrNoRows)
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"database/sql"
+	"strings"
 )
 
 type FeedItem struct {
@@ -12,8 +13,29 @@ type FeedItem struct {
 	Link        string `json:"link"`
 	PublishedAt string `json:"publishedAt"`
 	ReadAt      string `json:"readAt"`
+	StarredAt   string `json:"starredAt"`
+	FeedTitle   string `json:"feedTitle,omitempty"`
+
+	// UserID is the owner of the feed, used for access checks
+	UserID int `json:"-"`
+}
+
+// FeedItemFilter narrows down ListFeedItems. The zero value lists the newest
+// items of all feeds.
+type FeedItemFilter struct {
+	FeedID      int64
+	UnreadOnly  bool
+	StarredOnly bool
+	// Query matches items whose title or description contains it
+	Query string
+	// OldestFirst reverses the default order of newest items first
+	OldestFirst bool
+	Limit       int
+	Offset      int
 }
 
+const defaultFeedItemLimit = 100
+
 type Sqlite3FeedItemStore struct {
 	db *sql.DB
 }
@@ -26,6 +48,9 @@ type FeedItemStore interface {
 	CreateFeedItem(*FeedItem) (*FeedItem, error)
 	GetFeedItemByID(id int64) (*FeedItem, error)
 	UpdateFeedItem(*FeedItem) error
+	ListFeedItems(userID int64, filter FeedItemFilter) ([]*FeedItem, error)
+	GetAdjacentFeedItemIDs(userID int64, filter FeedItemFilter, item *FeedItem) (prevID, nextID int64, err error)
+	MarkFeedItemsRead(userID int64, filter FeedItemFilter, readAt string) (int64, error)
 }
 
 func (sqlite3 *Sqlite3FeedItemStore) CreateFeedItem(feedItem *FeedItem) (*FeedItem, error) {
@@ -65,22 +90,78 @@ func (sqlite3 *Sqlite3FeedItemStore) CreateFeedItem(feedItem *FeedItem) (*FeedIt
 	return feedItem, nil
 }
 
-func (sqlite3 *Sqlite3FeedItemStore) GetFeedItemByID(id int64) (*FeedItem, error) {
-	feedItem := &FeedItem{}
+// feedItemColumns are selected by all queries returning items, in the order
+// scanFeedItem expects them
+const feedItemColumns = `
+	feed_items.id,
+	feed_items.feed_id,
+	feed_items.title,
+	COALESCE(feed_items.description, ''),
+	feed_items.link,
+	feed_items.published_at,
+	COALESCE(feed_items.read_at, ''),
+	COALESCE(feed_items.starred_at, ''),
+	feeds.title,
+	feeds.user_id
+`
 
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFeedItem(row scanner) (*FeedItem, error) {
+	item := &FeedItem{}
+	err := row.Scan(
+		&item.ID,
+		&item.FeedID,
+		&item.Title,
+		&item.Description,
+		&item.Link,
+		&item.PublishedAt,
+		&item.ReadAt,
+		&item.StarredAt,
+		&item.FeedTitle,
+		&item.UserID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// feedItemFilterSQL returns the conditions selecting the items of userID
+// matching filter. Items have to be joined with their feed.
+func feedItemFilterSQL(userID int64, filter FeedItemFilter) (string, []any) {
+	where := `
+		feeds.user_id = ?
+		AND (? = 0 OR feed_items.feed_id = ?)
+		AND (? = 0 OR feed_items.read_at IS NULL)
+		AND (? = 0 OR feed_items.starred_at IS NOT NULL)
+		AND (? = '' OR feed_items.title LIKE ? ESCAPE '\' OR feed_items.description LIKE ? ESCAPE '\')
+	`
+	pattern := "%" + likeEscaper.Replace(filter.Query) + "%"
+	args := []any{
+		userID,
+		filter.FeedID, filter.FeedID,
+		filter.UnreadOnly,
+		filter.StarredOnly,
+		filter.Query, pattern, pattern,
+	}
+	return where, args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (sqlite3 *Sqlite3FeedItemStore) GetFeedItemByID(id int64) (*FeedItem, error) {
 	query := `
-		SELECT
-			id,
-			title,
-			description,
-			link,
-			published_at
+		SELECT` + feedItemColumns + `
 		FROM
 			feed_items
+			JOIN feeds ON feeds.id = feed_items.feed_id
 		WHERE
-			id = ?
+			feed_items.id = ?
 	`
-	err := sqlite3.db.QueryRow(query, id).Scan(&feedItem.ID, &feedItem.Title, &feedItem.Description, &feedItem.Link, &feedItem.PublishedAt)
+	feedItem, err := scanFeedItem(sqlite3.db.QueryRow(query, id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -91,6 +172,7 @@ func (sqlite3 *Sqlite3FeedItemStore) GetFeedItemByID(id int64) (*FeedItem, error
 	return feedItem, nil
 }
 
+// UpdateFeedItem stores the read and starred state of the item
 func (sqlite3 *Sqlite3FeedItemStore) UpdateFeedItem(feedItem *FeedItem) error {
 	tx, err := sqlite3.db.Begin()
 	if err != nil {
@@ -102,10 +184,11 @@ func (sqlite3 *Sqlite3FeedItemStore) UpdateFeedItem(feedItem *FeedItem) error {
 		UPDATE
 			feed_items
 		SET
-			read_at = ?
+			read_at = ?,
+			starred_at = ?
 		WHERE id = ?
 	`
-	result, err := tx.Exec(query, feedItem.ReadAt)
+	result, err := tx.Exec(query, nullString(feedItem.ReadAt), nullString(feedItem.StarredAt), feedItem.ID)
 	if err != nil {
 		return err
 	}
@@ -120,3 +203,125 @@ func (sqlite3 *Sqlite3FeedItemStore) UpdateFeedItem(feedItem *FeedItem) error {
 
 	return tx.Commit()
 }
+
+func (sqlite3 *Sqlite3FeedItemStore) ListFeedItems(userID int64, filter FeedItemFilter) ([]*FeedItem, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultFeedItemLimit
+	}
+
+	order := "DESC"
+	if filter.OldestFirst {
+		order = "ASC"
+	}
+
+	where, args := feedItemFilterSQL(userID, filter)
+	query := `
+		SELECT` + feedItemColumns + `
+		FROM
+			feed_items
+			JOIN feeds ON feeds.id = feed_items.feed_id
+		WHERE` + where + `
+		ORDER BY feed_items.published_at ` + order + `, feed_items.id ` + order + `
+		LIMIT ? OFFSET ?
+	`
+	rows, err := sqlite3.db.Query(query, append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var items []*FeedItem
+	for rows.Next() {
+		item, err := scanFeedItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// GetAdjacentFeedItemIDs returns the items before and after item in the
+// order of ListFeedItems, or 0 at either end. item itself does not have to
+// match filter, e.g. when it was just read while showing unread items.
+func (sqlite3 *Sqlite3FeedItemStore) GetAdjacentFeedItemIDs(userID int64, filter FeedItemFilter, item *FeedItem) (int64, int64, error) {
+	where, args := feedItemFilterSQL(userID, filter)
+	args = append(args, item.PublishedAt, item.ID)
+
+	newerQuery := `
+		SELECT
+			feed_items.id
+		FROM
+			feed_items
+			JOIN feeds ON feeds.id = feed_items.feed_id
+		WHERE` + where + `
+			AND (feed_items.published_at, feed_items.id) > (?, ?)
+		ORDER BY feed_items.published_at, feed_items.id
+		LIMIT 1
+	`
+	olderQuery := `
+		SELECT
+			feed_items.id
+		FROM
+			feed_items
+			JOIN feeds ON feeds.id = feed_items.feed_id
+		WHERE` + where + `
+			AND (feed_items.published_at, feed_items.id) < (?, ?)
+		ORDER BY feed_items.published_at DESC, feed_items.id DESC
+		LIMIT 1
+	`
+
+	var newerID, olderID int64
+	err := sqlite3.db.QueryRow(newerQuery, args...).Scan(&newerID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, 0, err
+	}
+	err = sqlite3.db.QueryRow(olderQuery, args...).Scan(&olderID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, 0, err
+	}
+
+	if filter.OldestFirst {
+		return olderID, newerID, nil
+	}
+	return newerID, olderID, nil
+}
+
+// MarkFeedItemsRead marks all unread items matching filter as read and
+// returns how many there were. The limit of filter is ignored.
+func (sqlite3 *Sqlite3FeedItemStore) MarkFeedItemsRead(userID int64, filter FeedItemFilter, readAt string) (int64, error) {
+	where, args := feedItemFilterSQL(userID, filter)
+	query := `
+		UPDATE
+			feed_items
+		SET
+			read_at = ?
+		WHERE
+			read_at IS NULL
+			AND id IN (
+				SELECT
+					feed_items.id
+				FROM
+					feed_items
+					JOIN feeds ON feeds.id = feed_items.feed_id
+				WHERE` + where + `
+			)
+	`
+	result, err := sqlite3.db.Exec(query, append([]any{readAt}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
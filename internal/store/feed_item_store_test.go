@@ -0,0 +1,174 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListFeedItems(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	feedStore := NewSqlite3FeedStore(db)
+	itemStore := NewSqlite3FeedItemStore(db)
+
+	news, err := feedStore.CreateFeed(&Feed{UserID: 1, Title: "News", Link: "https://example.com/news.xml"})
+	require.NoError(t, err)
+	blog, err := feedStore.CreateFeed(&Feed{UserID: 1, Title: "Blog", Link: "https://example.com/blog.xml"})
+	require.NoError(t, err)
+	other, err := feedStore.CreateFeed(&Feed{UserID: 2, Title: "Other", Link: "https://example.com/other.xml"})
+	require.NoError(t, err)
+
+	items := []*FeedItem{
+		{FeedID: news.ID, Title: "Old news", Link: "https://example.com/1", PublishedAt: "2025-01-01T00:00:00Z"},
+		{FeedID: news.ID, Title: "New news", Link: "https://example.com/2", PublishedAt: "2025-01-03T00:00:00Z"},
+		{FeedID: blog.ID, Title: "Post", Link: "https://example.com/3", PublishedAt: "2025-01-02T00:00:00Z"},
+		{FeedID: other.ID, Title: "Not mine", Link: "https://example.com/4", PublishedAt: "2025-01-04T00:00:00Z"},
+	}
+	for _, item := range items {
+		_, err := itemStore.CreateFeedItem(item)
+		require.NoError(t, err)
+	}
+
+	items[0].ReadAt = "2025-01-05T00:00:00Z"
+	require.NoError(t, itemStore.UpdateFeedItem(items[0]))
+	items[2].StarredAt = "2025-01-05T00:00:00Z"
+	require.NoError(t, itemStore.UpdateFeedItem(items[2]))
+
+	titles := func(items []*FeedItem) []string {
+		var titles []string
+		for _, item := range items {
+			titles = append(titles, item.Title)
+		}
+		return titles
+	}
+
+	tests := []struct {
+		name   string
+		filter FeedItemFilter
+		want   []string
+	}{
+		{
+			name:   "all feeds",
+			filter: FeedItemFilter{},
+			want:   []string{"New news", "Post", "Old news"},
+		},
+		{
+			name:   "single feed",
+			filter: FeedItemFilter{FeedID: int64(news.ID)},
+			want:   []string{"New news", "Old news"},
+		},
+		{
+			name:   "unread only",
+			filter: FeedItemFilter{UnreadOnly: true},
+			want:   []string{"New news", "Post"},
+		},
+		{
+			name:   "starred only",
+			filter: FeedItemFilter{StarredOnly: true},
+			want:   []string{"Post"},
+		},
+		{
+			name:   "query",
+			filter: FeedItemFilter{Query: "NEWS"},
+			want:   []string{"New news", "Old news"},
+		},
+		{
+			name:   "query with wildcard",
+			filter: FeedItemFilter{Query: "%"},
+			want:   nil,
+		},
+		{
+			name:   "oldest first",
+			filter: FeedItemFilter{OldestFirst: true},
+			want:   []string{"Old news", "Post", "New news"},
+		},
+		{
+			name:   "limit",
+			filter: FeedItemFilter{Limit: 1},
+			want:   []string{"New news"},
+		},
+		{
+			name:   "offset",
+			filter: FeedItemFilter{Limit: 1, Offset: 1},
+			want:   []string{"Post"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := itemStore.ListFeedItems(1, tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, titles(got))
+		})
+	}
+
+	item, err := itemStore.GetFeedItemByID(int64(items[0].ID))
+	require.NoError(t, err)
+	assert.Equal(t, "News", item.FeedTitle)
+	assert.Equal(t, 1, item.UserID)
+	assert.Equal(t, "2025-01-05T00:00:00Z", item.ReadAt)
+
+	items[0].ReadAt = ""
+	require.NoError(t, itemStore.UpdateFeedItem(items[0]))
+	item, err = itemStore.GetFeedItemByID(int64(items[0].ID))
+	require.NoError(t, err)
+	assert.Empty(t, item.ReadAt)
+}
+
+func TestGetAdjacentFeedItemIDs(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	feedStore := NewSqlite3FeedStore(db)
+	itemStore := NewSqlite3FeedItemStore(db)
+
+	feed, err := feedStore.CreateFeed(&Feed{UserID: 1, Title: "News", Link: "https://example.com/news.xml"})
+	require.NoError(t, err)
+
+	var items []*FeedItem
+	for i, publishedAt := range []string{"2025-01-03T00:00:00Z", "2025-01-02T00:00:00Z", "2025-01-02T00:00:00Z", "2025-01-01T00:00:00Z"} {
+		item, err := itemStore.CreateFeedItem(&FeedItem{FeedID: feed.ID, Title: "Item", Link: "https://example.com/" + string(rune('a'+i)), PublishedAt: publishedAt})
+		require.NoError(t, err)
+		items = append(items, item)
+	}
+
+	// Same publication dates are ordered by id, newest first
+	list, err := itemStore.ListFeedItems(1, FeedItemFilter{})
+	require.NoError(t, err)
+	require.Len(t, list, 4)
+
+	for _, filter := range []FeedItemFilter{{}, {OldestFirst: true}} {
+		list, err := itemStore.ListFeedItems(1, filter)
+		require.NoError(t, err)
+
+		for i, item := range list {
+			prevID, nextID, err := itemStore.GetAdjacentFeedItemIDs(1, filter, item)
+			require.NoError(t, err)
+
+			var wantPrev, wantNext int64
+			if i > 0 {
+				wantPrev = int64(list[i-1].ID)
+			}
+			if i < len(list)-1 {
+				wantNext = int64(list[i+1].ID)
+			}
+			assert.Equal(t, wantPrev, prevID, "prev of %d, oldest first %v", item.ID, filter.OldestFirst)
+			assert.Equal(t, wantNext, nextID, "next of %d, oldest first %v", item.ID, filter.OldestFirst)
+		}
+	}
+
+	n, err := itemStore.MarkFeedItemsRead(1, FeedItemFilter{}, "2025-01-05T00:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+
+	// Read items are skipped, but the current one still has neighbours
+	items[1].ReadAt = ""
+	require.NoError(t, itemStore.UpdateFeedItem(items[1]))
+	prevID, nextID, err := itemStore.GetAdjacentFeedItemIDs(1, FeedItemFilter{UnreadOnly: true}, items[0])
+	require.NoError(t, err)
+	assert.Zero(t, prevID)
+	assert.Equal(t, int64(items[1].ID), nextID)
+}
@@ -11,8 +11,17 @@ type Feed struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	Link        string `json:"link"`
+	// Category is the folder of the feed in OPML files, empty if none
+	Category    string `json:"category"`
 	Items       []Item `json:"items"`
 	UnreadCount int    `json:"unreadCount"`
+	// LastFetchError is why the last fetch failed, empty if it succeeded
+	LastFetchError string `json:"lastFetchError"`
+
+	// Cache validators from the last fetch, sent back as conditional
+	// request headers
+	ETag         string `json:"-"`
+	LastModified string `json:"-"`
 }
 
 type Item struct {
@@ -37,6 +46,9 @@ type FeedStore interface {
 	UpdateFeed(*Feed) error
 	DeleteFeedByID(id int64) error
 	GetFeedsByUserID(userID int64) ([]*Feed, error)
+	GetAllFeeds() ([]*Feed, error)
+	UpdateFeedCacheHeaders(id int64, etag, lastModified string) error
+	RecordFetchResult(id int64, fetchErr string) error
 }
 
 func (sqlite3 *Sqlite3FeedStore) CreateFeed(feed *Feed) (*Feed, error) {
@@ -51,17 +63,19 @@ func (sqlite3 *Sqlite3FeedStore) CreateFeed(feed *Feed) (*Feed, error) {
 			user_id,
 			title,
 			description,
-			link
+			link,
+			category
 		)
 		VALUES (
 			?,
 			?,
 			?,
+			?,
 			?
 		)
 		RETURNING id;
 	`
-	err = tx.QueryRow(query, feed.UserID, feed.Title, feed.Description, feed.Link).Scan(&feed.ID)
+	err = tx.QueryRow(query, feed.UserID, feed.Title, feed.Description, feed.Link, feed.Category).Scan(&feed.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -81,15 +95,19 @@ func (sqlite3 *Sqlite3FeedStore) GetFeedByID(id int64) (*Feed, error) {
 	query := `
 		SELECT
 			id,
+			user_id,
 			title,
 			description,
-			link
+			link,
+			category,
+			etag,
+			last_modified
 		FROM
 			feeds
 		WHERE
 			id = ?
 	`
-	err := sqlite3.db.QueryRow(query, id).Scan(&feed.ID, &feed.Title, &feed.Description, &feed.Link)
+	err := sqlite3.db.QueryRow(query, id).Scan(&feed.ID, &feed.UserID, &feed.Title, &feed.Description, &feed.Link, &feed.Category, &feed.ETag, &feed.LastModified)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -147,10 +165,11 @@ func (sqlite3 *Sqlite3FeedStore) UpdateFeed(feed *Feed) error {
 		SET
 			title = ?,
 			description = ?,
-			link = ?
+			link = ?,
+			category = ?
 		WHERE id = ?
 	`
-	result, err := tx.Exec(query, feed.Title, feed.Description, feed.Link, feed.ID)
+	result, err := tx.Exec(query, feed.Title, feed.Description, feed.Link, feed.Category, feed.ID)
 	if err != nil {
 		return err
 	}
@@ -205,7 +224,9 @@ func (sqlite3 *Sqlite3FeedStore) GetFeedsByUserID(userID int64) ([]*Feed, error)
 			title,
 			description,
 			link,
-			(SELECT COUNT(*) FROM feed_items WHERE feed_id = feeds.id AND read_at IS NULL) AS unread_count
+			category,
+			(SELECT COUNT(*) FROM feed_items WHERE feed_id = feeds.id AND read_at IS NULL) AS unread_count,
+			last_fetch_error
 		FROM
 			feeds
 		WHERE
@@ -227,7 +248,9 @@ func (sqlite3 *Sqlite3FeedStore) GetFeedsByUserID(userID int64) ([]*Feed, error)
 			&feed.Title,
 			&feed.Description,
 			&feed.Link,
+			&feed.Category,
 			&feed.UnreadCount,
+			&feed.LastFetchError,
 		)
 		if err != nil {
 			return nil, err
@@ -241,3 +264,75 @@ func (sqlite3 *Sqlite3FeedStore) GetFeedsByUserID(userID int64) ([]*Feed, error)
 
 	return feeds, nil
 }
+
+func (sqlite3 *Sqlite3FeedStore) GetAllFeeds() ([]*Feed, error) {
+	query := `
+		SELECT
+			id,
+			user_id,
+			title,
+			description,
+			link,
+			category
+		FROM
+			feeds
+		ORDER BY id
+	`
+	rows, err := sqlite3.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var feeds []*Feed
+	for rows.Next() {
+		feed := &Feed{}
+		err = rows.Scan(
+			&feed.ID,
+			&feed.UserID,
+			&feed.Title,
+			&feed.Description,
+			&feed.Link,
+			&feed.Category,
+		)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return feeds, nil
+}
+
+func (sqlite3 *Sqlite3FeedStore) UpdateFeedCacheHeaders(id int64, etag, lastModified string) error {
+	query := `
+		UPDATE
+			feeds
+		SET
+			etag = ?,
+			last_modified = ?
+		WHERE id = ?
+	`
+	_, err := sqlite3.db.Exec(query, etag, lastModified, id)
+	return err
+}
+
+// RecordFetchResult stores the outcome of a fetch, fetchErr is empty if it
+// succeeded
+func (sqlite3 *Sqlite3FeedStore) RecordFetchResult(id int64, fetchErr string) error {
+	query := `
+		UPDATE
+			feeds
+		SET
+			last_fetched_at = ` + sessionNow + `,
+			fetch_errors = CASE WHEN ? = '' THEN 0 ELSE fetch_errors + 1 END,
+			last_fetch_error = ?
+		WHERE id = ?
+	`
+	_, err := sqlite3.db.Exec(query, fetchErr, fetchErr, id)
+	return err
+}
@@ -25,8 +25,26 @@ func setupTestDB(t *testing.T) *sql.DB {
 	}
 
 	_, err = db.Exec(`
+		DELETE FROM alert_rule_feeds;
+		DELETE FROM alert_rules;
+		DELETE FROM notifiers;
+		DELETE FROM webhook_deliveries;
+		DELETE FROM webhooks;
+		DELETE FROM digest_feeds;
+		DELETE FROM digests;
 		DELETE FROM feed_items;
 		DELETE FROM feeds;
+		DELETE FROM user_settings;
+		DELETE FROM sessions;
+		DELETE FROM login_challenges;
+		DELETE FROM recovery_codes;
+		DELETE FROM user_totp;
+		DELETE FROM user_identities;
+		DELETE FROM login_failures;
+		DELETE FROM audit_log;
+		DELETE FROM invites;
+		DELETE FROM password_resets;
+		DELETE FROM users;
 	`)
 	if err != nil {
 		t.Fatalf("db: truncate %v", err)
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"database/sql"
+)
+
+// Identity links an account of an OpenID Connect provider to a user
+type Identity struct {
+	ID        int64  `json:"id"`
+	UserID    int    `json:"-"`
+	Issuer    string `json:"issuer"`
+	Subject   string `json:"subject"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type Sqlite3IdentityStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3IdentityStore(db *sql.DB) *Sqlite3IdentityStore {
+	return &Sqlite3IdentityStore{db: db}
+}
+
+type IdentityStore interface {
+	CreateIdentity(*Identity) error
+	CreateUserWithIdentity(*User, *Identity) error
+	GetIdentity(issuer, subject string) (*Identity, error)
+	ListUserIdentities(userID int) ([]*Identity, error)
+	DeleteUserIdentity(userID int, id int64) error
+}
+
+func (s *Sqlite3IdentityStore) CreateIdentity(identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (user_id, issuer, subject, username)
+		VALUES (?, ?, ?, ?)
+		RETURNING id, created_at
+	`
+	return s.db.QueryRow(
+		query,
+		identity.UserID,
+		identity.Issuer,
+		identity.Subject,
+		identity.Username,
+	).Scan(&identity.ID, &identity.CreatedAt)
+}
+
+// CreateUserWithIdentity creates the user and links the identity to them,
+// or neither. It returns ErrUsernameTaken or ErrEmailTaken if it can't.
+func (s *Sqlite3IdentityStore) CreateUserWithIdentity(user *User, identity *Identity) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `
+		INSERT INTO
+			users (username, email, password, is_admin)
+		VALUES
+			(?, ?, ?, ?) RETURNING id
+	`
+	err = tx.QueryRow(query, user.Username, user.Email, user.Password.hash, user.IsAdmin).Scan(&user.ID)
+	if err != nil {
+		return userError(err)
+	}
+
+	query = `
+		INSERT INTO user_identities (user_id, issuer, subject, username)
+		VALUES (?, ?, ?, ?)
+		RETURNING id, created_at
+	`
+	identity.UserID = user.ID
+	err = tx.QueryRow(
+		query,
+		identity.UserID,
+		identity.Issuer,
+		identity.Subject,
+		identity.Username,
+	).Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetIdentity returns nil if the account isn't linked to any user
+func (s *Sqlite3IdentityStore) GetIdentity(issuer, subject string) (*Identity, error) {
+	identity := &Identity{}
+	query := `
+		SELECT
+			id,
+			user_id,
+			issuer,
+			subject,
+			username,
+			created_at
+		FROM
+			user_identities
+		WHERE
+			issuer = ?
+		AND
+			subject = ?
+	`
+	err := s.db.QueryRow(query, issuer, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Issuer,
+		&identity.Subject,
+		&identity.Username,
+		&identity.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+func (s *Sqlite3IdentityStore) ListUserIdentities(userID int) ([]*Identity, error) {
+	query := `
+		SELECT
+			id,
+			user_id,
+			issuer,
+			subject,
+			username,
+			created_at
+		FROM
+			user_identities
+		WHERE
+			user_id = ?
+		ORDER BY id
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var identities []*Identity
+	for rows.Next() {
+		identity := &Identity{}
+		err = rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Issuer,
+			&identity.Subject,
+			&identity.Username,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// DeleteUserIdentity unlinks an identity, as long as it belongs to the user.
+// It returns sql.ErrNoRows otherwise.
+func (s *Sqlite3IdentityStore) DeleteUserIdentity(userID int, id int64) error {
+	query := `
+		DELETE FROM
+			user_identities
+		WHERE
+			id = ?
+		AND
+			user_id = ?
+	`
+	result, err := s.db.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentities(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3IdentityStore(db)
+
+	identity := &Identity{UserID: 1, Issuer: "https://idp.test", Subject: "abc", Username: "alice"}
+	require.NoError(t, store.CreateIdentity(identity))
+	assert.NotZero(t, identity.ID)
+
+	// An identity signs in as one user only
+	assert.Error(t, store.CreateIdentity(&Identity{UserID: 2, Issuer: "https://idp.test", Subject: "abc"}))
+
+	found, err := store.GetIdentity("https://idp.test", "abc")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, 1, found.UserID)
+	assert.Equal(t, "alice", found.Username)
+
+	found, err = store.GetIdentity("https://other.test", "abc")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	identities, err := store.ListUserIdentities(1)
+	require.NoError(t, err)
+	assert.Len(t, identities, 1)
+
+	assert.ErrorIs(t, store.DeleteUserIdentity(2, identity.ID), sql.ErrNoRows)
+	require.NoError(t, store.DeleteUserIdentity(1, identity.ID))
+	identities, err = store.ListUserIdentities(1)
+	require.NoError(t, err)
+	assert.Empty(t, identities)
+
+	// Neither the user nor the identity is created if one of them can't be
+	userStore := NewSqlite3UserStore(db)
+	bob := &User{Username: "bob"}
+	require.NoError(t, bob.Password.SetRandom())
+	bobIdentity := &Identity{Issuer: "https://idp.test", Subject: "bob", Username: "bob"}
+	require.NoError(t, store.CreateUserWithIdentity(bob, bobIdentity))
+	assert.NotZero(t, bob.ID)
+	assert.Equal(t, bob.ID, bobIdentity.UserID)
+	carol := &User{Username: "carol"}
+	require.NoError(t, carol.Password.SetRandom())
+	assert.Error(t, store.CreateUserWithIdentity(carol, &Identity{Issuer: "https://idp.test", Subject: "bob"}))
+	found, err = store.GetIdentity("https://idp.test", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, bob.ID, found.UserID)
+	carol, err = userStore.GetUserByUsername("carol")
+	require.NoError(t, err)
+	assert.Nil(t, carol)
+	taken := &User{Username: "bob"}
+	require.NoError(t, taken.Password.SetRandom())
+	assert.ErrorIs(t, store.CreateUserWithIdentity(taken, &Identity{Issuer: "https://idp.test", Subject: "bob2"}), ErrUsernameTaken)
+}
@@ -0,0 +1,215 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidInvite is returned for invite codes that don't exist, expired or
+// were used already
+var ErrInvalidInvite = errors.New("invalid or expired invite code")
+
+// Invite lets one person register. The code itself is only known when it is
+// created.
+type Invite struct {
+	ID int64 `json:"id"`
+	// CreatedBy and UsedBy are 0 if there is no such user (anymore)
+	CreatedBy int       `json:"createdBy,omitempty"`
+	UsedBy    int       `json:"usedBy,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// UsedAt is zero while the invite is unused
+	UsedAt    time.Time `json:"usedAt,omitzero"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Sqlite3InviteStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3InviteStore(db *sql.DB) *Sqlite3InviteStore {
+	return &Sqlite3InviteStore{db: db}
+}
+
+type InviteStore interface {
+	CreateInvite(invite *Invite, code string) error
+	ListInvites() ([]*Invite, error)
+	DeleteInvite(id int64) error
+	CreateUserWithInvite(user *User, code string) error
+}
+
+// NewInviteCode returns a random code like "k3vq-7pxw-2abx-9mtd"
+func NewInviteCode() (string, error) {
+	bytes := make([]byte, 10)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))
+	return code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// Invite codes are random like recovery codes and normalized the same way
+func hashInviteCode(code string) string {
+	return hashRecoveryCode(code)
+}
+
+func (s *Sqlite3InviteStore) CreateInvite(invite *Invite, code string) error {
+	var createdBy sql.NullInt64
+	if invite.CreatedBy != 0 {
+		createdBy = sql.NullInt64{Int64: int64(invite.CreatedBy), Valid: true}
+	}
+
+	query := `
+		INSERT INTO invites (code_hash, created_by, expires_at)
+		VALUES (?, ?, ?)
+		RETURNING id, created_at
+	`
+	var createdAt string
+	err := s.db.QueryRow(
+		query,
+		hashInviteCode(code),
+		createdBy,
+		invite.ExpiresAt.UTC().Format(sessionTimeFormat),
+	).Scan(&invite.ID, &createdAt)
+	if err != nil {
+		return err
+	}
+
+	invite.CreatedAt, err = time.Parse(time.RFC3339, createdAt)
+	return err
+}
+
+func (s *Sqlite3InviteStore) ListInvites() ([]*Invite, error) {
+	query := `
+		SELECT
+			id,
+			created_by,
+			used_by,
+			expires_at,
+			used_at,
+			created_at
+		FROM
+			invites
+		ORDER BY id DESC
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	invites := []*Invite{}
+	for rows.Next() {
+		invite := &Invite{}
+		var createdBy, usedBy sql.NullInt64
+		var expiresAt, createdAt string
+		var usedAt sql.NullString
+		err = rows.Scan(&invite.ID, &createdBy, &usedBy, &expiresAt, &usedAt, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		invite.CreatedBy = int(createdBy.Int64)
+		invite.UsedBy = int(usedBy.Int64)
+		if invite.ExpiresAt, err = time.Parse(time.RFC3339, expiresAt); err != nil {
+			return nil, err
+		}
+		if invite.CreatedAt, err = time.Parse(time.RFC3339, createdAt); err != nil {
+			return nil, err
+		}
+		if usedAt.Valid {
+			if invite.UsedAt, err = time.Parse(time.RFC3339, usedAt.String); err != nil {
+				return nil, err
+			}
+		}
+		invites = append(invites, invite)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+func (s *Sqlite3InviteStore) DeleteInvite(id int64) error {
+	query := `
+		DELETE FROM
+			invites
+		WHERE
+			id = ?
+	`
+	result, err := s.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreateUserWithInvite uses up the invite and creates the user, or neither.
+// It returns ErrInvalidInvite, ErrUsernameTaken or ErrEmailTaken if it
+// can't.
+func (s *Sqlite3InviteStore) CreateUserWithInvite(user *User, code string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `
+		UPDATE invites
+		SET
+			used_at = ` + sessionNow + `
+		WHERE
+			code_hash = ?
+		AND
+			used_at IS NULL
+		AND
+			expires_at > ` + sessionNow + `
+		RETURNING id
+	`
+	var inviteID int64
+	err = tx.QueryRow(query, hashInviteCode(code)).Scan(&inviteID)
+	if err == sql.ErrNoRows {
+		return ErrInvalidInvite
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO
+			users (username, email, password, is_admin)
+		VALUES
+			(?, ?, ?, ?) RETURNING id
+	`
+	err = tx.QueryRow(query, user.Username, user.Email, user.Password.hash, user.IsAdmin).Scan(&user.ID)
+	if err != nil {
+		return userError(err)
+	}
+
+	query = `
+		UPDATE invites
+		SET
+			used_by = ?
+		WHERE
+			id = ?
+	`
+	if _, err := tx.Exec(query, user.ID, inviteID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvites(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	users := NewSqlite3UserStore(db)
+	store := NewSqlite3InviteStore(db)
+
+	admin := &User{Username: "admin", IsAdmin: true}
+	require.NoError(t, admin.Password.Set("secret"))
+	require.NoError(t, users.CreateUser(admin))
+
+	code, err := NewInviteCode()
+	require.NoError(t, err)
+	invite := &Invite{CreatedBy: admin.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, store.CreateInvite(invite, code))
+	assert.NotZero(t, invite.ID)
+
+	alice := &User{Username: "alice"}
+	require.NoError(t, alice.Password.Set("secret"))
+	assert.ErrorIs(t, store.CreateUserWithInvite(alice, "wrong-code"), ErrInvalidInvite)
+
+	// Codes are accepted without dashes and in any case
+	require.NoError(t, store.CreateUserWithInvite(alice, strings.ToUpper(strings.ReplaceAll(code, "-", ""))))
+	assert.NotZero(t, alice.ID)
+
+	// and only once
+	bob := &User{Username: "bob"}
+	require.NoError(t, bob.Password.Set("secret"))
+	assert.ErrorIs(t, store.CreateUserWithInvite(bob, code), ErrInvalidInvite)
+
+	invites, err := store.ListInvites()
+	require.NoError(t, err)
+	require.Len(t, invites, 1)
+	assert.Equal(t, admin.ID, invites[0].CreatedBy)
+	assert.Equal(t, alice.ID, invites[0].UsedBy)
+	assert.False(t, invites[0].UsedAt.IsZero())
+
+	require.NoError(t, store.DeleteInvite(invite.ID))
+	assert.ErrorIs(t, store.DeleteInvite(invite.ID), sql.ErrNoRows)
+}
+
+func TestInviteNotUsedUp(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	users := NewSqlite3UserStore(db)
+	store := NewSqlite3InviteStore(db)
+
+	alice := &User{Username: "alice"}
+	require.NoError(t, alice.Password.Set("secret"))
+	require.NoError(t, users.CreateUser(alice))
+
+	expired, err := NewInviteCode()
+	require.NoError(t, err)
+	require.NoError(t, store.CreateInvite(&Invite{ExpiresAt: time.Now().Add(-time.Minute)}, expired))
+	bob := &User{Username: "bob"}
+	require.NoError(t, bob.Password.Set("secret"))
+	assert.ErrorIs(t, store.CreateUserWithInvite(bob, expired), ErrInvalidInvite)
+
+	// A taken username doesn't use up the invite
+	code, err := NewInviteCode()
+	require.NoError(t, err)
+	require.NoError(t, store.CreateInvite(&Invite{ExpiresAt: time.Now().Add(time.Hour)}, code))
+	taken := &User{Username: "alice"}
+	require.NoError(t, taken.Password.Set("secret"))
+	assert.ErrorIs(t, store.CreateUserWithInvite(taken, code), ErrUsernameTaken)
+	require.NoError(t, store.CreateUserWithInvite(bob, code))
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Failed logins are counted per client address and per username
+const (
+	LoginScopeIP       = "ip"
+	LoginScopeUsername = "username"
+)
+
+type LoginFailures struct {
+	Scope        string
+	Key          string
+	Failures     int
+	LastFailedAt time.Time
+	// LockedUntil is zero unless logins were locked
+	LockedUntil time.Time
+}
+
+type Sqlite3LoginThrottleStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3LoginThrottleStore(db *sql.DB) *Sqlite3LoginThrottleStore {
+	return &Sqlite3LoginThrottleStore{db: db}
+}
+
+type LoginThrottleStore interface {
+	GetLoginFailures(scope, key string) (*LoginFailures, error)
+	AddLoginFailure(scope, key string, window time.Duration) (*LoginFailures, error)
+	LockLogin(scope, key string, until time.Time) error
+	ClearLoginFailures(scope, key string) error
+	DeleteStaleLoginFailures(before time.Time) (int64, error)
+}
+
+func scanLoginFailures(row scanner, failures *LoginFailures) error {
+	var lastFailedAt string
+	var lockedUntil sql.NullString
+	err := row.Scan(&failures.Failures, &lastFailedAt, &lockedUntil)
+	if err != nil {
+		return err
+	}
+
+	failures.LastFailedAt, err = time.Parse(time.RFC3339, lastFailedAt)
+	if err != nil {
+		return err
+	}
+	if lockedUntil.Valid {
+		failures.LockedUntil, err = time.Parse(time.RFC3339, lockedUntil.String)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLoginFailures returns nil if there were no failed logins
+func (s *Sqlite3LoginThrottleStore) GetLoginFailures(scope, key string) (*LoginFailures, error) {
+	failures := &LoginFailures{Scope: scope, Key: key}
+	query := `
+		SELECT
+			failures,
+			last_failed_at,
+			locked_until
+		FROM
+			login_failures
+		WHERE
+			scope = ?
+		AND
+			key = ?
+	`
+	err := scanLoginFailures(s.db.QueryRow(query, scope, key), failures)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return failures, nil
+}
+
+// AddLoginFailure counts a failed login and returns the new count. Counting
+// starts over if the last failure is older than window.
+func (s *Sqlite3LoginThrottleStore) AddLoginFailure(scope, key string, window time.Duration) (*LoginFailures, error) {
+	now := time.Now().UTC()
+	failures := &LoginFailures{Scope: scope, Key: key}
+	query := `
+		INSERT INTO login_failures (scope, key, failures, last_failed_at)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT (scope, key) DO UPDATE SET
+			failures = CASE
+				WHEN last_failed_at < ? THEN 1
+				ELSE failures + 1
+			END,
+			last_failed_at = excluded.last_failed_at
+		RETURNING failures, last_failed_at, locked_until
+	`
+	err := scanLoginFailures(s.db.QueryRow(
+		query,
+		scope,
+		key,
+		now.Format(sessionTimeFormat),
+		now.Add(-window).Format(sessionTimeFormat),
+	), failures)
+	if err != nil {
+		return nil, err
+	}
+
+	return failures, nil
+}
+
+func (s *Sqlite3LoginThrottleStore) LockLogin(scope, key string, until time.Time) error {
+	query := `
+		UPDATE login_failures
+		SET
+			locked_until = ?
+		WHERE
+			scope = ?
+		AND
+			key = ?
+	`
+	_, err := s.db.Exec(query, until.UTC().Format(sessionTimeFormat), scope, key)
+	return err
+}
+
+// ClearLoginFailures forgets the failures and lifts a lock
+func (s *Sqlite3LoginThrottleStore) ClearLoginFailures(scope, key string) error {
+	query := `
+		DELETE FROM
+			login_failures
+		WHERE
+			scope = ?
+		AND
+			key = ?
+	`
+	_, err := s.db.Exec(query, scope, key)
+	return err
+}
+
+// DeleteStaleLoginFailures removes the failures that ended before the given
+// time and aren't locked anymore
+func (s *Sqlite3LoginThrottleStore) DeleteStaleLoginFailures(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM
+			login_failures
+		WHERE
+			last_failed_at < ?
+		AND
+			(locked_until IS NULL OR locked_until <= ` + sessionNow + `)
+	`
+	result, err := s.db.Exec(query, before.UTC().Format(sessionTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginFailures(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3LoginThrottleStore(db)
+
+	failures, err := store.GetLoginFailures(LoginScopeUsername, "alice")
+	require.NoError(t, err)
+	assert.Nil(t, failures)
+
+	for i := 1; i <= 3; i++ {
+		failures, err = store.AddLoginFailure(LoginScopeUsername, "alice", time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, i, failures.Failures)
+		assert.True(t, failures.LockedUntil.IsZero())
+	}
+
+	// The address is counted apart from the username
+	failures, err = store.AddLoginFailure(LoginScopeIP, "192.0.2.1", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures.Failures)
+
+	until := time.Now().Add(15 * time.Minute)
+	require.NoError(t, store.LockLogin(LoginScopeUsername, "alice", until))
+	failures, err = store.GetLoginFailures(LoginScopeUsername, "alice")
+	require.NoError(t, err)
+	require.NotNil(t, failures)
+	assert.Equal(t, 3, failures.Failures)
+	assert.WithinDuration(t, until, failures.LockedUntil, time.Second)
+
+	// Locked failures are kept until the lock ends
+	deleted, err := store.DeleteStaleLoginFailures(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	failures, err = store.GetLoginFailures(LoginScopeUsername, "alice")
+	require.NoError(t, err)
+	assert.NotNil(t, failures)
+
+	require.NoError(t, store.ClearLoginFailures(LoginScopeUsername, "alice"))
+	failures, err = store.GetLoginFailures(LoginScopeUsername, "alice")
+	require.NoError(t, err)
+	assert.Nil(t, failures)
+}
+
+func TestLoginFailuresWindow(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3LoginThrottleStore(db)
+
+	_, err := db.Exec(`INSERT INTO login_failures (scope, key, failures, last_failed_at) VALUES (?, ?, 5, ?)`,
+		LoginScopeUsername, "alice", time.Now().Add(-2*time.Hour).UTC().Format(sessionTimeFormat))
+	require.NoError(t, err)
+
+	// Failures older than the window don't count anymore
+	failures, err := store.AddLoginFailure(LoginScopeUsername, "alice", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures.Failures)
+}
+
+func TestRecordAuditEvent(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3AuditStore(db)
+
+	event := &AuditEvent{Event: AuditLoginFailed, Username: "nobody", IP: "192.0.2.1", Detail: "unknown user"}
+	require.NoError(t, store.RecordAuditEvent(event))
+	assert.NotZero(t, event.ID)
+	assert.NotEmpty(t, event.CreatedAt)
+
+	var userID *int
+	require.NoError(t, db.QueryRow(`SELECT user_id FROM audit_log WHERE id = ?`, event.ID).Scan(&userID))
+	assert.Nil(t, userID)
+
+	require.NoError(t, store.RecordAuditEvent(&AuditEvent{Event: AuditLoginLocked, Username: "nobody"}))
+	events, err := store.ListAuditEvents(10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, AuditLoginLocked, events[0].Event)
+	assert.Equal(t, event, events[1])
+
+	events, err = store.ListAuditEvents(1)
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrInvalidPasswordReset is returned for reset tokens that don't exist,
+// expired or were used already
+var ErrInvalidPasswordReset = errors.New("invalid or expired password reset link")
+
+type Sqlite3PasswordResetStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3PasswordResetStore(db *sql.DB) *Sqlite3PasswordResetStore {
+	return &Sqlite3PasswordResetStore{db: db}
+}
+
+type PasswordResetStore interface {
+	CreatePasswordReset(userID int, expiresAt time.Time) (string, error)
+	GetPasswordResetUserID(token string) (int, error)
+	ResetPassword(token string, user *User) error
+	CountPasswordResets(userID int, since time.Time) (int, error)
+	DeleteExpiredPasswordResets() (int64, error)
+}
+
+// Reset tokens are random like recovery codes, a fast hash is enough
+func hashResetToken(token string) string {
+	return hashRecoveryCode(token)
+}
+
+// CreatePasswordReset returns the token of a new reset for the user. Only
+// its hash is stored, the token goes into the link sent to the user.
+func (s *Sqlite3PasswordResetStore) CreatePasswordReset(userID int, expiresAt time.Time) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := `
+		INSERT INTO password_resets (token_hash, user_id, expires_at)
+		VALUES (?, ?, ?)
+	`
+	_, err = s.db.Exec(query, hashResetToken(token), userID, expiresAt.UTC().Format(sessionTimeFormat))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetPasswordResetUserID returns the user a token resets the password of,
+// or 0 if the token can't be used
+func (s *Sqlite3PasswordResetStore) GetPasswordResetUserID(token string) (int, error) {
+	query := `
+		SELECT
+			user_id
+		FROM
+			password_resets
+		WHERE
+			token_hash = ?
+		AND
+			used_at IS NULL
+		AND
+			expires_at > ` + sessionNow + `
+	`
+	var userID int
+	err := s.db.QueryRow(query, hashResetToken(token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// ResetPassword uses up the token and saves the password of user, or
+// neither. Other resets of the user can't be used afterwards. It returns
+// ErrInvalidPasswordReset if the token isn't one of the user's or can't be
+// used.
+func (s *Sqlite3PasswordResetStore) ResetPassword(token string, user *User) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `
+		UPDATE password_resets
+		SET
+			used_at = ` + sessionNow + `
+		WHERE
+			token_hash = ?
+		AND
+			user_id = ?
+		AND
+			used_at IS NULL
+		AND
+			expires_at > ` + sessionNow + `
+	`
+	result, err := tx.Exec(query, hashResetToken(token), user.ID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvalidPasswordReset
+	}
+
+	// Links sent before may have leaked along with the password
+	query = `
+		UPDATE password_resets
+		SET
+			used_at = ` + sessionNow + `
+		WHERE
+			user_id = ?
+		AND
+			used_at IS NULL
+	`
+	if _, err := tx.Exec(query, user.ID); err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE users
+		SET
+			password = ?
+		WHERE
+			id = ?
+	`
+	if _, err := tx.Exec(query, user.Password.hash, user.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteExpiredPasswordResets deletes resets that can't be used anymore
+func (s *Sqlite3PasswordResetStore) DeleteExpiredPasswordResets() (int64, error) {
+	query := `
+		DELETE FROM
+			password_resets
+		WHERE
+			expires_at <= ` + sessionNow + `
+		OR
+			used_at IS NOT NULL
+	`
+	result, err := s.db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CountPasswordResets returns how many resets were created for the user
+// since then, used or not
+func (s *Sqlite3PasswordResetStore) CountPasswordResets(userID int, since time.Time) (int, error) {
+	query := `
+		SELECT
+			COUNT(*)
+		FROM
+			password_resets
+		WHERE
+			user_id = ?
+		AND
+			created_at > ?
+	`
+	var count int
+	err := s.db.QueryRow(query, userID, since.UTC().Format(sessionTimeFormat)).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordResets(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	users := NewSqlite3UserStore(db)
+	store := NewSqlite3PasswordResetStore(db)
+
+	alice := &User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, alice.Password.Set("secret"))
+	require.NoError(t, users.CreateUser(alice))
+	bob := &User{Username: "bob"}
+	require.NoError(t, bob.Password.Set("secret"))
+	require.NoError(t, users.CreateUser(bob))
+
+	first, err := store.CreatePasswordReset(alice.ID, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	token, err := store.CreatePasswordReset(alice.ID, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	expired, err := store.CreatePasswordReset(alice.ID, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+
+	count, err := store.CountPasswordResets(alice.ID, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	userID, err := store.GetPasswordResetUserID(token)
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, userID)
+	userID, err = store.GetPasswordResetUserID(expired)
+	require.NoError(t, err)
+	assert.Zero(t, userID)
+	userID, err = store.GetPasswordResetUserID("unknown")
+	require.NoError(t, err)
+	assert.Zero(t, userID)
+
+	// A token only resets the password of its user
+	require.NoError(t, bob.Password.Set("changed"))
+	assert.ErrorIs(t, store.ResetPassword(token, bob), ErrInvalidPasswordReset)
+	assert.ErrorIs(t, store.ResetPassword(expired, alice), ErrInvalidPasswordReset)
+
+	require.NoError(t, alice.Password.Set("changed"))
+	require.NoError(t, store.ResetPassword(token, alice))
+	loaded, err := users.GetUserByID(alice.ID)
+	require.NoError(t, err)
+	matches, err := loaded.Password.Matches("changed")
+	require.NoError(t, err)
+	assert.True(t, matches)
+	loaded, err = users.GetUserByID(bob.ID)
+	require.NoError(t, err)
+	matches, err = loaded.Password.Matches("secret")
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	// Neither the token nor the ones sent before can be used again
+	assert.ErrorIs(t, store.ResetPassword(token, alice), ErrInvalidPasswordReset)
+	assert.ErrorIs(t, store.ResetPassword(first, alice), ErrInvalidPasswordReset)
+
+	deleted, err := store.DeleteExpiredPasswordResets()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+}
+
+func TestUserEmail(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3UserStore(db)
+
+	alice := &User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, alice.Password.Set("secret"))
+	require.NoError(t, store.CreateUser(alice))
+	// Users without an address don't clash
+	bob := &User{Username: "bob"}
+	require.NoError(t, bob.Password.Set("secret"))
+	require.NoError(t, store.CreateUser(bob))
+	carol := &User{Username: "carol"}
+	require.NoError(t, carol.Password.Set("secret"))
+	require.NoError(t, store.CreateUser(carol))
+
+	loaded, err := store.GetUserByEmail("Alice@Example.com")
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+	assert.Equal(t, alice.ID, loaded.ID)
+	assert.Equal(t, "alice@example.com", loaded.Email)
+
+	loaded, err = store.GetUserByEmail("")
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+
+	bob.Email = "alice@example.com"
+	assert.ErrorIs(t, store.UpdateUser(bob), ErrEmailTaken)
+	again := &User{Username: "alice2", Email: "alice@example.com"}
+	require.NoError(t, again.Password.Set("secret"))
+	assert.ErrorIs(t, store.CreateUser(again), ErrEmailTaken)
+}
@@ -7,17 +7,41 @@ import (
 	"time"
 )
 
+// Session times are stored in UTC as text in sessionTimeFormat, which
+// compares correctly against sessionNow
+const (
+	sessionTimeFormat = "2006-01-02T15:04:05Z"
+	sessionNow        = `strftime('%Y-%m-%dT%H:%M:%SZ', 'now')`
+)
+
 type Session struct {
-	Token     string
-	UserID    int
-	ExpiresAt time.Time
+	ID        int64  `json:"id"`
+	Token     string `json:"-"`
+	UserID    int    `json:"-"`
+	UserAgent string `json:"userAgent"`
+	IP        string `json:"ip"`
+	// Remember sessions are kept across browser restarts
+	Remember bool `json:"remember"`
+	// Lifetime is the inactivity after which the session expires, every use
+	// pushes ExpiresAt out again
+	Lifetime   time.Duration `json:"-"`
+	LastSeenAt time.Time     `json:"lastSeenAt"`
+	ExpiresAt  time.Time     `json:"expiresAt"`
+	CreatedAt  string        `json:"createdAt"`
 }
 
 type SessionStore interface {
-	CreateSession(userID int, expiresIn time.Duration) (*Session, error)
+	CreateSession(*Session) error
 	GetSession(token string) (*Session, error)
+	TouchSession(*Session) error
+	RotateSession(*Session) error
+	ListUserSessions(userID int) ([]*Session, error)
 	DeleteSession(token string) error
+	DeleteUserSession(userID int, id int64) error
 	DeleteUserSessions(userID int) error
+	DeleteOtherUserSessions(userID int, token string) error
+	DeleteExpiredSessions() (int64, error)
+	CountActiveSessions() (int, error)
 }
 
 type Sqlite3SessionStore struct {
@@ -36,46 +60,94 @@ func generateToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-func (s *Sqlite3SessionStore) CreateSession(userID int, expiresIn time.Duration) (*Session, error) {
-	token, err := generateToken()
+// sessionColumns are read by scanSession
+const sessionColumns = `
+			id,
+			token,
+			user_id,
+			user_agent,
+			ip,
+			remember,
+			lifetime,
+			last_seen_at,
+			expires_at,
+			created_at`
+
+func scanSession(row scanner) (*Session, error) {
+	session := &Session{}
+	var lifetime int64
+	var lastSeenAt, expiresAt string
+	err := row.Scan(
+		&session.ID,
+		&session.Token,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IP,
+		&session.Remember,
+		&lifetime,
+		&lastSeenAt,
+		&expiresAt,
+		&session.CreatedAt,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	expiresAt := time.Now().Add(expiresIn)
-	query := `
-		INSERT INTO sessions (token, user_id, expires_at)
-		VALUES (?, ?, ?)
-	`
-	_, err = s.db.Exec(query, token, userID, expiresAt.Format(time.RFC3339))
+	session.Lifetime = time.Duration(lifetime) * time.Second
+	session.LastSeenAt, err = time.Parse(time.RFC3339, lastSeenAt)
+	if err != nil {
+		return nil, err
+	}
+	session.ExpiresAt, err = time.Parse(time.RFC3339, expiresAt)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Session{
-		Token:     token,
-		UserID:    userID,
-		ExpiresAt: expiresAt,
-	}, nil
+	return session, nil
 }
 
-func (s *Sqlite3SessionStore) GetSession(token string) (*Session, error) {
-	session := &Session{}
-	var expiresAtStr string
+// CreateSession stores a new session for session.UserID, filling in its id,
+// token and times
+func (s *Sqlite3SessionStore) CreateSession(session *Session) error {
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	session.Token = token
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(session.Lifetime)
 
 	query := `
-		SELECT
-			token,
-			user_id,
-			expires_at
+		INSERT INTO sessions (token, user_id, user_agent, ip, remember, lifetime, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id, created_at
+	`
+	return s.db.QueryRow(
+		query,
+		session.Token,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		session.Remember,
+		int64(session.Lifetime/time.Second),
+		session.LastSeenAt.Format(sessionTimeFormat),
+		session.ExpiresAt.Format(sessionTimeFormat),
+	).Scan(&session.ID, &session.CreatedAt)
+}
+
+func (s *Sqlite3SessionStore) GetSession(token string) (*Session, error) {
+	query := `
+		SELECT` + sessionColumns + `
 		FROM
 			sessions
 		WHERE
 			token = ?
 		AND
-			expires_at > datetime('now')
+			expires_at > ` + sessionNow + `
 	`
-	err := s.db.QueryRow(query, token).Scan(&session.Token, &session.UserID, &expiresAtStr)
+	session, err := scanSession(s.db.QueryRow(query, token))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -83,13 +155,103 @@ func (s *Sqlite3SessionStore) GetSession(token string) (*Session, error) {
 		return nil, err
 	}
 
-	expiresAt, err := time.Parse(time.RFC3339, expiresAtStr)
+	return session, nil
+}
+
+// TouchSession records that the session was just used from session.IP and
+// extends it by its lifetime
+func (s *Sqlite3SessionStore) TouchSession(session *Session) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	query := `
+		UPDATE sessions
+		SET
+			ip = ?,
+			last_seen_at = ?,
+			expires_at = ?
+		WHERE
+			id = ?
+	`
+	_, err := s.db.Exec(
+		query,
+		session.IP,
+		now.Format(sessionTimeFormat),
+		now.Add(session.Lifetime).Format(sessionTimeFormat),
+		session.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(session.Lifetime)
+	return nil
+}
+
+// RotateSession gives the session a new token, so a token seen before a
+// change of privileges can't be used afterwards
+func (s *Sqlite3SessionStore) RotateSession(session *Session) error {
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE sessions
+		SET
+			token = ?
+		WHERE
+			id = ?
+	`
+	result, err := s.db.Exec(query, token, session.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	session.Token = token
+	return nil
+}
+
+// ListUserSessions returns the sessions of the user that have not expired,
+// the most recently used first
+func (s *Sqlite3SessionStore) ListUserSessions(userID int) ([]*Session, error) {
+	query := `
+		SELECT` + sessionColumns + `
+		FROM
+			sessions
+		WHERE
+			user_id = ?
+		AND
+			expires_at > ` + sessionNow + `
+		ORDER BY last_seen_at DESC, id DESC
+	`
+	rows, err := s.db.Query(query, userID)
 	if err != nil {
 		return nil, err
 	}
-	session.ExpiresAt = expiresAt
+	defer func() { _ = rows.Close() }()
 
-	return session, nil
+	var sessions []*Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
 }
 
 func (s *Sqlite3SessionStore) DeleteSession(token string) error {
@@ -103,6 +265,33 @@ func (s *Sqlite3SessionStore) DeleteSession(token string) error {
 	return err
 }
 
+// DeleteUserSession deletes a session by id, as long as it belongs to the
+// user. It returns sql.ErrNoRows otherwise.
+func (s *Sqlite3SessionStore) DeleteUserSession(userID int, id int64) error {
+	query := `
+		DELETE FROM
+			sessions
+		WHERE
+			id = ?
+		AND
+			user_id = ?
+	`
+	result, err := s.db.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
 func (s *Sqlite3SessionStore) DeleteUserSessions(userID int) error {
 	query := `
 		DELETE FROM
@@ -113,3 +302,48 @@ func (s *Sqlite3SessionStore) DeleteUserSessions(userID int) error {
 	_, err := s.db.Exec(query, userID)
 	return err
 }
+
+// DeleteOtherUserSessions signs the user out everywhere but the session
+// with the given token
+func (s *Sqlite3SessionStore) DeleteOtherUserSessions(userID int, token string) error {
+	query := `
+		DELETE FROM
+			sessions
+		WHERE
+			user_id = ?
+		AND
+			token != ?
+	`
+	_, err := s.db.Exec(query, userID, token)
+	return err
+}
+
+// DeleteExpiredSessions removes the sessions GetSession no longer returns
+// and reports how many there were
+func (s *Sqlite3SessionStore) DeleteExpiredSessions() (int64, error) {
+	query := `
+		DELETE FROM
+			sessions
+		WHERE
+			expires_at <= ` + sessionNow + `
+	`
+	result, err := s.db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *Sqlite3SessionStore) CountActiveSessions() (int, error) {
+	query := `
+		SELECT
+			COUNT(*)
+		FROM
+			sessions
+		WHERE
+			expires_at > ` + sessionNow + `
+	`
+	var count int
+	err := s.db.QueryRow(query).Scan(&count)
+	return count, err
+}
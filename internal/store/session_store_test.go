@@ -0,0 +1,109 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserSessions(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3SessionStore(db)
+
+	phone := &Session{UserID: 1, UserAgent: "Phone", IP: "192.0.2.1", Lifetime: time.Hour}
+	laptop := &Session{UserID: 1, UserAgent: "Laptop", IP: "192.0.2.2", Lifetime: time.Hour}
+	other := &Session{UserID: 2, UserAgent: "Other", IP: "192.0.2.3", Lifetime: time.Hour}
+	for _, session := range []*Session{phone, laptop, other} {
+		require.NoError(t, store.CreateSession(session))
+	}
+
+	session, err := store.GetSession(phone.Token)
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, phone.ID, session.ID)
+	assert.Equal(t, "Phone", session.UserAgent)
+	assert.Equal(t, "192.0.2.1", session.IP)
+	assert.Equal(t, time.Hour, session.Lifetime)
+
+	session.IP = "192.0.2.9"
+	require.NoError(t, store.TouchSession(session))
+	session, err = store.GetSession(phone.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.9", session.IP)
+
+	sessions, err := store.ListUserSessions(1)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	// Sessions of other users can't be deleted by id
+	assert.ErrorIs(t, store.DeleteUserSession(1, other.ID), sql.ErrNoRows)
+
+	require.NoError(t, store.DeleteOtherUserSessions(1, laptop.Token))
+	sessions, err = store.ListUserSessions(1)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, laptop.ID, sessions[0].ID)
+
+	require.NoError(t, store.DeleteUserSession(1, laptop.ID))
+	session, err = store.GetSession(laptop.Token)
+	require.NoError(t, err)
+	assert.Nil(t, session)
+
+	session, err = store.GetSession(other.Token)
+	require.NoError(t, err)
+	assert.NotNil(t, session)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3SessionStore(db)
+
+	session := &Session{UserID: 1, Lifetime: time.Minute}
+	require.NoError(t, store.CreateSession(session))
+
+	// Touching slides the expiry by the lifetime
+	session.Lifetime = time.Hour
+	_, err := db.Exec(`UPDATE sessions SET lifetime = 3600 WHERE id = ?`, session.ID)
+	require.NoError(t, err)
+	require.NoError(t, store.TouchSession(session))
+	got, err := store.GetSession(session.Token)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), got.ExpiresAt, 2*time.Second)
+
+	oldToken := session.Token
+	require.NoError(t, store.RotateSession(session))
+	assert.NotEqual(t, oldToken, session.Token)
+	got, err = store.GetSession(oldToken)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = store.GetSession(session.Token)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, session.ID, got.ID)
+
+	// A session that expired a second ago, earlier the same day
+	expired := &Session{UserID: 1, Lifetime: -time.Second}
+	require.NoError(t, store.CreateSession(expired))
+	got, err = store.GetSession(expired.Token)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	count, err := store.CountActiveSessions()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	deleted, err := store.DeleteExpiredSessions()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	got, err = store.GetSession(session.Token)
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+}
@@ -0,0 +1,380 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// RecoveryCodeCount is the number of recovery codes handed out at a time
+const RecoveryCodeCount = 10
+
+type TOTP struct {
+	UserID       int
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+}
+
+// LoginChallenge is a login that passed the password check and waits for
+// the second factor
+type LoginChallenge struct {
+	Token     string
+	UserID    int
+	Remember  bool
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+type Sqlite3TwoFactorStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3TwoFactorStore(db *sql.DB) *Sqlite3TwoFactorStore {
+	return &Sqlite3TwoFactorStore{db: db}
+}
+
+type TwoFactorStore interface {
+	GetTOTP(userID int) (*TOTP, error)
+	SaveTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, step int64, recoveryCodes []string) error
+	DisableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID int, recoveryCodes []string) error
+	UseRecoveryCode(userID int, code string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+	CreateLoginChallenge(*LoginChallenge) error
+	GetLoginChallenge(token string) (*LoginChallenge, error)
+	AddLoginChallengeAttempt(token string) error
+	DeleteLoginChallenge(token string) error
+	DeleteExpiredLoginChallenges() (int64, error)
+}
+
+// NewRecoveryCodes returns RecoveryCodeCount random codes like
+// "k3vq7-pxw2a". Only their hashes are stored.
+func NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	for range RecoveryCodeCount {
+		bytes := make([]byte, 7)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes. The codes are random,
+// so a fast hash is enough.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetTOTP returns nil if the user never started enrolling
+func (s *Sqlite3TwoFactorStore) GetTOTP(userID int) (*TOTP, error) {
+	totp := &TOTP{UserID: userID}
+	query := `
+		SELECT
+			secret,
+			enabled_at IS NOT NULL,
+			last_used_step
+		FROM
+			user_totp
+		WHERE
+			user_id = ?
+	`
+	err := s.db.QueryRow(query, userID).Scan(&totp.Secret, &totp.Enabled, &totp.LastUsedStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return totp, nil
+}
+
+// SaveTOTPSecret starts an enrollment with a new secret. Two-factor stays
+// disabled until EnableTOTP.
+func (s *Sqlite3TwoFactorStore) SaveTOTPSecret(userID int, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = excluded.secret,
+			enabled_at = NULL,
+			last_used_step = 0
+	`
+	_, err := s.db.Exec(query, userID, secret)
+	return err
+}
+
+// EnableTOTP completes the enrollment once a code for step was verified and
+// replaces the recovery codes
+func (s *Sqlite3TwoFactorStore) EnableTOTP(userID int, step int64, recoveryCodes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `
+		UPDATE user_totp
+		SET
+			enabled_at = datetime('now'),
+			last_used_step = ?
+		WHERE
+			user_id = ?
+	`
+	result, err := tx.Exec(query, step, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP removes the secret and recovery codes of the user. It returns
+// sql.ErrNoRows if there were none.
+func (s *Sqlite3TwoFactorStore) DisableTOTP(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code of step was accepted. It reports false if
+// a code of that or a later step was accepted before.
+func (s *Sqlite3TwoFactorStore) UseTOTPStep(userID int, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp
+		SET
+			last_used_step = ?
+		WHERE
+			user_id = ?
+		AND
+			last_used_step < ?
+	`
+	result, err := s.db.Exec(query, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (s *Sqlite3TwoFactorStore) ReplaceRecoveryCodes(userID int, recoveryCodes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, recoveryCodes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO recovery_codes (user_id, code_hash)
+		VALUES (?, ?)
+	`
+	for _, code := range recoveryCodes {
+		if _, err := tx.Exec(query, userID, hashRecoveryCode(code)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the code as used and reports whether it was a valid,
+// unused one
+func (s *Sqlite3TwoFactorStore) UseRecoveryCode(userID int, code string) (bool, error) {
+	query := `
+		UPDATE recovery_codes
+		SET
+			used_at = datetime('now')
+		WHERE
+			user_id = ?
+		AND
+			code_hash = ?
+		AND
+			used_at IS NULL
+	`
+	result, err := s.db.Exec(query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes
+func (s *Sqlite3TwoFactorStore) CountRecoveryCodes(userID int) (int, error) {
+	query := `
+		SELECT
+			COUNT(*)
+		FROM
+			recovery_codes
+		WHERE
+			user_id = ?
+		AND
+			used_at IS NULL
+	`
+	var count int
+	err := s.db.QueryRow(query, userID).Scan(&count)
+	return count, err
+}
+
+// CreateLoginChallenge stores the challenge, filling in its token
+func (s *Sqlite3TwoFactorStore) CreateLoginChallenge(challenge *LoginChallenge) error {
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+	challenge.Token = token
+
+	query := `
+		INSERT INTO login_challenges (token, user_id, remember, expires_at)
+		VALUES (?, ?, ?, ?)
+	`
+	_, err = s.db.Exec(
+		query,
+		challenge.Token,
+		challenge.UserID,
+		challenge.Remember,
+		challenge.ExpiresAt.UTC().Format(sessionTimeFormat),
+	)
+	return err
+}
+
+// GetLoginChallenge returns nil if there is no such challenge or it expired
+func (s *Sqlite3TwoFactorStore) GetLoginChallenge(token string) (*LoginChallenge, error) {
+	challenge := &LoginChallenge{}
+	var expiresAt string
+	query := `
+		SELECT
+			token,
+			user_id,
+			remember,
+			attempts,
+			expires_at
+		FROM
+			login_challenges
+		WHERE
+			token = ?
+		AND
+			expires_at > ` + sessionNow + `
+	`
+	err := s.db.QueryRow(query, token).Scan(
+		&challenge.Token,
+		&challenge.UserID,
+		&challenge.Remember,
+		&challenge.Attempts,
+		&expiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	challenge.ExpiresAt, err = time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+func (s *Sqlite3TwoFactorStore) AddLoginChallengeAttempt(token string) error {
+	query := `
+		UPDATE login_challenges
+		SET
+			attempts = attempts + 1
+		WHERE
+			token = ?
+	`
+	_, err := s.db.Exec(query, token)
+	return err
+}
+
+func (s *Sqlite3TwoFactorStore) DeleteLoginChallenge(token string) error {
+	query := `
+		DELETE FROM
+			login_challenges
+		WHERE
+			token = ?
+	`
+	_, err := s.db.Exec(query, token)
+	return err
+}
+
+func (s *Sqlite3TwoFactorStore) DeleteExpiredLoginChallenges() (int64, error) {
+	query := `
+		DELETE FROM
+			login_challenges
+		WHERE
+			expires_at <= ` + sessionNow + `
+	`
+	result, err := s.db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPEnrollment(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3TwoFactorStore(db)
+
+	totp, err := store.GetTOTP(1)
+	require.NoError(t, err)
+	assert.Nil(t, totp)
+
+	require.NoError(t, store.SaveTOTPSecret(1, "JBSWY3DPEHPK3PXP"))
+	totp, err = store.GetTOTP(1)
+	require.NoError(t, err)
+	require.NotNil(t, totp)
+	assert.False(t, totp.Enabled)
+
+	codes, err := NewRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, RecoveryCodeCount)
+	require.NoError(t, store.EnableTOTP(1, 100, codes))
+
+	totp, err = store.GetTOTP(1)
+	require.NoError(t, err)
+	assert.True(t, totp.Enabled)
+	assert.Equal(t, int64(100), totp.LastUsedStep)
+
+	// A step can't be used twice, nor can an earlier one
+	used, err := store.UseTOTPStep(1, 100)
+	require.NoError(t, err)
+	assert.False(t, used)
+	used, err = store.UseTOTPStep(1, 101)
+	require.NoError(t, err)
+	assert.True(t, used)
+
+	// Recovery codes work once, regardless of case and dashes
+	used, err = store.UseRecoveryCode(1, "wrong-code")
+	require.NoError(t, err)
+	assert.False(t, used)
+	used, err = store.UseRecoveryCode(1, strings.ToUpper(codes[0][:5]+codes[0][6:]))
+	require.NoError(t, err)
+	assert.True(t, used)
+	used, err = store.UseRecoveryCode(1, codes[0])
+	require.NoError(t, err)
+	assert.False(t, used)
+	used, err = store.UseRecoveryCode(2, codes[1])
+	require.NoError(t, err)
+	assert.False(t, used)
+
+	count, err := store.CountRecoveryCodes(1)
+	require.NoError(t, err)
+	assert.Equal(t, RecoveryCodeCount-1, count)
+
+	require.NoError(t, store.DisableTOTP(1))
+	totp, err = store.GetTOTP(1)
+	require.NoError(t, err)
+	assert.Nil(t, totp)
+	count, err = store.CountRecoveryCodes(1)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	assert.ErrorIs(t, store.DisableTOTP(1), sql.ErrNoRows)
+}
+
+func TestLoginChallenges(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3TwoFactorStore(db)
+
+	current := &LoginChallenge{UserID: 1, Remember: true, ExpiresAt: time.Now().Add(time.Minute)}
+	expired := &LoginChallenge{UserID: 1, ExpiresAt: time.Now().Add(-time.Minute)}
+	require.NoError(t, store.CreateLoginChallenge(current))
+	require.NoError(t, store.CreateLoginChallenge(expired))
+
+	require.NoError(t, store.AddLoginChallengeAttempt(current.Token))
+	challenge, err := store.GetLoginChallenge(current.Token)
+	require.NoError(t, err)
+	require.NotNil(t, challenge)
+	assert.True(t, challenge.Remember)
+	assert.Equal(t, 1, challenge.Attempts)
+
+	challenge, err = store.GetLoginChallenge(expired.Token)
+	require.NoError(t, err)
+	assert.Nil(t, challenge)
+
+	deleted, err := store.DeleteExpiredLoginChallenges()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	require.NoError(t, store.DeleteLoginChallenge(current.Token))
+	challenge, err = store.GetLoginChallenge(current.Token)
+	require.NoError(t, err)
+	assert.Nil(t, challenge)
+}
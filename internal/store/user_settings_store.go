@@ -0,0 +1,123 @@
+package store
+
+import (
+	"database/sql"
+)
+
+const (
+	ThemeSystem = "system"
+	ThemeLight  = "light"
+	ThemeDark   = "dark"
+
+	SortNewest = "newest"
+	SortOldest = "oldest"
+)
+
+type UserSettings struct {
+	UserID           int    `json:"-"`
+	Theme            string `json:"theme"`
+	SortOrder        string `json:"sortOrder"`
+	UnreadOnly       bool   `json:"unreadOnly"`
+	ItemsPerPage     int    `json:"itemsPerPage"`
+	MarkReadOnScroll bool   `json:"markReadOnScroll"`
+	Timezone         string `json:"timezone"`
+	DateFormat       string `json:"dateFormat"`
+}
+
+// DefaultUserSettings are used until a user saves their own
+func DefaultUserSettings(userID int) *UserSettings {
+	return &UserSettings{
+		UserID:       userID,
+		Theme:        ThemeSystem,
+		SortOrder:    SortNewest,
+		ItemsPerPage: 50,
+		Timezone:     "UTC",
+		DateFormat:   "relative",
+	}
+}
+
+type Sqlite3UserSettingsStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3UserSettingsStore(db *sql.DB) *Sqlite3UserSettingsStore {
+	return &Sqlite3UserSettingsStore{db: db}
+}
+
+type UserSettingsStore interface {
+	GetUserSettings(userID int) (*UserSettings, error)
+	SaveUserSettings(*UserSettings) error
+}
+
+// GetUserSettings returns the defaults if the user never saved any settings
+func (s *Sqlite3UserSettingsStore) GetUserSettings(userID int) (*UserSettings, error) {
+	settings := &UserSettings{UserID: userID}
+	query := `
+		SELECT
+			theme,
+			sort_order,
+			unread_only,
+			items_per_page,
+			mark_read_on_scroll,
+			timezone,
+			date_format
+		FROM
+			user_settings
+		WHERE
+			user_id = ?
+	`
+	err := s.db.QueryRow(query, userID).Scan(
+		&settings.Theme,
+		&settings.SortOrder,
+		&settings.UnreadOnly,
+		&settings.ItemsPerPage,
+		&settings.MarkReadOnScroll,
+		&settings.Timezone,
+		&settings.DateFormat,
+	)
+	if err == sql.ErrNoRows {
+		return DefaultUserSettings(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+func (s *Sqlite3UserSettingsStore) SaveUserSettings(settings *UserSettings) error {
+	query := `
+		INSERT INTO user_settings (
+			user_id,
+			theme,
+			sort_order,
+			unread_only,
+			items_per_page,
+			mark_read_on_scroll,
+			timezone,
+			date_format
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			theme = excluded.theme,
+			sort_order = excluded.sort_order,
+			unread_only = excluded.unread_only,
+			items_per_page = excluded.items_per_page,
+			mark_read_on_scroll = excluded.mark_read_on_scroll,
+			timezone = excluded.timezone,
+			date_format = excluded.date_format,
+			modified_at = datetime('now')
+	`
+	_, err := s.db.Exec(
+		query,
+		settings.UserID,
+		settings.Theme,
+		settings.SortOrder,
+		settings.UnreadOnly,
+		settings.ItemsPerPage,
+		settings.MarkReadOnScroll,
+		settings.Timezone,
+		settings.DateFormat,
+	)
+	return err
+}
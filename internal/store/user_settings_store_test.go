@@ -0,0 +1,42 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserSettings(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3UserSettingsStore(db)
+
+	settings, err := store.GetUserSettings(1)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultUserSettings(1), settings)
+
+	settings.Theme = ThemeDark
+	settings.SortOrder = SortOldest
+	settings.UnreadOnly = true
+	settings.ItemsPerPage = 20
+	settings.Timezone = "Europe/Zurich"
+	require.NoError(t, store.SaveUserSettings(settings))
+
+	got, err := store.GetUserSettings(1)
+	require.NoError(t, err)
+	assert.Equal(t, settings, got)
+
+	// Saving again updates the existing row
+	settings.MarkReadOnScroll = true
+	require.NoError(t, store.SaveUserSettings(settings))
+
+	got, err = store.GetUserSettings(1)
+	require.NoError(t, err)
+	assert.Equal(t, settings, got)
+
+	other, err := store.GetUserSettings(2)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultUserSettings(2), other)
+}
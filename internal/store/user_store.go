@@ -1,19 +1,39 @@
 package store
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"strings"
+	"sync"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// passwordCost is the bcrypt cost of password hashes
+const passwordCost = 12
+
+// dummyPasswordHash is compared against when there is no user to check a
+// password of, see CompareDummyPassword
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), passwordCost)
+	return hash
+})
+
+// CompareDummyPassword does the work of a password check without a user, so
+// logins of unknown usernames take as long as wrong passwords
+func CompareDummyPassword(plainTextPassword string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(plainTextPassword))
+}
+
 type password struct {
 	plainText *string
 	hash      []byte
 }
 
 func (p *password) Set(plainTextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plainTextPassword), 12)
+	hash, err := bcrypt.GenerateFromPassword([]byte(plainTextPassword), passwordCost)
 	if err != nil {
 		return err
 	}
@@ -24,6 +44,16 @@ func (p *password) Set(plainTextPassword string) error {
 	return nil
 }
 
+// SetRandom sets a password nobody knows, for users who sign in through
+// something else
+func (p *password) SetRandom() error {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return err
+	}
+	return p.Set(hex.EncodeToString(bytes))
+}
+
 func (p *password) Matches(plainTextPassword string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plainTextPassword))
 	if err != nil {
@@ -39,11 +69,39 @@ func (p *password) Matches(plainTextPassword string) (bool, error) {
 }
 
 type User struct {
-	ID       int      `json:"id"`
-	Username string   `json:"username"`
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	// Email is where password reset links go, it is empty if the user has
+	// none and lower case otherwise
+	Email   string `json:"email"`
+	IsAdmin bool   `json:"isAdmin"`
+	// Disabled users can't sign in
+	Disabled bool     `json:"disabled"`
 	Password password `json:"-"`
 }
 
+// ErrUsernameTaken is returned when creating or renaming a user to a
+// username that is in use
+var ErrUsernameTaken = errors.New("username is already taken")
+
+// ErrEmailTaken is returned when saving a user with an email address of
+// another user
+var ErrEmailTaken = errors.New("email address is already in use")
+
+// userError maps the unique constraints of users to ErrUsernameTaken and
+// ErrEmailTaken
+func userError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case strings.Contains(err.Error(), "UNIQUE constraint failed: users.username"):
+		return ErrUsernameTaken
+	case strings.Contains(err.Error(), "UNIQUE constraint failed: users.email"):
+		return ErrEmailTaken
+	}
+	return err
+}
+
 type Sqlite3UserStore struct {
 	db *sql.DB
 }
@@ -56,24 +114,32 @@ type UserStore interface {
 	CreateUser(*User) error
 	GetUserByUsername(username string) (*User, error)
 	GetUserByID(id int) (*User, error)
+	GetUserByEmail(email string) (*User, error)
 	UpdateUser(*User) error
+	SetUserAdmin(id int, isAdmin bool) error
+	SetUserDisabled(id int, disabled bool) error
+	ListUsers() ([]*User, error)
+	DeleteUser(id int) error
 }
 
+// CreateUser returns ErrUsernameTaken or ErrEmailTaken if the username or
+// email address is in use
 func (s *Sqlite3UserStore) CreateUser(user *User) error {
 	query := `
 		INSERT INTO
-			users (username, password)
+			users (username, email, password, is_admin)
 		VALUES
-			(?, ?) RETURNING id
+			(?, ?, ?, ?) RETURNING id
 	`
-	err := s.db.QueryRow(query, user.Username, user.Password.hash).Scan(&user.ID)
+	err := s.db.QueryRow(query, user.Username, user.Email, user.Password.hash, user.IsAdmin).Scan(&user.ID)
 	if err != nil {
-		return err
+		return userError(err)
 	}
 
 	return nil
 }
 
+// GetUserByUsername ignores the case of username, like the unique index
 func (s *Sqlite3UserStore) GetUserByUsername(username string) (*User, error) {
 	user := &User{
 		Password: password{},
@@ -83,15 +149,18 @@ func (s *Sqlite3UserStore) GetUserByUsername(username string) (*User, error) {
 		SELECT
 			id,
 			username,
+			email,
+			is_admin,
+			disabled_at IS NOT NULL,
 			password
 		FROM
 			users
 		WHERE
-			username = ?
+			username = ? COLLATE NOCASE
 	`
 
 	var passwordHash []byte
-	err := s.db.QueryRow(query, username).Scan(&user.ID, &user.Username, &passwordHash)
+	err := s.db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Email, &user.IsAdmin, &user.Disabled, &passwordHash)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -111,14 +180,20 @@ func (s *Sqlite3UserStore) GetUserByID(id int) (*User, error) {
 	query := `
 		SELECT
 			id,
-			username
+			username,
+			email,
+			is_admin,
+			disabled_at IS NOT NULL,
+			password
 		FROM
 			users
 		WHERE
 			id = ?
 	`
 
-	err := s.db.QueryRow(query, id).Scan(&user.ID, &user.Username)
+	// The hash is loaded too, UpdateUser would clear it otherwise
+	var passwordHash []byte
+	err := s.db.QueryRow(query, id).Scan(&user.ID, &user.Username, &user.Email, &user.IsAdmin, &user.Disabled, &passwordHash)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -126,20 +201,173 @@ func (s *Sqlite3UserStore) GetUserByID(id int) (*User, error) {
 		return nil, err
 	}
 
+	user.Password.hash = passwordHash
 	return user, nil
 }
 
+// GetUserByEmail returns nil if no user has the email address, which is
+// compared case insensitively
+func (s *Sqlite3UserStore) GetUserByEmail(email string) (*User, error) {
+	if email == "" {
+		return nil, nil
+	}
+
+	user := &User{
+		Password: password{},
+	}
+
+	query := `
+		SELECT
+			id,
+			username,
+			email,
+			is_admin,
+			disabled_at IS NOT NULL,
+			password
+		FROM
+			users
+		WHERE
+			email = lower(?)
+	`
+
+	var passwordHash []byte
+	err := s.db.QueryRow(query, email).Scan(&user.ID, &user.Username, &user.Email, &user.IsAdmin, &user.Disabled, &passwordHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user.Password.hash = passwordHash
+	return user, nil
+}
+
+// UpdateUser saves the username, email address and password, it returns
+// ErrUsernameTaken or ErrEmailTaken if the username or email address is in
+// use
 func (s *Sqlite3UserStore) UpdateUser(user *User) error {
 	query := `
 		UPDATE users
 		SET
 			username = ?,
+			email = ?,
 			password = ?
 		WHERE
 			id = ?
 	`
 
-	result, err := s.db.Exec(query, user.Username, user.Password.hash)
+	result, err := s.db.Exec(query, user.Username, user.Email, user.Password.hash, user.ID)
+	if err != nil {
+		return userError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *Sqlite3UserStore) SetUserAdmin(id int, isAdmin bool) error {
+	query := `
+		UPDATE users
+		SET
+			is_admin = ?
+		WHERE
+			id = ?
+	`
+
+	result, err := s.db.Exec(query, isAdmin, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetUserDisabled disables or enables a user. Their sessions are kept,
+// deleting them is up to the caller.
+func (s *Sqlite3UserStore) SetUserDisabled(id int, disabled bool) error {
+	query := `
+		UPDATE users
+		SET
+			disabled_at = CASE WHEN ? THEN COALESCE(disabled_at, ` + sessionNow + `) END
+		WHERE
+			id = ?
+	`
+
+	result, err := s.db.Exec(query, disabled, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *Sqlite3UserStore) ListUsers() ([]*User, error) {
+	query := `
+		SELECT
+			id,
+			username,
+			email,
+			is_admin,
+			disabled_at IS NOT NULL
+		FROM
+			users
+		ORDER BY id
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var users []*User
+	for rows.Next() {
+		user := &User{}
+		err = rows.Scan(&user.ID, &user.Username, &user.Email, &user.IsAdmin, &user.Disabled)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (s *Sqlite3UserStore) DeleteUser(id int) error {
+	query := `
+		DELETE FROM
+			users
+		WHERE
+			id = ?
+	`
+	result, err := s.db.Exec(query, id)
 	if err != nil {
 		return err
 	}
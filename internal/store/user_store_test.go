@@ -0,0 +1,127 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3UserStore(db)
+
+	user := &User{Username: "alice"}
+	require.NoError(t, user.Password.Set("secret"))
+	require.NoError(t, store.CreateUser(user))
+
+	// Renaming a user loaded by id keeps their password
+	loaded, err := store.GetUserByID(user.ID)
+	require.NoError(t, err)
+	loaded.Username = "alice2"
+	require.NoError(t, store.UpdateUser(loaded))
+
+	renamed, err := store.GetUserByUsername("alice2")
+	require.NoError(t, err)
+	require.NotNil(t, renamed)
+	matches, err := renamed.Password.Matches("secret")
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	require.NoError(t, renamed.Password.Set("changed"))
+	require.NoError(t, store.UpdateUser(renamed))
+
+	loaded, err = store.GetUserByID(user.ID)
+	require.NoError(t, err)
+	matches, err = loaded.Password.Matches("changed")
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	assert.ErrorIs(t, store.UpdateUser(&User{ID: user.ID + 1, Username: "nobody"}), sql.ErrNoRows)
+}
+
+func TestUsernameTaken(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3UserStore(db)
+
+	alice := &User{Username: "alice"}
+	require.NoError(t, alice.Password.Set("secret"))
+	require.NoError(t, store.CreateUser(alice))
+	bob := &User{Username: "bob"}
+	require.NoError(t, bob.Password.Set("secret"))
+	require.NoError(t, store.CreateUser(bob))
+
+	again := &User{Username: "alice"}
+	require.NoError(t, again.Password.Set("secret"))
+	assert.ErrorIs(t, store.CreateUser(again), ErrUsernameTaken)
+	again.Username = "Alice"
+	assert.ErrorIs(t, store.CreateUser(again), ErrUsernameTaken)
+
+	bob.Username = "ALICE"
+	assert.ErrorIs(t, store.UpdateUser(bob), ErrUsernameTaken)
+
+	// Lookups ignore case as well
+	loaded, err := store.GetUserByUsername("Alice")
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+	assert.Equal(t, alice.ID, loaded.ID)
+}
+
+func TestSetUserAdmin(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3UserStore(db)
+
+	user := &User{Username: "alice"}
+	require.NoError(t, user.Password.Set("secret"))
+	require.NoError(t, store.CreateUser(user))
+	assert.False(t, user.IsAdmin)
+
+	require.NoError(t, store.SetUserAdmin(user.ID, true))
+	loaded, err := store.GetUserByUsername("alice")
+	require.NoError(t, err)
+	assert.True(t, loaded.IsAdmin)
+
+	users, err := store.ListUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.True(t, users[0].IsAdmin)
+
+	require.NoError(t, store.SetUserAdmin(user.ID, false))
+	loaded, err = store.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.False(t, loaded.IsAdmin)
+
+	assert.ErrorIs(t, store.SetUserAdmin(user.ID+1, true), sql.ErrNoRows)
+}
+
+func TestSetUserDisabled(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	store := NewSqlite3UserStore(db)
+
+	user := &User{Username: "alice"}
+	require.NoError(t, user.Password.Set("secret"))
+	require.NoError(t, store.CreateUser(user))
+
+	require.NoError(t, store.SetUserDisabled(user.ID, true))
+	// Disabling twice keeps the user disabled
+	require.NoError(t, store.SetUserDisabled(user.ID, true))
+	loaded, err := store.GetUserByUsername("alice")
+	require.NoError(t, err)
+	assert.True(t, loaded.Disabled)
+
+	require.NoError(t, store.SetUserDisabled(user.ID, false))
+	loaded, err = store.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.False(t, loaded.Disabled)
+
+	assert.ErrorIs(t, store.SetUserDisabled(user.ID+1, true), sql.ErrNoRows)
+}
@@ -0,0 +1,425 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Statuses of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is called with new items of its user's feeds
+type Webhook struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"-"`
+	URL    string `json:"url"`
+	// Secret signs the payloads, it is only shown when the webhook is created
+	Secret string `json:"-"`
+	// FeedID limits the webhook to one feed, it gets all feeds if 0
+	FeedID int `json:"feedId,omitempty"`
+	// Category limits the webhook to the feeds of that category
+	Category string `json:"category,omitempty"`
+	// Keyword limits the webhook to items with it in their title or
+	// description
+	Keyword   string    `json:"keyword,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery is one call of a webhook, along with its retries
+type WebhookDelivery struct {
+	ID        int64  `json:"id"`
+	WebhookID int    `json:"webhookId"`
+	Event     string `json:"event"`
+	Payload   string `json:"payload"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	// ResponseStatus and Error are of the last attempt
+	ResponseStatus int    `json:"responseStatus,omitempty"`
+	Error          string `json:"error,omitempty"`
+	// NextAttemptAt is zero once the delivery is done
+	NextAttemptAt time.Time `json:"nextAttemptAt,omitzero"`
+	LastAttemptAt time.Time `json:"lastAttemptAt,omitzero"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+type Sqlite3WebhookStore struct {
+	db *sql.DB
+}
+
+func NewSqlite3WebhookStore(db *sql.DB) *Sqlite3WebhookStore {
+	return &Sqlite3WebhookStore{db: db}
+}
+
+type WebhookStore interface {
+	CreateWebhook(*Webhook) error
+	GetWebhook(id int) (*Webhook, error)
+	ListWebhooks(userID int) ([]*Webhook, error)
+	ListFeedWebhooks(feedID int) ([]*Webhook, error)
+	DeleteWebhook(id int) error
+	CreateWebhookDelivery(*WebhookDelivery) error
+	ListDueWebhookDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error)
+	ListWebhookDeliveries(webhookID int, limit int) ([]*WebhookDelivery, error)
+	RecordWebhookAttempt(*WebhookDelivery) error
+	DeleteOldWebhookDeliveries(before time.Time) (int64, error)
+}
+
+// NewWebhookSecret returns a random secret for webhooks created without one
+func NewWebhookSecret() (string, error) {
+	return generateToken()
+}
+
+func (s *Sqlite3WebhookStore) CreateWebhook(webhook *Webhook) error {
+	var feedID sql.NullInt64
+	if webhook.FeedID != 0 {
+		feedID = sql.NullInt64{Int64: int64(webhook.FeedID), Valid: true}
+	}
+
+	query := `
+		INSERT INTO webhooks (user_id, url, secret, feed_id, category, keyword)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id, created_at
+	`
+	var createdAt string
+	err := s.db.QueryRow(
+		query,
+		webhook.UserID,
+		webhook.URL,
+		webhook.Secret,
+		feedID,
+		webhook.Category,
+		webhook.Keyword,
+	).Scan(&webhook.ID, &createdAt)
+	if err != nil {
+		return err
+	}
+
+	webhook.CreatedAt, err = time.Parse(time.RFC3339, createdAt)
+	return err
+}
+
+const webhookColumns = `
+	id,
+	user_id,
+	url,
+	secret,
+	COALESCE(feed_id, 0),
+	category,
+	keyword,
+	created_at
+`
+
+func scanWebhook(row scanner) (*Webhook, error) {
+	webhook := &Webhook{}
+	var createdAt string
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Secret,
+		&webhook.FeedID,
+		&webhook.Category,
+		&webhook.Keyword,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.CreatedAt, err = time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *Sqlite3WebhookStore) GetWebhook(id int) (*Webhook, error) {
+	query := `
+		SELECT` + webhookColumns + `
+		FROM
+			webhooks
+		WHERE
+			id = ?
+	`
+	webhook, err := scanWebhook(s.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (s *Sqlite3WebhookStore) listWebhooks(query string, args ...any) ([]*Webhook, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (s *Sqlite3WebhookStore) ListWebhooks(userID int) ([]*Webhook, error) {
+	query := `
+		SELECT` + webhookColumns + `
+		FROM
+			webhooks
+		WHERE
+			user_id = ?
+		ORDER BY id
+	`
+	return s.listWebhooks(query, userID)
+}
+
+// ListFeedWebhooks returns the webhooks of the feed's user that get its
+// items
+func (s *Sqlite3WebhookStore) ListFeedWebhooks(feedID int) ([]*Webhook, error) {
+	query := `
+		SELECT` + webhookColumns + `
+		FROM
+			webhooks
+		WHERE
+			user_id = (SELECT user_id FROM feeds WHERE id = ?)
+		AND
+			(feed_id IS NULL OR feed_id = ?)
+		AND
+			(category = '' OR category = (SELECT category FROM feeds WHERE id = ?))
+		ORDER BY id
+	`
+	return s.listWebhooks(query, feedID, feedID, feedID)
+}
+
+// DeleteWebhook deletes the webhook along with its deliveries
+func (s *Sqlite3WebhookStore) DeleteWebhook(id int) error {
+	query := `
+		DELETE FROM
+			webhooks
+		WHERE
+			id = ?
+	`
+	result, err := s.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func nullTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.UTC().Format(sessionTimeFormat), Valid: true}
+}
+
+// CreateWebhookDelivery stores a delivery to be attempted at its
+// NextAttemptAt
+func (s *Sqlite3WebhookStore) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	if delivery.Status == "" {
+		delivery.Status = DeliveryPending
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id, created_at
+	`
+	var createdAt string
+	err := s.db.QueryRow(
+		query,
+		delivery.WebhookID,
+		delivery.Event,
+		delivery.Payload,
+		delivery.Status,
+		nullTime(delivery.NextAttemptAt),
+	).Scan(&delivery.ID, &createdAt)
+	if err != nil {
+		return err
+	}
+
+	delivery.CreatedAt, err = time.Parse(time.RFC3339, createdAt)
+	return err
+}
+
+const webhookDeliveryColumns = `
+	id,
+	webhook_id,
+	event,
+	payload,
+	status,
+	attempts,
+	response_status,
+	error,
+	next_attempt_at,
+	last_attempt_at,
+	created_at
+`
+
+func (s *Sqlite3WebhookStore) listWebhookDeliveries(query string, args ...any) ([]*WebhookDelivery, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery := &WebhookDelivery{}
+		var nextAttemptAt, lastAttemptAt sql.NullString
+		var createdAt string
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.ResponseStatus,
+			&delivery.Error,
+			&nextAttemptAt,
+			&lastAttemptAt,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if nextAttemptAt.Valid {
+			if delivery.NextAttemptAt, err = time.Parse(time.RFC3339, nextAttemptAt.String); err != nil {
+				return nil, err
+			}
+		}
+		if lastAttemptAt.Valid {
+			if delivery.LastAttemptAt, err = time.Parse(time.RFC3339, lastAttemptAt.String); err != nil {
+				return nil, err
+			}
+		}
+		if delivery.CreatedAt, err = time.Parse(time.RFC3339, createdAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// ListDueWebhookDeliveries returns up to limit pending deliveries to be
+// attempted at now, oldest first
+func (s *Sqlite3WebhookStore) ListDueWebhookDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error) {
+	query := `
+		SELECT` + webhookDeliveryColumns + `
+		FROM
+			webhook_deliveries
+		WHERE
+			status = 'pending'
+		AND
+			next_attempt_at <= ?
+		ORDER BY next_attempt_at, id
+		LIMIT ?
+	`
+	return s.listWebhookDeliveries(query, now.UTC().Format(sessionTimeFormat), limit)
+}
+
+// ListWebhookDeliveries returns the latest deliveries of the webhook, newest
+// first
+func (s *Sqlite3WebhookStore) ListWebhookDeliveries(webhookID int, limit int) ([]*WebhookDelivery, error) {
+	query := `
+		SELECT` + webhookDeliveryColumns + `
+		FROM
+			webhook_deliveries
+		WHERE
+			webhook_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	return s.listWebhookDeliveries(query, webhookID, limit)
+}
+
+// RecordWebhookAttempt saves the outcome of an attempt: the status,
+// attempts, response status, error and next attempt of the delivery
+func (s *Sqlite3WebhookStore) RecordWebhookAttempt(delivery *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET
+			status = ?,
+			attempts = ?,
+			response_status = ?,
+			error = ?,
+			next_attempt_at = ?,
+			last_attempt_at = ?
+		WHERE
+			id = ?
+	`
+	result, err := s.db.Exec(
+		query,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.ResponseStatus,
+		delivery.Error,
+		nullTime(delivery.NextAttemptAt),
+		nullTime(delivery.LastAttemptAt),
+		delivery.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteOldWebhookDeliveries deletes the log of deliveries that are done
+// and were created before then
+func (s *Sqlite3WebhookStore) DeleteOldWebhookDeliveries(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM
+			webhook_deliveries
+		WHERE
+			status != 'pending'
+		AND
+			created_at < ?
+	`
+	result, err := s.db.Exec(query, before.UTC().Format(sessionTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
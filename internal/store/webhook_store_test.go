@@ -0,0 +1,107 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooks(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() { _ = db.Close() }()
+
+	feedStore := NewSqlite3FeedStore(db)
+	store := NewSqlite3WebhookStore(db)
+
+	news, err := feedStore.CreateFeed(&Feed{UserID: 1, Title: "News", Link: "https://example.com/news.xml", Category: "News"})
+	require.NoError(t, err)
+	blog, err := feedStore.CreateFeed(&Feed{UserID: 1, Title: "Blog", Link: "https://example.com/blog.xml"})
+	require.NoError(t, err)
+	other, err := feedStore.CreateFeed(&Feed{UserID: 2, Title: "Other", Link: "https://example.com/other.xml"})
+	require.NoError(t, err)
+
+	all := &Webhook{UserID: 1, URL: "https://hooks.example.com/all", Secret: "secret"}
+	require.NoError(t, store.CreateWebhook(all))
+	assert.NotZero(t, all.ID)
+	assert.False(t, all.CreatedAt.IsZero())
+	newsOnly := &Webhook{UserID: 1, URL: "https://hooks.example.com/news", Secret: "secret", FeedID: news.ID, Keyword: "go"}
+	require.NoError(t, store.CreateWebhook(newsOnly))
+	require.NoError(t, store.CreateWebhook(&Webhook{UserID: 2, URL: "https://hooks.example.com/bob", Secret: "secret"}))
+	newsCategory := &Webhook{UserID: 1, URL: "https://hooks.example.com/category", Secret: "secret", Category: "News"}
+	require.NoError(t, store.CreateWebhook(newsCategory))
+
+	loaded, err := store.GetWebhook(newsOnly.ID)
+	require.NoError(t, err)
+	assert.Equal(t, newsOnly, loaded)
+	loaded, err = store.GetWebhook(12345)
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+
+	webhooks, err := store.ListWebhooks(1)
+	require.NoError(t, err)
+	assert.Len(t, webhooks, 3)
+
+	ids := func(webhooks []*Webhook) []int {
+		ids := []int{}
+		for _, webhook := range webhooks {
+			ids = append(ids, webhook.ID)
+		}
+		return ids
+	}
+	webhooks, err = store.ListFeedWebhooks(news.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []int{all.ID, newsOnly.ID, newsCategory.ID}, ids(webhooks))
+	webhooks, err = store.ListFeedWebhooks(blog.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []int{all.ID}, ids(webhooks))
+	webhooks, err = store.ListFeedWebhooks(other.ID)
+	require.NoError(t, err)
+	assert.Len(t, webhooks, 1)
+	assert.NotContains(t, ids(webhooks), all.ID)
+
+	now := time.Now().Truncate(time.Second)
+	due := &WebhookDelivery{WebhookID: all.ID, Event: "items.created", Payload: `{"event":"items.created"}`, NextAttemptAt: now.Add(-time.Minute)}
+	require.NoError(t, store.CreateWebhookDelivery(due))
+	assert.Equal(t, DeliveryPending, due.Status)
+	later := &WebhookDelivery{WebhookID: all.ID, Event: "items.created", Payload: "{}", NextAttemptAt: now.Add(time.Hour)}
+	require.NoError(t, store.CreateWebhookDelivery(later))
+
+	deliveries, err := store.ListDueWebhookDeliveries(now, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, due.ID, deliveries[0].ID)
+	assert.Equal(t, due.Payload, deliveries[0].Payload)
+
+	due.Status = DeliveryDelivered
+	due.Attempts = 1
+	due.ResponseStatus = 204
+	due.NextAttemptAt = time.Time{}
+	due.LastAttemptAt = now
+	require.NoError(t, store.RecordWebhookAttempt(due))
+	deliveries, err = store.ListDueWebhookDeliveries(now, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	// The log is newest first
+	deliveries, err = store.ListWebhookDeliveries(all.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, later.ID, deliveries[0].ID)
+	assert.Equal(t, DeliveryDelivered, deliveries[1].Status)
+	assert.Equal(t, 1, deliveries[1].Attempts)
+	assert.Equal(t, 204, deliveries[1].ResponseStatus)
+	assert.True(t, now.Equal(deliveries[1].LastAttemptAt))
+	assert.True(t, deliveries[1].NextAttemptAt.IsZero())
+
+	// Pending deliveries are kept
+	deleted, err := store.DeleteOldWebhookDeliveries(now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	require.NoError(t, store.DeleteWebhook(newsOnly.ID))
+	assert.ErrorIs(t, store.DeleteWebhook(newsOnly.ID), sql.ErrNoRows)
+	assert.ErrorIs(t, store.RecordWebhookAttempt(&WebhookDelivery{ID: 12345}), sql.ErrNoRows)
+}
@@ -67,3 +67,20 @@ func GetUserFromContext(r *http.Request) *store.User {
 	}
 	return user
 }
+
+// GetSessionFromContext retrieves the session of the authenticated user from
+// the request context
+func GetSessionFromContext(r *http.Request) *store.Session {
+	session, ok := r.Context().Value(middleware.SessionContextKey).(*store.Session)
+	if !ok {
+		return nil
+	}
+	return session
+}
+
+// GetCSRFTokenFromContext retrieves the CSRF token pages have to send back
+// with forms and HTMX requests
+func GetCSRFTokenFromContext(r *http.Request) string {
+	token, _ := r.Context().Value(middleware.CSRFTokenContextKey).(string)
+	return token
+}
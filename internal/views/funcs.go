@@ -0,0 +1,204 @@
+package views
+
+import (
+	"fmt"
+	"html/template"
+	"maps"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Funcs returns the functions shared by all templates. Dates are formatted
+// for the default locale, Renderer replaces them for each page.
+func Funcs() template.FuncMap {
+	funcs := template.FuncMap{
+		"initial":     Initial,
+		"sanitize":    Sanitize,
+		"webLink":     WebLink,
+		"formatBytes": FormatBytes,
+	}
+	maps.Copy(funcs, Locale{}.funcs())
+	return funcs
+}
+
+// policy strips everything from feed content that could run script or
+// change the page outside the reading pane
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	p.RequireNoReferrerOnFullyQualifiedLinks(true)
+	return p
+}()
+
+// Sanitize makes untrusted feed HTML safe to render as is
+func Sanitize(s string) template.HTML {
+	return template.HTML(policy.Sanitize(s))
+}
+
+// WebLink returns link if it points to a web page, and "" otherwise, so a
+// feed can't smuggle a javascript: link past html/template into an attribute
+// it doesn't know holds a URL
+func WebLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return link
+}
+
+// DateFormat is a way of showing dates users can choose
+type DateFormat struct {
+	Name  string
+	Label string
+	// Layout is empty for relative dates
+	Layout string
+}
+
+// DateFormats are the formats users can choose from, the first is the default
+var DateFormats = []DateFormat{
+	{Name: "relative", Label: "Relative (3 hours ago)"},
+	{Name: "long", Label: "December 24, 2025", Layout: "January 2, 2006"},
+	{Name: "iso", Label: "2025-12-24", Layout: "2006-01-02"},
+	{Name: "european", Label: "24.12.2025", Layout: "02.01.2006"},
+	{Name: "us", Label: "12/24/2025", Layout: "01/02/2006"},
+}
+
+// longLayout is used where a relative date would not make sense
+const longLayout = "January 2, 2006"
+
+// Locale decides how dates are shown to a user. The zero value shows
+// relative dates in UTC.
+type Locale struct {
+	Location   *time.Location
+	DateFormat string
+}
+
+func (l Locale) funcs() template.FuncMap {
+	return template.FuncMap{
+		"formatDate":   l.FormatDate,
+		"relativeTime": l.RelativeTime,
+	}
+}
+
+func (l Locale) format() DateFormat {
+	for _, format := range DateFormats {
+		if format.Name == l.DateFormat {
+			return format
+		}
+	}
+	return DateFormats[0]
+}
+
+func (l Locale) layout() string {
+	if layout := l.format().Layout; layout != "" {
+		return layout
+	}
+	return longLayout
+}
+
+func (l Locale) in(t time.Time) time.Time {
+	if l.Location == nil {
+		return t.UTC()
+	}
+	return t.In(l.Location)
+}
+
+// Layouts dates are stored in. Feed items use RFC 3339, SQLite defaults
+// produce the second one.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+}
+
+// parseTime accepts a time.Time or one of the stored string formats
+func parseTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, !t.IsZero()
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, false
+		}
+		return *t, !t.IsZero()
+	case string:
+		for _, layout := range dateLayouts {
+			if parsed, err := time.Parse(layout, t); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// FormatDate renders v in the chosen date format, e.g. "December 24, 2025"
+func (l Locale) FormatDate(v any) string {
+	t, ok := parseTime(v)
+	if !ok {
+		return ""
+	}
+	return l.in(t).Format(l.layout())
+}
+
+// RelativeTime renders v relative to now, e.g. "3 hours ago", unless another
+// date format was chosen. Anything older than a week is shown as a date.
+func (l Locale) RelativeTime(v any) string {
+	t, ok := parseTime(v)
+	if !ok {
+		return ""
+	}
+	if l.format().Layout != "" {
+		return l.FormatDate(t)
+	}
+
+	d := time.Since(t)
+	switch {
+	case d < 0:
+		return l.FormatDate(t)
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute") + " ago"
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour") + " ago"
+	case d < 7*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day") + " ago"
+	default:
+		return l.FormatDate(t)
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// Initial returns the upper-cased first letter of s, used as feed icon
+func Initial(s string) string {
+	r, _ := utf8.DecodeRuneInString(strings.TrimSpace(s))
+	if r == utf8.RuneError {
+		return "?"
+	}
+	return string(unicode.ToUpper(r))
+}
+
+// FormatBytes returns n in the largest unit it is at least one of, like
+// "1.5 MB"
+func FormatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "kMGT"[exp])
+}
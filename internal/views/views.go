@@ -0,0 +1,188 @@
+// Package views parses and renders the page templates
+package views
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"strings"
+)
+
+// Page holds the fields every page layout uses. Page data structs embed it.
+type Page struct {
+	Title string
+	Flash *Flash
+
+	// Username is shown in the header of signed in pages, admins get a
+	// link to the admin page there
+	Username string
+	IsAdmin  bool
+	// Theme is "light" or "dark" to override the system preference
+	Theme  string
+	Locale Locale
+	// CSRFToken is sent back with every form and HTMX request
+	CSRFToken string
+}
+
+func (p Page) locale() Locale {
+	return p.Locale
+}
+
+// localized is implemented by data embedding Page
+type localized interface {
+	locale() Locale
+}
+
+type Flash struct {
+	// Kind is either "error" or "success"
+	Kind    string
+	Message string
+}
+
+func ErrorFlash(message string) *Flash {
+	if message == "" {
+		return nil
+	}
+	return &Flash{Kind: "error", Message: message}
+}
+
+func SuccessFlash(message string) *Flash {
+	if message == "" {
+		return nil
+	}
+	return &Flash{Kind: "success", Message: message}
+}
+
+// Renderer renders pages, which are executed through the "base" layout, and
+// partials, which are rendered on their own for HTMX swaps.
+//
+// Templates are laid out as:
+//
+//	layouts/*.html   defines "base"
+//	partials/*.html  one named template each, e.g. "sidebar"
+//	pages/*.html     defines "body" (and optionally blocks of the layout)
+type Renderer struct {
+	fsys  fs.FS
+	funcs template.FuncMap
+	dev   bool
+	set   *templateSet
+}
+
+type templateSet struct {
+	partials *template.Template
+	pages    map[string]*template.Template
+}
+
+// New parses all templates in fsys. In dev mode they are parsed again on
+// every render, so edits show up without a restart.
+func New(fsys fs.FS, funcs template.FuncMap, dev bool) (*Renderer, error) {
+	r := &Renderer{
+		fsys:  fsys,
+		funcs: Funcs(),
+		dev:   dev,
+	}
+	maps.Copy(r.funcs, funcs)
+
+	set, err := r.parse()
+	if err != nil {
+		return nil, err
+	}
+	r.set = set
+
+	return r, nil
+}
+
+func (r *Renderer) parse() (*templateSet, error) {
+	partials, err := template.New("").Funcs(r.funcs).ParseFS(r.fsys, "layouts/*.html", "partials/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("views: parse %w", err)
+	}
+
+	names, err := fs.Glob(r.fsys, "pages/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	set := &templateSet{
+		partials: partials,
+		pages:    make(map[string]*template.Template, len(names)),
+	}
+	for _, name := range names {
+		base, err := partials.Clone()
+		if err != nil {
+			return nil, err
+		}
+		page, err := base.ParseFS(r.fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("views: parse %w", err)
+		}
+		set.pages[strings.TrimSuffix(path.Base(name), ".html")] = page
+	}
+
+	return set, nil
+}
+
+func (r *Renderer) templates() (*templateSet, error) {
+	if r.dev {
+		return r.parse()
+	}
+	return r.set, nil
+}
+
+// Render executes the named page, e.g. "dashboard", through the base layout.
+// Nothing is written to w if rendering fails.
+func (r *Renderer) Render(w io.Writer, page string, data any) error {
+	set, err := r.templates()
+	if err != nil {
+		return err
+	}
+
+	t, ok := set.pages[page]
+	if !ok {
+		return fmt.Errorf("views: unknown page %q", page)
+	}
+	return execute(w, t, "base", data)
+}
+
+// RenderPartial executes a single partial, e.g. "item_row"
+func (r *Renderer) RenderPartial(w io.Writer, name string, data any) error {
+	set, err := r.templates()
+	if err != nil {
+		return err
+	}
+	return execute(w, set.partials, name, data)
+}
+
+// execute runs a clone of t, so dates can be formatted for the locale of the
+// data. The parsed templates themselves are never executed, html/template
+// can't clone them afterwards.
+func execute(w io.Writer, t *template.Template, name string, data any) error {
+	t, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	if l, ok := data.(localized); ok {
+		t.Funcs(l.locale().funcs())
+	}
+
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		return fmt.Errorf("views: execute %s %w", name, err)
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Check reports whether the templates can be parsed. Outside of dev mode
+// they were parsed at startup, so this only matters when reloading.
+func (r *Renderer) Check() error {
+	if !r.dev {
+		return nil
+	}
+	_, err := r.parse()
+	return err
+}
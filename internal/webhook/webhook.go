@@ -0,0 +1,359 @@
+// Package webhook calls the webhooks of users with the new items of their
+// feeds.
+//
+// Deliveries are POST requests with a JSON payload. They are signed with the
+// secret of the webhook: the X-RSS-Signature header is "sha256=" followed by
+// the hex HMAC-SHA256 of the X-RSS-Timestamp header, a dot and the body.
+// Deliveries that fail are tried again with growing delays.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/store"
+)
+
+// Events a webhook gets
+const (
+	EventItemsCreated = "items.created"
+	EventPing         = "ping"
+)
+
+const (
+	// checkInterval is how often deliveries due for a retry are looked for
+	checkInterval = 30 * time.Second
+	// batchSize is how many deliveries are attempted per check
+	batchSize      = 50
+	requestTimeout = 10 * time.Second
+	// maxErrorLength keeps the delivery log short
+	maxErrorLength = 200
+)
+
+// retryDelays are how long to wait after each failed attempt. A delivery
+// fails for good after one more attempt than there are delays.
+var retryDelays = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+	6 * time.Hour,
+}
+
+// errPrivateTarget is why deliveries to the machine itself or its networks
+// fail, unless they are allowed
+var errPrivateTarget = errors.New("webhook URL points to a private address")
+
+// Dispatcher queues deliveries for new items and sends them
+type Dispatcher struct {
+	webhookStore store.WebhookStore
+	client       *http.Client
+	logger       *slog.Logger
+	// wake makes Run send new deliveries right away
+	wake chan struct{}
+}
+
+// NewDispatcher returns a dispatcher that refuses to deliver to loopback,
+// link-local and private addresses unless allowPrivate is set. Otherwise
+// users could probe the network the server runs in.
+func NewDispatcher(webhookStore store.WebhookStore, allowPrivate bool, logger *slog.Logger) *Dispatcher {
+	client := &http.Client{Timeout: requestTimeout}
+	if !allowPrivate {
+		// The address is checked once resolved, so a name can't point
+		// somewhere else than it did when checked. Deliveries don't go
+		// through a proxy, the proxy would connect instead.
+		dialer := &net.Dialer{Timeout: requestTimeout, Control: publicOnly}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = dialer.DialContext
+		client.Transport = transport
+	}
+
+	return &Dispatcher{
+		webhookStore: webhookStore,
+		client:       client,
+		logger:       logger,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// publicOnly refuses connections to addresses that aren't public
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() ||
+		addr.IsMulticast() || addr.IsInterfaceLocalMulticast() {
+		return errPrivateTarget
+	}
+	return nil
+}
+
+// Payload is the body of a delivery
+type Payload struct {
+	Event     string    `json:"event"`
+	WebhookID int       `json:"webhookId"`
+	CreatedAt time.Time `json:"createdAt"`
+	Feed      *Feed     `json:"feed,omitempty"`
+	Items     []*Item   `json:"items,omitempty"`
+}
+
+type Feed struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Link  string `json:"link"`
+}
+
+type Item struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Link        string `json:"link"`
+	Description string `json:"description"`
+	PublishedAt string `json:"publishedAt"`
+}
+
+// Matches reports whether the webhook gets the item of feed
+func Matches(webhook *store.Webhook, feed *store.Feed, item *store.FeedItem) bool {
+	if webhook.FeedID != 0 && webhook.FeedID != feed.ID {
+		return false
+	}
+	if webhook.Category != "" && webhook.Category != feed.Category {
+		return false
+	}
+	if webhook.Keyword == "" {
+		return true
+	}
+	keyword := strings.ToLower(webhook.Keyword)
+	return strings.Contains(strings.ToLower(item.Title), keyword) ||
+		strings.Contains(strings.ToLower(item.Description), keyword)
+}
+
+// Enqueue queues a delivery of the new items to every webhook of the feed
+// they match. It is meant for Fetcher.OnNewItems.
+func (d *Dispatcher) Enqueue(ctx context.Context, feed *store.Feed, items []*store.FeedItem) {
+	webhooks, err := d.webhookStore.ListFeedWebhooks(feed.ID)
+	if err != nil {
+		d.logger.ErrorContext(ctx, "webhook: ListFeedWebhooks", "error", err)
+		return
+	}
+
+	queued := false
+	for _, webhook := range webhooks {
+		payload := &Payload{
+			Event:     EventItemsCreated,
+			WebhookID: webhook.ID,
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+			Feed:      &Feed{ID: feed.ID, Title: feed.Title, Link: feed.Link},
+		}
+		for _, item := range items {
+			if Matches(webhook, feed, item) {
+				payload.Items = append(payload.Items, &Item{
+					ID:          item.ID,
+					Title:       item.Title,
+					Link:        item.Link,
+					Description: item.Description,
+					PublishedAt: item.PublishedAt,
+				})
+			}
+		}
+		if len(payload.Items) == 0 {
+			continue
+		}
+
+		if _, err := d.queue(payload, time.Now()); err != nil {
+			d.logger.ErrorContext(ctx, "webhook: queueing delivery", "webhook_id", webhook.ID, "error", err)
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// queue stores a delivery of payload, to be attempted at nextAttemptAt
+func (d *Dispatcher) queue(payload *Payload, nextAttemptAt time.Time) (*store.WebhookDelivery, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	delivery := &store.WebhookDelivery{
+		WebhookID:     payload.WebhookID,
+		Event:         payload.Event,
+		Payload:       string(body),
+		NextAttemptAt: nextAttemptAt,
+	}
+	if err := d.webhookStore.CreateWebhookDelivery(delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Run sends due deliveries until ctx is done, new ones right away and
+// retries every checkInterval
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		d.SendDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// SendDue attempts the deliveries due at now
+func (d *Dispatcher) SendDue(ctx context.Context, now time.Time) {
+	for ctx.Err() == nil {
+		deliveries, err := d.webhookStore.ListDueWebhookDeliveries(now, batchSize)
+		if err != nil {
+			d.logger.ErrorContext(ctx, "webhook: ListDueWebhookDeliveries", "error", err)
+			return
+		}
+
+		for _, delivery := range deliveries {
+			if ctx.Err() != nil {
+				return
+			}
+			webhook, err := d.webhookStore.GetWebhook(delivery.WebhookID)
+			if err != nil {
+				d.logger.ErrorContext(ctx, "webhook: GetWebhook", "error", err)
+				return
+			}
+			// Deleted meanwhile, its deliveries are gone too
+			if webhook == nil {
+				continue
+			}
+			if err := d.attempt(ctx, webhook, delivery, true); err != nil {
+				d.logger.ErrorContext(ctx, "webhook: recording attempt", "delivery_id", delivery.ID, "error", err)
+				return
+			}
+		}
+
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// SendTest sends a ping to the webhook right away and returns the logged
+// delivery. It isn't retried.
+func (d *Dispatcher) SendTest(ctx context.Context, webhook *store.Webhook) (*store.WebhookDelivery, error) {
+	payload := &Payload{
+		Event:     EventPing,
+		WebhookID: webhook.ID,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	delivery, err := d.queue(payload, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if err := d.attempt(ctx, webhook, delivery, false); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// attempt posts the delivery and records the outcome. Failed deliveries
+// are scheduled for another attempt if retry is set and attempts are left.
+func (d *Dispatcher) attempt(ctx context.Context, webhook *store.Webhook, delivery *store.WebhookDelivery, retry bool) error {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = now
+	delivery.Error = ""
+
+	status, err := d.post(ctx, webhook, delivery, now)
+	delivery.ResponseStatus = status
+	switch {
+	case err == nil:
+		delivery.Status = store.DeliveryDelivered
+		delivery.NextAttemptAt = time.Time{}
+	case retry && delivery.Attempts <= len(retryDelays):
+		delivery.Status = store.DeliveryPending
+		delivery.NextAttemptAt = now.Add(retryDelays[delivery.Attempts-1])
+	default:
+		delivery.Status = store.DeliveryFailed
+		delivery.NextAttemptAt = time.Time{}
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+		if len(delivery.Error) > maxErrorLength {
+			delivery.Error = delivery.Error[:maxErrorLength]
+		}
+		d.logger.WarnContext(ctx, "webhook: delivery failed", "webhook_id", webhook.ID, "delivery_id", delivery.ID, "attempts", delivery.Attempts, "error", err)
+	}
+
+	return d.webhookStore.RecordWebhookAttempt(delivery)
+}
+
+// post returns the response status and an error unless it is 2xx
+func (d *Dispatcher) post(ctx context.Context, webhook *store.Webhook, delivery *store.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "RSS/1.0")
+	req.Header.Set("X-RSS-Event", delivery.Event)
+	req.Header.Set("X-RSS-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-RSS-Timestamp", timestamp)
+	req.Header.Set("X-RSS-Signature", Sign(webhook.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	// Reading the body lets the connection be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the X-RSS-Signature header of a delivery
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the one of a delivery, for receivers
+// written in Go
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,192 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore keeps webhooks and deliveries in memory
+type fakeStore struct {
+	store.WebhookStore
+	webhooks   []*store.Webhook
+	deliveries []*store.WebhookDelivery
+}
+
+func (s *fakeStore) GetWebhook(id int) (*store.Webhook, error) {
+	for _, webhook := range s.webhooks {
+		if webhook.ID == id {
+			return webhook, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeStore) ListFeedWebhooks(feedID int) ([]*store.Webhook, error) {
+	return s.webhooks, nil
+}
+
+func (s *fakeStore) CreateWebhookDelivery(delivery *store.WebhookDelivery) error {
+	delivery.ID = int64(len(s.deliveries) + 1)
+	delivery.Status = store.DeliveryPending
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+func (s *fakeStore) ListDueWebhookDeliveries(now time.Time, limit int) ([]*store.WebhookDelivery, error) {
+	var due []*store.WebhookDelivery
+	for _, delivery := range s.deliveries {
+		if delivery.Status == store.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	return due, nil
+}
+
+func (s *fakeStore) RecordWebhookAttempt(delivery *store.WebhookDelivery) error {
+	return nil
+}
+
+// receiver records the requests it gets and answers with status
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+func TestMatches(t *testing.T) {
+	item := &store.FeedItem{Title: "Go 1.26 is released", Description: "With a new garbage collector"}
+
+	feed := &store.Feed{ID: 1, Category: "Tech"}
+
+	assert.True(t, Matches(&store.Webhook{}, feed, item))
+	assert.True(t, Matches(&store.Webhook{FeedID: 1}, feed, item))
+	assert.False(t, Matches(&store.Webhook{FeedID: 2}, feed, item))
+	assert.True(t, Matches(&store.Webhook{Category: "Tech"}, feed, item))
+	assert.False(t, Matches(&store.Webhook{Category: "News"}, feed, item))
+	assert.True(t, Matches(&store.Webhook{Keyword: "GO"}, feed, item))
+	assert.True(t, Matches(&store.Webhook{Keyword: "garbage"}, feed, item))
+	assert.False(t, Matches(&store.Webhook{Keyword: "rust"}, feed, item))
+}
+
+func TestDispatcher(t *testing.T) {
+	rc := &receiver{status: http.StatusInternalServerError}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	webhookStore := &fakeStore{webhooks: []*store.Webhook{
+		{ID: 1, URL: server.URL, Secret: "0123456789abcdef"},
+		{ID: 2, URL: server.URL, Secret: "0123456789abcdef", Keyword: "nothing matches"},
+	}}
+	dispatcher := NewDispatcher(webhookStore, true, slog.New(slog.DiscardHandler))
+
+	feed := &store.Feed{ID: 7, Title: "News", Link: "https://example.com/news.xml"}
+	dispatcher.Enqueue(context.Background(), feed, []*store.FeedItem{
+		{ID: 42, FeedID: 7, Title: "First", Link: "https://example.com/1", PublishedAt: "2025-03-10T05:00:00Z"},
+	})
+	require.Len(t, webhookStore.deliveries, 1)
+	delivery := webhookStore.deliveries[0]
+
+	// A failed delivery is tried again later
+	dispatcher.SendDue(context.Background(), time.Now())
+	require.Len(t, rc.requests, 1)
+	assert.Equal(t, store.DeliveryPending, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, delivery.ResponseStatus)
+	assert.NotEmpty(t, delivery.Error)
+	assert.WithinDuration(t, time.Now().Add(retryDelays[0]), delivery.NextAttemptAt, 5*time.Second)
+
+	dispatcher.SendDue(context.Background(), time.Now())
+	assert.Len(t, rc.requests, 1)
+
+	rc.status = http.StatusNoContent
+	dispatcher.SendDue(context.Background(), delivery.NextAttemptAt)
+	require.Len(t, rc.requests, 2)
+	assert.Equal(t, store.DeliveryDelivered, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Empty(t, delivery.Error)
+	assert.True(t, delivery.NextAttemptAt.IsZero())
+
+	req, body := rc.requests[1], rc.bodies[1]
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, EventItemsCreated, req.Header.Get("X-RSS-Event"))
+	assert.Equal(t, "1", req.Header.Get("X-RSS-Delivery"))
+	assert.True(t, Verify("0123456789abcdef", req.Header.Get("X-RSS-Timestamp"), body, req.Header.Get("X-RSS-Signature")))
+	assert.False(t, Verify("wrong secret", req.Header.Get("X-RSS-Timestamp"), body, req.Header.Get("X-RSS-Signature")))
+
+	var payload Payload
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, EventItemsCreated, payload.Event)
+	assert.Equal(t, 1, payload.WebhookID)
+	assert.Equal(t, &Feed{ID: 7, Title: "News", Link: "https://example.com/news.xml"}, payload.Feed)
+	require.Len(t, payload.Items, 1)
+	assert.Equal(t, 42, payload.Items[0].ID)
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	rc := &receiver{status: http.StatusBadGateway}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	webhookStore := &fakeStore{webhooks: []*store.Webhook{{ID: 1, URL: server.URL, Secret: "0123456789abcdef"}}}
+	dispatcher := NewDispatcher(webhookStore, true, slog.New(slog.DiscardHandler))
+	dispatcher.Enqueue(context.Background(), &store.Feed{ID: 7}, []*store.FeedItem{{ID: 42, FeedID: 7}})
+	delivery := webhookStore.deliveries[0]
+
+	for range len(retryDelays) + 1 {
+		dispatcher.SendDue(context.Background(), delivery.NextAttemptAt)
+	}
+	assert.Len(t, rc.requests, len(retryDelays)+1)
+	assert.Equal(t, store.DeliveryFailed, delivery.Status)
+	assert.True(t, delivery.NextAttemptAt.IsZero())
+}
+
+func TestSendTest(t *testing.T) {
+	rc := &receiver{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	webhookStore := &fakeStore{}
+	dispatcher := NewDispatcher(webhookStore, true, slog.New(slog.DiscardHandler))
+
+	// A failed test isn't retried
+	delivery, err := dispatcher.SendTest(context.Background(), &store.Webhook{ID: 1, URL: server.URL, Secret: "0123456789abcdef"})
+	require.NoError(t, err)
+	assert.Equal(t, store.DeliveryFailed, delivery.Status)
+	assert.Equal(t, http.StatusServiceUnavailable, delivery.ResponseStatus)
+	require.Len(t, rc.requests, 1)
+	assert.Equal(t, EventPing, rc.requests[0].Header.Get("X-RSS-Event"))
+	var payload Payload
+	require.NoError(t, json.Unmarshal(rc.bodies[0], &payload))
+	assert.Equal(t, EventPing, payload.Event)
+	assert.Equal(t, 1, payload.WebhookID)
+	assert.Nil(t, payload.Feed)
+
+	// The stand-in listens on loopback, which is refused by default
+	dispatcher = NewDispatcher(webhookStore, false, slog.New(slog.DiscardHandler))
+	delivery, err = dispatcher.SendTest(context.Background(), &store.Webhook{ID: 1, URL: server.URL, Secret: "0123456789abcdef"})
+	require.NoError(t, err)
+	assert.Equal(t, store.DeliveryFailed, delivery.Status)
+	assert.Contains(t, delivery.Error, errPrivateTarget.Error())
+	assert.Len(t, rc.requests, 1)
+}
@@ -1,39 +1,148 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"net/http"
-	"time"
+	"os"
+	"slices"
+	"strings"
+	// Timezones of the user settings shouldn't depend on the host
+	_ "time/tzdata"
 
 	"github.com/floriangaechter/rss/internal/app"
-	"github.com/floriangaechter/rss/internal/routes"
+	"github.com/floriangaechter/rss/internal/config"
+	"github.com/floriangaechter/rss/internal/store"
+	"golang.org/x/term"
 )
 
+const usage = `Usage: rss <command> [arguments]
+
+Commands:
+  serve                                 Run the web server (default)
+  migrate up|down|status                Manage database migrations
+  user create|list|delete|reset-password|reset-2fa|promote|demote
+  feed add|list|refresh <id|all>
+  opml import|export --user <username>
+  db vacuum                             Reclaim unused database space
+
+Run "rss <command> <subcommand> -h" to list the flags of a command.
+`
+
+var errUsage = errors.New("invalid usage")
+
 func main() {
-	var port int
-	flag.IntVar(&port, "port", 8080, "Server port")
-	flag.Parse()
+	args := os.Args[1:]
 
-	app, err := app.NewApplication()
-	if err != nil {
-		panic(err)
+	// Plain "rss -port 8080" keeps starting the server
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = runServe(args)
+	case "migrate":
+		err = runMigrate(args)
+	case "user":
+		err = runUser(args)
+	case "feed":
+		err = runFeed(args)
+	case "opml":
+		err = runOPML(args)
+	case "db":
+		err = runDB(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		err = fmt.Errorf("%w: unknown command %q", errUsage, command)
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns the flag set of "rss <name>" with the shared config
+// flags already registered
+func newFlagSet(name string, cfg *config.Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("rss "+name, flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	return fs
+}
+
+// subcommand splits "<sub> [flags] [args]" off args and checks that sub is
+// one of valid
+func subcommand(command string, args []string, valid ...string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", nil, fmt.Errorf("%w: missing %s command", errUsage, command)
+	}
+	if !slices.Contains(valid, args[0]) {
+		return "", nil, fmt.Errorf("%w: unknown %s command %q", errUsage, command, args[0])
 	}
-	defer func() { _ = app.DB.Close() }()
+	return args[0], args[1:], nil
+}
 
-	r := routes.SetupRoutes(app)
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", port),
-		Handler:      r,
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+// openApp builds the application for a one-off command. Logs go to stderr
+// so they don't mix with the command output. The returned func closes it.
+func openApp(cfg config.Config) (*app.Application, func(), error) {
+	a, err := app.NewApplication(cfg, os.Stderr)
+	if err != nil {
+		return nil, nil, err
 	}
+	return a, func() { _ = a.Shutdown(context.Background()) }, nil
+}
 
-	app.Logger.Printf("server: running on port %d\n", port)
+func lookupUser(a *app.Application, username string) (*store.User, error) {
+	if username == "" {
+		return nil, fmt.Errorf("%w: -user is required", errUsage)
+	}
 
-	err = server.ListenAndServe()
+	user, err := a.UserStore.GetUserByUsername(username)
 	if err != nil {
-		app.Logger.Fatal(err)
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %q not found", username)
+	}
+	return user, nil
+}
+
+// readPassword prompts for a password on stdin when none was passed as flag
+func readPassword(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	// Typed passwords aren't echoed, piped ones are read as a line
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		typed, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		password = string(typed)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if strings.TrimSpace(password) == "" {
+		return "", errors.New("password is required")
 	}
+	return password, nil
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+
+	"github.com/floriangaechter/rss/internal/config"
+	"github.com/floriangaechter/rss/internal/logging"
+	"github.com/floriangaechter/rss/internal/store"
+	"github.com/floriangaechter/rss/migrations"
+)
+
+func runMigrate(args []string) error {
+	sub, args, err := subcommand("migrate", args, "up", "down", "status")
+	if err != nil {
+		return err
+	}
+
+	var cfg config.Config
+	fs := newFlagSet("migrate "+sub, &cfg)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Not using openApp, it would apply pending migrations on open
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	db, err := store.Open(logger)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	switch sub {
+	case "up":
+		return store.MigrateFS(db, migrations.FS, ".")
+	case "down":
+		return store.MigrateDownFS(db, migrations.FS, ".")
+	case "status":
+		return store.MigrationStatusFS(db, migrations.FS, ".")
+	}
+
+	return nil
+}
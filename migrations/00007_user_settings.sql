@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_settings (
+  user_id INTEGER PRIMARY KEY,
+  theme TEXT NOT NULL DEFAULT 'system',
+  sort_order TEXT NOT NULL DEFAULT 'newest',
+  unread_only INTEGER NOT NULL DEFAULT 0,
+  items_per_page INTEGER NOT NULL DEFAULT 50,
+  mark_read_on_scroll INTEGER NOT NULL DEFAULT 0,
+  timezone TEXT NOT NULL DEFAULT 'UTC',
+  date_format TEXT NOT NULL DEFAULT 'relative',
+  modified_at TEXT NOT NULL DEFAULT (datetime('now')),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_settings;
+-- +goose StatementEnd
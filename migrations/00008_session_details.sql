@@ -0,0 +1,47 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Sessions get an id so they can be listed and revoked without exposing
+-- their tokens, SQLite can't add one to an existing table
+CREATE TABLE sessions_new (
+  id INTEGER PRIMARY KEY,
+  token TEXT UNIQUE NOT NULL,
+  user_id INTEGER NOT NULL,
+  expires_at TEXT NOT NULL,
+  user_agent TEXT NOT NULL DEFAULT '',
+  ip TEXT NOT NULL DEFAULT '',
+  last_seen_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  created_at TEXT NOT NULL DEFAULT (datetime('now')),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO sessions_new (token, user_id, expires_at, last_seen_at, created_at)
+SELECT token, user_id, expires_at, strftime('%Y-%m-%dT%H:%M:%SZ', created_at), created_at
+FROM sessions;
+
+DROP TABLE sessions;
+ALTER TABLE sessions_new RENAME TO sessions;
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE sessions_old (
+  token TEXT PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  expires_at TEXT NOT NULL,
+  created_at TEXT NOT NULL DEFAULT (datetime('now')),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO sessions_old (token, user_id, expires_at, created_at)
+SELECT token, user_id, expires_at, created_at
+FROM sessions;
+
+DROP TABLE sessions;
+ALTER TABLE sessions_old RENAME TO sessions;
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
+-- +goose StatementEnd
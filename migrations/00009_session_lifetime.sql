@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE sessions ADD COLUMN remember INTEGER NOT NULL DEFAULT 0;
+-- Seconds of inactivity after which the session expires
+ALTER TABLE sessions ADD COLUMN lifetime INTEGER NOT NULL DEFAULT 86400;
+
+-- Expiry times were written with the local offset but compared as text
+-- against UTC, normalize them so the comparison holds
+UPDATE sessions SET expires_at = strftime('%Y-%m-%dT%H:%M:%SZ', expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE sessions DROP COLUMN lifetime;
+ALTER TABLE sessions DROP COLUMN remember;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Accounts of an OpenID Connect provider that sign in as a user
+CREATE TABLE IF NOT EXISTS user_identities (
+  id INTEGER PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  issuer TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  -- The username the provider reported, to tell identities apart
+  username TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL DEFAULT (datetime('now')),
+  UNIQUE (issuer, subject),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_user_identities_user_id;
+DROP TABLE IF EXISTS user_identities;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Recent failed logins per client address (scope "ip") and per username
+-- (scope "username"), whether the username exists or not
+CREATE TABLE IF NOT EXISTS login_failures (
+  scope TEXT NOT NULL,
+  key TEXT NOT NULL,
+  failures INTEGER NOT NULL DEFAULT 0,
+  last_failed_at TEXT NOT NULL,
+  locked_until TEXT,
+  PRIMARY KEY (scope, key)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+  id INTEGER PRIMARY KEY,
+  event TEXT NOT NULL,
+  -- Kept when the user is deleted, username still tells who it was
+  user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  username TEXT NOT NULL DEFAULT '',
+  ip TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  detail TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_audit_log_created_at;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS login_failures;
+-- +goose StatementEnd
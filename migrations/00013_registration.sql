@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN is_admin INTEGER NOT NULL DEFAULT 0;
+
+-- Someone has to be able to issue invites, the first user set up the
+-- instance
+UPDATE users SET is_admin = 1 WHERE id = (SELECT MIN(id) FROM users);
+
+-- Single-use codes admins hand out to let someone register. Only their
+-- hashes are stored.
+CREATE TABLE IF NOT EXISTS invites (
+  id INTEGER PRIMARY KEY,
+  code_hash TEXT UNIQUE NOT NULL,
+  created_by INTEGER,
+  used_by INTEGER,
+  expires_at TEXT NOT NULL,
+  used_at TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+  FOREIGN KEY (used_by) REFERENCES users(id) ON DELETE SET NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS invites;
+ALTER TABLE users DROP COLUMN is_admin;
+-- +goose StatementEnd
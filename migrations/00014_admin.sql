@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Disabled users can't sign in, their data is kept
+ALTER TABLE users ADD COLUMN disabled_at TEXT;
+
+-- The outcome of the last fetch, fetch_errors counts the failures since the
+-- last success
+ALTER TABLE feeds ADD COLUMN last_fetched_at TEXT;
+ALTER TABLE feeds ADD COLUMN fetch_errors INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE feeds ADD COLUMN last_fetch_error TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE feeds DROP COLUMN last_fetch_error;
+ALTER TABLE feeds DROP COLUMN fetch_errors;
+ALTER TABLE feeds DROP COLUMN last_fetched_at;
+ALTER TABLE users DROP COLUMN disabled_at;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Where password reset links are sent to, empty if the user has none. It is
+-- stored lower case, so the index catches duplicates.
+ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX idx_users_email ON users(email) WHERE email != '';
+
+-- Single-use tokens of the links sent to reset a password. Only their hashes
+-- are stored.
+CREATE TABLE IF NOT EXISTS password_resets (
+  id INTEGER PRIMARY KEY,
+  token_hash TEXT UNIQUE NOT NULL,
+  user_id INTEGER NOT NULL,
+  expires_at TEXT NOT NULL,
+  used_at TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_password_resets_user_id ON password_resets(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_password_resets_user_id;
+DROP TABLE IF EXISTS password_resets;
+DROP INDEX IF EXISTS idx_users_email;
+ALTER TABLE users DROP COLUMN email;
+-- +goose StatementEnd
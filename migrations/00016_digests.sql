@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Email summaries of new unread items. last_item_id is the newest item the
+-- last digest looked at, later ones are new to the next digest. Digests of
+-- all feeds are marked as such, so a digest whose feeds were all deleted
+-- lists nothing instead of everything.
+CREATE TABLE IF NOT EXISTS digests (
+  user_id INTEGER PRIMARY KEY,
+  schedule TEXT NOT NULL DEFAULT 'off',
+  hour INTEGER NOT NULL DEFAULT 7,
+  weekday INTEGER NOT NULL DEFAULT 1,
+  max_items INTEGER NOT NULL DEFAULT 20,
+  all_feeds INTEGER NOT NULL DEFAULT 0,
+  last_item_id INTEGER NOT NULL DEFAULT 0,
+  next_at TEXT,
+  last_sent_at TEXT,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- The feeds a digest is limited to unless it is of all feeds
+CREATE TABLE IF NOT EXISTS digest_feeds (
+  user_id INTEGER NOT NULL,
+  feed_id INTEGER NOT NULL,
+  PRIMARY KEY (user_id, feed_id),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_digests_next_at ON digests(next_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_digests_next_at;
+DROP TABLE IF EXISTS digest_feeds;
+DROP TABLE IF EXISTS digests;
+-- +goose StatementEnd
@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Where alerts are sent. url, token and room are used as the kind needs
+-- them, e.g. room only by Matrix.
+CREATE TABLE IF NOT EXISTS notifiers (
+  id INTEGER PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  url TEXT NOT NULL,
+  token TEXT NOT NULL DEFAULT '',
+  room TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_notifiers_user_id ON notifiers(user_id);
+
+-- Rules send new items matching pattern in field to a notifier. Rules on
+-- all feeds are marked as such, so a rule whose feeds were all deleted
+-- matches nothing instead of everything.
+CREATE TABLE IF NOT EXISTS alert_rules (
+  id INTEGER PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  pattern TEXT NOT NULL,
+  is_regex INTEGER NOT NULL DEFAULT 0,
+  field TEXT NOT NULL DEFAULT 'any',
+  notifier_id INTEGER NOT NULL,
+  all_feeds INTEGER NOT NULL DEFAULT 0,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (notifier_id) REFERENCES notifiers(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_alert_rules_user_id ON alert_rules(user_id);
+
+-- The feeds a rule is limited to unless it is on all feeds
+CREATE TABLE IF NOT EXISTS alert_rule_feeds (
+  rule_id INTEGER NOT NULL,
+  feed_id INTEGER NOT NULL,
+  PRIMARY KEY (rule_id, feed_id),
+  FOREIGN KEY (rule_id) REFERENCES alert_rules(id) ON DELETE CASCADE,
+  FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS alert_rule_feeds;
+DROP INDEX IF EXISTS idx_alert_rules_user_id;
+DROP TABLE IF EXISTS alert_rules;
+DROP INDEX IF EXISTS idx_notifiers_user_id;
+DROP TABLE IF EXISTS notifiers;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Feeds keep the category they were filed under in an OPML file, webhooks
+-- can be limited to the feeds of one category
+ALTER TABLE feeds ADD COLUMN category TEXT NOT NULL DEFAULT '';
+ALTER TABLE webhooks ADD COLUMN category TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE webhooks DROP COLUMN category;
+ALTER TABLE feeds DROP COLUMN category;
+-- +goose StatementEnd
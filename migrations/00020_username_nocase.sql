@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Usernames are unique regardless of case, so Alice can't sign up next to
+-- alice. This fails if such users exist already, one of them has to be
+-- renamed first.
+CREATE UNIQUE INDEX idx_users_username_nocase ON users(username COLLATE NOCASE);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_users_username_nocase;
+-- +goose StatementEnd
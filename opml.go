@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/floriangaechter/rss/internal/config"
+	"github.com/floriangaechter/rss/internal/opml"
+)
+
+func runOPML(args []string) error {
+	sub, args, err := subcommand("opml", args, "import", "export")
+	if err != nil {
+		return err
+	}
+
+	var cfg config.Config
+	fs := newFlagSet("opml "+sub, &cfg)
+	var username, output string
+	fs.StringVar(&username, "user", "", "User to import the feeds for or export the feeds of")
+	if sub == "export" {
+		fs.StringVar(&output, "o", "-", "File to write to, - for stdout")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, closeApp, err := openApp(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	user, err := lookupUser(a, username)
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "import":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("%w: rss opml import -user <username> <file|->", errUsage)
+		}
+
+		var r io.Reader = os.Stdin
+		if fs.Arg(0) != "-" {
+			f, err := os.Open(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			defer func() { _ = f.Close() }()
+			r = f
+		}
+
+		subscriptions, err := opml.Parse(r)
+		if err != nil {
+			return err
+		}
+
+		existing, err := a.FeedStore.GetFeedsByUserID(int64(user.ID))
+		if err != nil {
+			return err
+		}
+		subscribed := make(map[string]bool, len(existing))
+		for _, feed := range existing {
+			subscribed[feed.Link] = true
+		}
+
+		// The titles and categories from the OPML file are kept, items are
+		// pulled in by the scheduler or "rss feed refresh"
+		var added int
+		for _, s := range subscriptions {
+			if subscribed[s.XMLURL] {
+				continue
+			}
+			title := s.Title
+			if title == "" {
+				title = s.XMLURL
+			}
+			if _, err := addFeed(context.Background(), a, user, s.XMLURL, title, s.Category); err != nil {
+				return err
+			}
+			subscribed[s.XMLURL] = true
+			added++
+		}
+
+		fmt.Fprintf(os.Stderr, "imported %d of %d feeds\n", added, len(subscriptions))
+		return nil
+
+	case "export":
+		feeds, err := a.FeedStore.GetFeedsByUserID(int64(user.ID))
+		if err != nil {
+			return err
+		}
+
+		subscriptions := make([]opml.Subscription, 0, len(feeds))
+		for _, feed := range feeds {
+			subscriptions = append(subscriptions, opml.Subscription{
+				Title:    feed.Title,
+				XMLURL:   feed.Link,
+				Category: feed.Category,
+			})
+		}
+
+		var w io.Writer = os.Stdout
+		if output != "-" {
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = f.Close() }()
+			w = f
+		}
+
+		return opml.Write(w, user.Username+" subscriptions", subscriptions)
+	}
+
+	return nil
+}
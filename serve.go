@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/floriangaechter/rss/internal/app"
+	"github.com/floriangaechter/rss/internal/config"
+	"github.com/floriangaechter/rss/internal/routes"
+)
+
+func runServe(args []string) error {
+	var cfg config.Config
+	fs := newFlagSet("serve", &cfg)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	app, err := app.NewApplication(cfg, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	r := routes.SetupRoutes(app)
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Port),
+		Handler:      r,
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	// Event streams never go idle, they have to end for Shutdown to finish
+	server.RegisterOnShutdown(app.Events.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app.Start()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		app.Logger.Info("server: running", "port", cfg.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	var runErr error
+	select {
+	case err = <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			runErr = fmt.Errorf("server: %w", err)
+		}
+	case <-ctx.Done():
+		app.Logger.Info("server: shutting down")
+	}
+	// A second signal kills the process right away
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		app.Logger.Error("server shutdown", "error", err)
+	}
+	if err := app.Shutdown(shutdownCtx); err != nil {
+		return errors.Join(runErr, fmt.Errorf("app shutdown: %w", err))
+	}
+
+	app.Logger.Info("server: stopped")
+	return runErr
+}
@@ -0,0 +1,7 @@
+// Package static
+package static
+
+import "embed"
+
+//go:embed *.css *.js *.svg
+var FS embed.FS
@@ -0,0 +1,164 @@
+// Keyboard shortcuts for the dashboard. The list is in the help dialog of
+// templates/pages/dashboard.html, press ? to show it.
+(function () {
+  "use strict";
+
+  // Classes marking the selected row, the same as the current sidebar link
+  const selectedClasses = ["bg-gray-100", "dark:bg-white/5"];
+
+  function article() {
+    return document.getElementById("item-detail");
+  }
+
+  function rows() {
+    return Array.from(document.querySelectorAll("#items li[data-item-id]"));
+  }
+
+  function selectedRow() {
+    return document.querySelector("#items li[aria-current]");
+  }
+
+  // filters are the values of the sidebar form, including the search input
+  function filters() {
+    const form = document.getElementById("filters");
+    return form ? Object.fromEntries(new FormData(form)) : {};
+  }
+
+  // isWebLink keeps links of feeds to pages, a javascript: link would run in
+  // the dashboard
+  function isWebLink(link) {
+    try {
+      const protocol = new URL(link).protocol;
+      return protocol === "http:" || protocol === "https:";
+    } catch {
+      return false;
+    }
+  }
+
+  function openItem(id) {
+    if (!id || id === "0") {
+      return;
+    }
+    htmx.ajax("GET", "/dashboard/items/" + id, {
+      target: "#reading-pane",
+      values: filters(),
+    });
+  }
+
+  // step opens the item before (-1) or after (1) the open one. Without an
+  // open item it starts at the top of the list.
+  function step(direction) {
+    const current = article();
+    if (current) {
+      openItem(direction > 0 ? current.dataset.nextId : current.dataset.prevId);
+      return;
+    }
+    const first = rows()[0];
+    if (first) {
+      openItem(first.dataset.itemId);
+    }
+  }
+
+  function stepFeed(direction) {
+    const links = Array.from(document.querySelectorAll("#sidebar [data-feed-link]"));
+    if (links.length === 0) {
+      return;
+    }
+    const current = links.findIndex((link) => link.hasAttribute("aria-current"));
+    const next = Math.min(Math.max(current + direction, 0), links.length - 1);
+    if (next !== current) {
+      links[next].click();
+    }
+  }
+
+  function click(id) {
+    const el = document.getElementById(id);
+    if (el) {
+      el.click();
+    }
+  }
+
+  function toggleHelp() {
+    const dialog = document.getElementById("shortcuts");
+    if (dialog.open) {
+      dialog.close();
+    } else {
+      dialog.showModal();
+    }
+  }
+
+  // Highlight the row of the open item whenever the list or pane changes
+  function markSelected() {
+    const current = article();
+    for (const row of rows()) {
+      const selected = current !== null && row.dataset.itemId === current.dataset.itemId;
+      row.toggleAttribute("aria-current", selected);
+      row.classList.toggle(selectedClasses[0], selected);
+      row.classList.toggle(selectedClasses[1], selected);
+      if (selected) {
+        row.scrollIntoView({ block: "nearest" });
+      }
+    }
+  }
+
+  const actions = {
+    j: () => step(1),
+    k: () => step(-1),
+    n: () => stepFeed(1),
+    p: () => stepFeed(-1),
+    o: () => {
+      const row = selectedRow() || rows()[0];
+      if (row) {
+        openItem(row.dataset.itemId);
+      }
+    },
+    m: () => click("toggle-read"),
+    s: () => click("toggle-star"),
+    v: () => {
+      const current = article();
+      if (current && isWebLink(current.dataset.link)) {
+        window.open(current.dataset.link, "_blank", "noopener");
+      }
+    },
+    A: () => {
+      if (window.confirm("Mark all items as read?")) {
+        htmx.ajax("POST", "/dashboard/items/read", {
+          target: "#items",
+          swap: "outerHTML",
+          values: filters(),
+        });
+      }
+    },
+    r: () => click("refresh"),
+    "/": () => {
+      const search = document.getElementById("search");
+      if (search) {
+        search.focus();
+        search.select();
+      }
+    },
+    "?": toggleHelp,
+  };
+
+  document.addEventListener("keydown", (event) => {
+    if (event.ctrlKey || event.metaKey || event.altKey || event.isComposing) {
+      return;
+    }
+    const target = event.target;
+    if (target.isContentEditable || target.matches("input:not([type=checkbox]), select, textarea")) {
+      if (event.key === "Escape") {
+        target.blur();
+      }
+      return;
+    }
+
+    const action = actions[event.key];
+    if (action) {
+      event.preventDefault();
+      action();
+    }
+  });
+
+  document.addEventListener("htmx:afterSettle", markSelected);
+  document.addEventListener("DOMContentLoaded", markSelected);
+})();
@@ -0,0 +1,36 @@
+// Keeps the unread counts and feed status of the dashboard current. Listens
+// to the event stream and refreshes the sidebar when items arrive or are read
+// elsewhere, and when a feed was fetched.
+(function () {
+  "use strict";
+
+  // Bursts of events, like a refresh of all feeds, end up as one request
+  const delay = 1000;
+  let timer = null;
+
+  function filters() {
+    const form = document.getElementById("filters");
+    return form ? Object.fromEntries(new FormData(form)) : {};
+  }
+
+  function refreshSidebar() {
+    timer = null;
+    // The sidebar is swapped out of band
+    htmx.ajax("GET", "/dashboard/sidebar", { swap: "none", values: filters() });
+  }
+
+  function schedule() {
+    if (timer === null) {
+      timer = setTimeout(refreshSidebar, delay);
+    }
+  }
+
+  if (!window.EventSource) {
+    return;
+  }
+  // The browser reconnects on its own when the stream ends
+  const source = new EventSource("/events");
+  source.addEventListener("items", schedule);
+  source.addEventListener("read", schedule);
+  source.addEventListener("feed", schedule);
+})();
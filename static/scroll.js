@@ -0,0 +1,44 @@
+// Marks items as read once they are scrolled past the top of the viewport.
+// Only loaded when the "mark read on scroll" setting is enabled.
+(function () {
+  "use strict";
+
+  function filters() {
+    const form = document.getElementById("filters");
+    return form ? Object.fromEntries(new FormData(form)) : {};
+  }
+
+  function markRead(row) {
+    const content = row.querySelector("[data-unread]");
+    if (!content) {
+      return;
+    }
+    // Drop the marker right away so the row isn't posted twice while the
+    // request is in flight. The response updates the row out of band.
+    content.removeAttribute("data-unread");
+    htmx.ajax("POST", "/dashboard/items/" + row.dataset.itemId + "/read", {
+      swap: "none",
+      values: Object.assign(filters(), { read: "1" }),
+    });
+  }
+
+  const observer = new IntersectionObserver((entries) => {
+    for (const entry of entries) {
+      if (!entry.isIntersecting && entry.boundingClientRect.bottom < (entry.rootBounds ? entry.rootBounds.top : 0)) {
+        markRead(entry.target);
+      }
+    }
+  });
+
+  // Rows are replaced by htmx, so observe whatever is in the list after
+  // every swap
+  function observeRows() {
+    observer.disconnect();
+    for (const row of document.querySelectorAll("#items li[data-item-id]")) {
+      observer.observe(row);
+    }
+  }
+
+  document.addEventListener("htmx:afterSettle", observeRows);
+  document.addEventListener("DOMContentLoaded", observeRows);
+})();
@@ -0,0 +1,7 @@
+// Package templates
+package templates
+
+import "embed"
+
+//go:embed layouts partials pages email
+var FS embed.FS
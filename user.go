@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/floriangaechter/rss/internal/api"
+	"github.com/floriangaechter/rss/internal/config"
+	"github.com/floriangaechter/rss/internal/store"
+)
+
+func runUser(args []string) error {
+	sub, args, err := subcommand("user", args, "create", "list", "delete", "reset-password", "reset-2fa", "promote", "demote")
+	if err != nil {
+		return err
+	}
+
+	var cfg config.Config
+	fs := newFlagSet("user "+sub, &cfg)
+	var passwordFlag string
+	if sub == "create" || sub == "reset-password" {
+		fs.StringVar(&passwordFlag, "password", "", "New password, prompted for on stdin when empty")
+	}
+	var adminFlag bool
+	var emailFlag string
+	if sub == "create" {
+		fs.BoolVar(&adminFlag, "admin", false, "Make the user an admin")
+		fs.StringVar(&emailFlag, "email", "", "Email address for password resets")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, closeApp, err := openApp(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	switch sub {
+	case "create":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("%w: rss user create [-admin] [-email <email>] [-password <password>] <username>", errUsage)
+		}
+		if err := api.ValidateUsername(fs.Arg(0)); err != nil {
+			return err
+		}
+		email := api.NormalizeEmail(emailFlag)
+		if err := api.ValidateEmail(email); err != nil {
+			return err
+		}
+		password, err := readPassword(passwordFlag)
+		if err != nil {
+			return err
+		}
+		if err := api.ValidatePassword(password, fs.Arg(0)); err != nil {
+			return err
+		}
+
+		user := &store.User{Username: fs.Arg(0), Email: email, IsAdmin: adminFlag}
+		if err := user.Password.Set(password); err != nil {
+			return err
+		}
+		if err := a.UserStore.CreateUser(user); err != nil {
+			return err
+		}
+		fmt.Printf("created user %q with id %d\n", user.Username, user.ID)
+		return nil
+
+	case "list":
+		users, err := a.UserStore.ListUsers()
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSERNAME\tADMIN\tFEEDS")
+		for _, user := range users {
+			feeds, err := a.FeedStore.GetFeedsByUserID(int64(user.ID))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%t\t%d\n", user.ID, user.Username, user.IsAdmin, len(feeds))
+		}
+		return tw.Flush()
+
+	case "delete":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("%w: rss user delete <username>", errUsage)
+		}
+		user, err := lookupUser(a, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		// Feeds, items and sessions go with the user via ON DELETE CASCADE
+		if err := a.UserStore.DeleteUser(user.ID); err != nil {
+			return err
+		}
+		fmt.Printf("deleted user %q\n", user.Username)
+		return nil
+
+	case "reset-password":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("%w: rss user reset-password [-password <password>] <username>", errUsage)
+		}
+		user, err := lookupUser(a, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		password, err := readPassword(passwordFlag)
+		if err != nil {
+			return err
+		}
+		if err := api.ValidatePassword(password, user.Username); err != nil {
+			return err
+		}
+
+		if err := user.Password.Set(password); err != nil {
+			return err
+		}
+		if err := a.UserStore.UpdateUser(user); err != nil {
+			return err
+		}
+		// Log out everywhere, the old password may have leaked
+		if err := a.SessionStore.DeleteUserSessions(user.ID); err != nil {
+			return err
+		}
+		fmt.Printf("reset password of user %q\n", user.Username)
+		return nil
+
+	case "reset-2fa":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("%w: rss user reset-2fa <username>", errUsage)
+		}
+		user, err := lookupUser(a, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		// For users who lost both their device and their recovery codes
+		err = a.TwoFactorStore.DisableTOTP(user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("two-factor authentication is not enabled for user %q", user.Username)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("disabled two-factor authentication of user %q\n", user.Username)
+		return nil
+
+	case "promote", "demote":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("%w: rss user %s <username>", errUsage, sub)
+		}
+		user, err := lookupUser(a, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		if err := a.UserStore.SetUserAdmin(user.ID, sub == "promote"); err != nil {
+			return err
+		}
+		if sub == "promote" {
+			fmt.Printf("user %q is an admin now\n", user.Username)
+		} else {
+			fmt.Printf("user %q is no admin anymore\n", user.Username)
+		}
+		return nil
+	}
+
+	return nil
+}